@@ -1,77 +1,178 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"github.com/Hrtnet/social-activities/internal/db"
 	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/mailer"
 	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/Hrtnet/social-activities/internal/storage"
+	"github.com/Hrtnet/social-activities/internal/uid"
+	"github.com/Hrtnet/social-activities/internal/webhook"
 	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
 	"os"
+	"strconv"
+	"sync"
 	"time"
-
-	"net/http"
 )
 
 type config struct {
-	port                            int
-	environment                     model.Environment
+	port        int
+	environment model.Environment
+
+	// blob key prefixes under which uploaded images are stored.
+	// Blobs under the incidence report prefixes are private
 	incidenceReportDrugImagePath    string
 	incidenceReportReceiptImagePath string
 	announcementImagePath           string
-	apiUrl                          string
-	dsn                             string
+
+	apiUrl string
+	dsn    string
+
+	// adminApiKey authorizes administrative endpoints, e.g., partner registration
+	adminApiKey string
+
+	incidenceReports struct {
+
+		// hourlyLimit and dailyLimit cap the incidence reports a user can submit
+		// within an hour and a day respectively. Non-positive values disable a limit
+		hourlyLimit int
+		dailyLimit  int
+
+		// duplicateWindow is how far back new reports are compared with
+		// previous reports for duplicate detection
+		duplicateWindow time.Duration
+	}
+
+	// uidLength and uidAlphabet shape the ids of new users, see uid.New
+	uidLength   int
+	uidAlphabet string
+
+	// dedupeUIDs, if set, is report or resolve: the users sharing a uid are listed, or resolved
+	// with db.Mongo.ResolveDuplicateUIDs, then the server exits without serving requests
+	dedupeUIDs string
+
+	// sessionTTL is how long the session credentials issued to users are valid
+	sessionTTL time.Duration
+
+	// legacySessionsUntil is when users who signed up before sessions were introduced, and have
+	// neither a wallet nor an email to prove who they are, can no longer claim their first session
+	legacySessionsUntil time.Time
+
+	// shutdownTimeout bounds the time spent completing requests and background work in flight
+	// once the server is asked to stop. Heroku kills dynos 30 seconds after asking them to stop
+	shutdownTimeout time.Duration
+
+	mail struct {
+
+		// driver is either smtp or file
+		driver string
+
+		// fileDir is the directory the file driver writes emails to
+		fileDir string
+		smtp    mailer.SMTPConfig
+	}
+
+	referrals struct {
+
+		// dailyLimit caps the users a referral code can bring in per day
+		// and rewardCap the referrals a user can be rewarded for.
+		// Non-positive values disable a limit
+		dailyLimit int
+		rewardCap  int
+
+		// what a referrer earns once an invitee completes a first drug validation
+		rewardPoints    int
+		rewardHrtTokens int
+	}
+
+	storage struct {
+
+		// driver is either local or s3
+		driver string
+
+		// localRoot is the directory the local driver stores blobs in
+		localRoot string
+
+		// urlSigningSecret signs urls granting temporary access to private blobs
+		urlSigningSecret string
+		s3               storage.S3Config
+	}
 }
 
 type app struct {
 	config          *config
 	repo            Repository
 	notificationHub *NotificationHub
-}
+	store           storage.BlobStore
+	urlSigner       *storage.Signer
+	webhooks        *webhook.Dispatcher
+	uids            *uid.Generator
+	mailer          mailer.Mailer
 
-// createDirs creates necessary file server directories with
-// the appropriate file permissions
-func createDirs(dirs ...string) {
-	for _, dir := range dirs {
-		os.MkdirAll(dir, 0700)
-	}
+	// backgroundTasks tracks the work that outlives the request that started it, see app.background
+	backgroundTasks sync.WaitGroup
 }
 
 func main() {
 
 	cfg := initConfig()
 	logger.Logger = logger.NewLogger(cfg.environment == model.Production)
-	createDirs(
-		cfg.announcementImagePath,
-		cfg.incidenceReportReceiptImagePath,
-		cfg.incidenceReportDrugImagePath)
 	model.InitializeFirebaseAdminSDK()
-	mongo, err := db.ConnectMongo(cfg.dsn)
+	uids, err := uid.New(cfg.uidAlphabet, cfg.uidLength)
+	if err != nil {
+		logger.Logger.LogFatal("invalid uid configuration", "", err)
+	}
+	mongo, err := db.ConnectMongo(cfg.dsn, uids)
 	if err != nil {
 		logger.Logger.LogFatal("error connecting to database", "", err)
 	}
+	if cfg.dedupeUIDs != "" {
+		err := dedupeUIDs(mongo, cfg.dedupeUIDs)
+		if err := mongo.Disconnect(); err != nil {
+			logger.Logger.LogError("failed to disconnect from database", "dedupe uids", err)
+		}
+		if err != nil {
+			logger.Logger.LogFatal("failed to dedupe uids", "dedupe uids", err)
+		}
+		logger.Logger.FlushBuffer()
+		return
+	}
+	urlSigner := storage.NewSigner(cfg.storage.urlSigningSecret)
+	store, err := newBlobStore(&cfg, urlSigner)
+	if err != nil {
+		logger.Logger.LogFatal("error initializing blob storage", "", err)
+	}
+	mail, err := newMailer(&cfg)
+	if err != nil {
+		logger.Logger.LogFatal("error initializing mailer", "", err)
+	}
 	app := &app{
-		config: &cfg,
-		repo:   mongo,
+		config:    &cfg,
+		repo:      mongo,
+		store:     store,
+		urlSigner: urlSigner,
+		uids:      uids,
+		mailer:    mail,
 	}
 	app.notificationHub = NewNotificationHub(mongo)
-	defer app.repo.Disconnect()
+	app.webhooks = webhook.NewDispatcher(mongo, nil)
 
-	app.serve()
-}
-
-func (app *app) serve() error {
-	server := &http.Server{
-		Addr:              fmt.Sprintf(":%d", app.config.port),
-		Handler:           app.routes(),
-		ReadHeaderTimeout: 10 * time.Second,
-		WriteTimeout:      20 * time.Second,
-		MaxHeaderBytes:    2048,
+	err = app.serve()
+	if err != nil {
+		logger.Logger.LogError("server stopped unexpectedly", "serve", err)
+	}
+	if err := app.repo.Disconnect(); err != nil {
+		logger.Logger.LogError("failed to disconnect from database", "shut down", err)
+	}
+	logger.Logger.FlushBuffer()
+	if err != nil {
+		os.Exit(1)
 	}
-
-	logger.Logger.LogInfo(
-		fmt.Sprintf("starting server in %s mode on port %d", app.config.environment.String(), app.config.port))
-	return server.ListenAndServe()
 }
 
 func initConfig() config {
@@ -80,6 +181,25 @@ func initConfig() config {
 	flag.IntVar(&config.port, "port", 4042, "port the server listens on")
 	flag.Var(&config.environment, "environment", "application environment, enum: development, production")
 	flag.StringVar(&config.apiUrl, "apiUrl", "localhost", "api endpoint")
+	flag.StringVar(&config.storage.driver, "storage", "local", "blob storage driver for uploaded files, enum: local, s3")
+	flag.IntVar(&config.incidenceReports.hourlyLimit, "reportsPerHour", 3, "incidence reports a user can submit per hour")
+	flag.IntVar(&config.incidenceReports.dailyLimit, "reportsPerDay", 10, "incidence reports a user can submit per day")
+	flag.DurationVar(&config.incidenceReports.duplicateWindow, "duplicateWindow", 7*24*time.Hour,
+		"how far back incidence reports are checked for duplicates")
+	flag.StringVar(&config.mail.driver, "mailer", "file", "how emails are sent, enum: smtp, file")
+	flag.DurationVar(&config.sessionTTL, "sessionTTL", 90*24*time.Hour, "how long user sessions are valid")
+	legacySessionsUntil := flag.String("legacySessionsUntil", "2027-01-31",
+		"date (YYYY-MM-DD) until which accounts without a wallet or an email can claim their first session")
+	flag.DurationVar(&config.shutdownTimeout, "shutdownTimeout", 25*time.Second,
+		"how long work in flight is waited for when the server stops")
+	flag.IntVar(&config.uidLength, "uidLength", uid.DefaultLength, "random characters of new user ids, check digit excluded")
+	flag.StringVar(&config.uidAlphabet, "uidAlphabet", uid.DefaultAlphabet, "characters new user ids are made of")
+	flag.StringVar(&config.dedupeUIDs, "dedupeUIDs", "",
+		"list, or resolve, the users sharing a uid then exit instead of serving requests, enum: report, resolve")
+	flag.IntVar(&config.referrals.dailyLimit, "referralsPerDay", 20, "users a referral code can bring in per day")
+	flag.IntVar(&config.referrals.rewardCap, "referralRewardCap", 50, "referrals a user can be rewarded for")
+	flag.IntVar(&config.referrals.rewardPoints, "referralPoints", 50, "points earned per rewarded referral")
+	flag.IntVar(&config.referrals.rewardHrtTokens, "referralHrtTokens", 5, "HRT tokens earned per rewarded referral")
 	flag.Parse()
 
 	if config.environment == model.Development {
@@ -87,10 +207,104 @@ func initConfig() config {
 			logger.Logger.LogFatal("failed to load env file", "initializing app config", err)
 		}
 	}
+	var err error
+	if config.legacySessionsUntil, err = time.Parse("2006-01-02", *legacySessionsUntil); err != nil {
+		logger.Logger.LogFatal("invalid legacySessionsUntil flag", "initializing app config", err)
+	}
 	config.dsn = os.Getenv("DSN")
-	config.incidenceReportDrugImagePath = "./res/images/incidence-reports/drugs"
-	config.incidenceReportReceiptImagePath = "./res/images/incidence-reports/receipts"
-	config.announcementImagePath = "./res/images/announcements"
+	config.adminApiKey = os.Getenv("ADMIN_API_KEY")
+	config.incidenceReportDrugImagePath = "incidence-reports/drugs"
+	config.incidenceReportReceiptImagePath = "incidence-reports/receipts"
+	config.announcementImagePath = "announcements"
+
+	config.mail.fileDir = "./res/mail"
+	config.mail.smtp = mailer.SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	config.mail.smtp.Port, _ = strconv.Atoi(os.Getenv("SMTP_PORT"))
+
+	config.storage.localRoot = "./res/images"
+	config.storage.urlSigningSecret = os.Getenv("URL_SIGNING_SECRET")
+	config.storage.s3 = storage.S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		Region:          os.Getenv("S3_REGION"),
+		UseSSL:          os.Getenv("S3_USE_SSL") != "false",
+		PublicBaseUrl:   os.Getenv("S3_PUBLIC_BASE_URL"),
+		PublicPrefixes:  []string{config.announcementImagePath + "/"},
+	}
+	if config.storage.urlSigningSecret == "" {
+		if config.environment == model.Production {
+			logger.Logger.LogFatal("missing URL_SIGNING_SECRET", "initializing app config",
+				errors.New("URL_SIGNING_SECRET must be set in production"))
+		}
+
+		// signed urls issued in development become invalid on restart
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		config.storage.urlSigningSecret = hex.EncodeToString(secret)
+	}
 
 	return config
 }
+
+// newBlobStore creates the storage.BlobStore selected by cfg.storage.driver
+func newBlobStore(cfg *config, signer *storage.Signer) (storage.BlobStore, error) {
+	switch cfg.storage.driver {
+	case "s3":
+		return storage.NewS3Store(cfg.storage.s3)
+	case "local":
+		return storage.NewLocalStore(cfg.storage.localRoot,
+			fmt.Sprintf("%s/api/res/images", cfg.apiUrl), signer)
+	default:
+		return nil, fmt.Errorf("unrecognised storage driver %s", cfg.storage.driver)
+	}
+}
+
+// dedupeUIDs lists the users sharing a uid if mode is report, or resolves them if mode is resolve,
+// see db.Mongo.ResolveDuplicateUIDs
+func dedupeUIDs(mongo *db.Mongo, mode string) error {
+	var duplicates []db.DuplicateUID
+	var err error
+	switch mode {
+	case "report":
+		duplicates, err = mongo.FindDuplicateUIDs()
+	case "resolve":
+		duplicates, err = mongo.ResolveDuplicateUIDs()
+	default:
+		return fmt.Errorf("unrecognised dedupeUIDs mode %s", mode)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, duplicate := range duplicates {
+		logger.Logger.LogInfo(fmt.Sprintf("uid %s is shared by users %v, resolving keeps %s",
+			duplicate.UID, duplicate.UserIDs, duplicate.UserIDs[0].Hex()))
+	}
+	if mode == "resolve" {
+		logger.Logger.LogInfo(fmt.Sprintf("%d duplicate uids resolved, the users not kept were moved to "+
+			"the duplicateUsers collection and the unique index on uid was created", len(duplicates)))
+	} else {
+		logger.Logger.LogInfo(fmt.Sprintf("%d duplicate uids found, run with -dedupeUIDs=resolve to resolve them",
+			len(duplicates)))
+	}
+	return nil
+}
+
+// newMailer creates the mailer.Mailer selected by cfg.mail.driver
+func newMailer(cfg *config) (mailer.Mailer, error) {
+	switch cfg.mail.driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.mail.smtp)
+	case "file":
+		return mailer.NewFileMailer(cfg.mail.fileDir, "HeartNet <no-reply@localhost>")
+	default:
+		return nil, fmt.Errorf("unrecognised mail driver %s", cfg.mail.driver)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"net/http"
+)
+
+type contextKey string
+
+const (
+	partnerContextKey = contextKey("partner")
+	sessionContextKey = contextKey("session")
+)
+
+// contextSetPartner returns a copy of r with partner attached to its context
+func contextSetPartner(r *http.Request, partner *model.Partner) *http.Request {
+	ctx := context.WithValue(r.Context(), partnerContextKey, partner)
+	return r.WithContext(ctx)
+}
+
+// contextGetPartner retrieves the partner attached to r's context.
+// contextGetPartner must only be invoked in handlers wrapped by app.requirePartner
+func contextGetPartner(r *http.Request) *model.Partner {
+	partner, ok := r.Context().Value(partnerContextKey).(*model.Partner)
+	if !ok {
+		panic("missing partner value in request context")
+	}
+	return partner
+}
+
+// contextSetSession returns a copy of r with the user's session attached to its context
+func contextSetSession(r *http.Request, session *model.Session) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, session)
+	return r.WithContext(ctx)
+}
+
+// contextGetSession retrieves the user's session attached to r's context.
+// contextGetSession must only be invoked in handlers wrapped by app.requireUser
+func contextGetSession(r *http.Request) *model.Session {
+	session, ok := r.Context().Value(sessionContextKey).(*model.Session)
+	if !ok {
+		panic("missing session value in request context")
+	}
+	return session
+}
@@ -63,3 +63,24 @@ func (app *app) sendFailedValidationResponse(w http.ResponseWriter, r *http.Requ
 func (app *app) sendEditConflictResponse(w http.ResponseWriter, r *http.Request, message string) {
 	app.sendErrorResponse(w, r, http.StatusConflict, message, nil)
 }
+
+// sendUnauthorizedResponse sends a 401 Unauthorized status code
+// and JSON response to the client.
+func (app *app) sendUnauthorizedResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication credentials"
+	app.sendErrorResponse(w, r, http.StatusUnauthorized, message, nil)
+}
+
+// sendForbiddenResponse sends a 403 Forbidden status code
+// and JSON response to the client.
+func (app *app) sendForbiddenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you do not have permission to access this resource"
+	app.sendErrorResponse(w, r, http.StatusForbidden, message, nil)
+}
+
+// sendRateLimitExceededResponse sends a 429 Too Many Requests status code
+// and JSON response to the client.
+func (app *app) sendRateLimitExceededResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.sendErrorResponse(w, r, http.StatusTooManyRequests, message, nil)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	// public images are stored under keys that embed their upload time,
+	// so their content never changes and can be cached indefinitely
+	publicImageCacheControl = "public, max-age=31536000, immutable"
+
+	// private images must not be kept by shared caches, and must not outlive
+	// the signed url that granted access to them
+	privateImageCacheControl = "private, max-age=900"
+)
+
+// serveImages streams images stored in app.store.
+// Range requests and conditional requests (If-None-Match, If-Modified-Since)
+// are supported.
+//
+// Images under the incidence report key prefixes are private, and are only served if
+// the request carries one of:
+//		a url signature issued by app.urlSigner (see app.resolveIncidenceReportUrls)
+//		the api key of a partner assigned to the report that owns the image
+//		the session token of the reporter who owns the image
+func (app *app) serveImages(w http.ResponseWriter, r *http.Request) {
+	key, ok := cleanBlobKey(chi.URLParam(r, "*"))
+	if !ok {
+		app.sendNotFoundResponse(w, r)
+		return
+	}
+
+	private := app.isPrivateBlob(key)
+	if private {
+		allowed, err := app.canAccessPrivateBlob(r, key)
+		if err != nil {
+			if err == db.ErrIncidenceReportNotFound {
+				app.sendNotFoundResponse(w, r)
+				return
+			}
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+		if !allowed {
+			app.sendForbiddenResponse(w, r)
+			return
+		}
+	}
+
+	object, info, err := app.store.Get(r.Context(), key)
+	if err != nil {
+		if err == storage.ErrNotFound || err == storage.ErrInvalidKey {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r,
+			errors.Wrap(err, fmt.Sprintf("failed to open file %s", key)))
+		return
+	}
+	defer object.Close()
+
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Content-Type must be overwritten since a json Content-Type is set for every response by the router
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if info.ETag != "" {
+		w.Header().Set("ETag", fmt.Sprintf("%q", info.ETag))
+	}
+	if private {
+		w.Header().Set("Cache-Control", privateImageCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", publicImageCacheControl)
+	}
+
+	// ServeContent handles Range, If-Range, If-None-Match and If-Modified-Since,
+	// and streams object rather than loading it into memory
+	http.ServeContent(w, r, path.Base(key), info.ModTime, object)
+}
+
+// canAccessPrivateBlob reports if r is authorized to read the private blob identified by key.
+// Returns db.ErrIncidenceReportNotFound if no incidence report owns the blob
+func (app *app) canAccessPrivateBlob(r *http.Request, key string) (bool, error) {
+	if err := app.urlSigner.Verify(key, r.URL.Query()); err == nil {
+		return true, nil
+	}
+
+	report, err := app.repo.FetchIncidenceReportByImageKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	session, err := app.authenticateUser(r)
+	if err != nil {
+		return false, err
+	}
+	if session != nil && session.UserID == report.UserID {
+		return true, nil
+	}
+
+	partner, err := app.authenticatePartner(r)
+	if err != nil {
+		return false, err
+	}
+	return partner != nil && report.IsAssignedTo(partner.ID), nil
+}
+
+// isPrivateBlob reports if the blob identified by key is private, see app.serveImages
+func (app *app) isPrivateBlob(key string) bool {
+	return strings.HasPrefix(key, app.config.incidenceReportDrugImagePath+"/") ||
+		strings.HasPrefix(key, app.config.incidenceReportReceiptImagePath+"/")
+}
+
+// cleanBlobKey normalises a blob key taken from a request path.
+// ok is false if key is empty or attempts to escape the image root, e.g., ../../etc/passwd
+func cleanBlobKey(key string) (cleaned string, ok bool) {
+	if key == "" || strings.Contains(key, "\x00") || strings.Contains(key, "\\") {
+		return "", false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	cleaned = strings.TrimPrefix(path.Clean("/"+key), "/")
+	return cleaned, cleaned != "" && cleaned != "."
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"github.com/skip2/go-qrcode"
-	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
 	"strings"
 	"time"
@@ -25,7 +25,7 @@ import (
 //		text string required (not more than 150 characters)
 //		title string required (not more than 30 characters)
 //		url string
-//		image multipartfile (Content-Type file/image, file must not be greater than 5mb)
+//		image multipartfile (jpeg, png or webp image not greater than 5mb)
 func (app *app) submitAnnouncement(w http.ResponseWriter, r *http.Request) {
 
 	// todo protect endpoint with admin key
@@ -36,7 +36,7 @@ func (app *app) submitAnnouncement(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	announcement, errorType, err := extractAnnouncement(r, app.config.announcementImagePath, app.config.apiUrl)
+	announcement, errorType, err := extractAnnouncement(r, app.store, app.config.announcementImagePath)
 	if err != nil {
 		if errorType == db.InternalError {
 			app.sendServerErrorResponse(w, r, err)
@@ -52,15 +52,18 @@ func (app *app) submitAnnouncement(w http.ResponseWriter, r *http.Request) {
 		errs := make(map[string]string)
 		for _, err := range err.(validator.ValidationErrors) {
 			errs[err.Field()] = fmt.Sprintf("%v is not a valid value for %s", err.Value(), err.Field())
+			deleteBlobs(app.store, announcement.ImageUrl, announcement.ThumbnailUrl)
 			app.sendFailedValidationResponse(w, r, errs)
 			return
 		}
 	}
 
 	if err := app.repo.InsertAnnouncement(announcement); err != nil {
+		deleteBlobs(app.store, announcement.ImageUrl, announcement.ThumbnailUrl)
 		app.sendServerErrorResponse(w, r, errors.Wrap(err, "error inserting announcement"))
 		return
 	}
+	app.resolveAnnouncementUrls(announcement)
 
 	app.sendAPIResponse(&responseWriterArgs{
 		writer:     w,
@@ -88,6 +91,9 @@ func (app *app) serveAnnouncements(w http.ResponseWriter, r *http.Request) {
 		app.sendBadRequestResponse(w, r, err)
 		return
 	}
+	for i := range *announcements {
+		app.resolveAnnouncementUrls(&(*announcements)[i])
+	}
 
 	app.sendAPIResponse(&responseWriterArgs{
 		writer:     w,
@@ -97,15 +103,19 @@ func (app *app) serveAnnouncements(w http.ResponseWriter, r *http.Request) {
 	}, r, announcements)
 }
 
-// submitIncidenceReportStatus
+// submitIncidenceReportStatus records a partner's update on an incidence report
+// and optionally moves the report to a new state of the investigation workflow
+// (see model.IncidenceReportState). The reporter is notified of every update.
+// Only partners assigned to the report by an admin can update it, see assignIncidenceReportPartners.
 // Method: POST
+// Request must contain partner authorization
 // Request Body:
 // 		parent_id mongodb valid id required
 //		message string required
-//		sent_by string required
+//		state string (one of new, under_investigation, confirmed_counterfeit, dismissed)
+//		images []string
 func (app *app) submitIncidenceReportStatus(w http.ResponseWriter, r *http.Request) {
-	// todo protect endpoint with partner key
-
+	partner := contextGetPartner(r)
 	update := new(model.IncidenceReportUpdate)
 
 	err := app.readJSON(w, r, &update)
@@ -113,6 +123,9 @@ func (app *app) submitIncidenceReportStatus(w http.ResponseWriter, r *http.Reque
 		app.sendBadRequestResponse(w, r, err)
 		return
 	}
+	update.ID = primitive.NewObjectID()
+	update.SentBy = partner.Name
+	update.SentOn = time.Now()
 
 	validate := validator.New()
 	if err := validate.Struct(update); err != nil {
@@ -123,51 +136,96 @@ func (app *app) submitIncidenceReportStatus(w http.ResponseWriter, r *http.Reque
 			return
 		}
 	}
+	if update.State != "" && !update.State.IsValid() {
+		app.sendFailedValidationResponse(w, r, map[string]string{"state": "unknown incidence report state"})
+		return
+	}
 
-	// todo optimize. use https://www.mongodb.com/docs/manual/reference/operator/aggregation/lookup/#definition
-	uid, err := app.repo.FetchIncidenceReporterUID(update.IncidenceReportID)
+	report, err := app.repo.FetchIncidenceReport(update.IncidenceReportID)
 	if err != nil {
-		if err != mongo.ErrNoDocuments {
-			app.sendEditConflictResponse(w, r, "incidence report not found")
+		if err == db.ErrIncidenceReportNotFound {
+			app.sendNotFoundResponse(w, r)
 			return
 		}
 		app.sendServerErrorResponse(w, r, err)
 		return
 	}
+	if !report.IsAssignedTo(partner.ID) {
+		app.sendForbiddenResponse(w, r)
+		return
+	}
 
-	token, err := app.repo.FetchNotificationTokenByUserID(uid)
-	if err != nil {
-		if err != mongo.ErrNoDocuments {
-			app.sendAPIResponse(&responseWriterArgs{
-				writer:     w,
-				statusCode: 200,
-				status:     true,
-				message:    "Thanks for submitting this incidence report status update",
-			}, r, nil)
+	var transition *model.IncidenceReportTransition
+	current := report.CurrentState()
+	if update.State != "" && update.State != current {
+		if !current.CanTransitionTo(update.State) {
+			app.sendFailedValidationResponse(w, r, map[string]string{
+				"state": fmt.Sprintf("report cannot move from %s to %s", current, update.State),
+			})
 			return
 		}
-		app.sendServerErrorResponse(w, r, err)
-		return
+		transition = &model.IncidenceReportTransition{
+			From:    current,
+			To:      update.State,
+			Actor:   partner.Name,
+			ActorID: partner.ID,
+			At:      update.SentOn,
+		}
 	}
 
-	model.PushNotification{
-		Notification: messaging.Notification{
-			Title: "Incidence report update",
-			Body:  update.Message,
-		},
-	}.SendToUser(token)
-
-	err = app.repo.InsertIncidenceReportUpdate(update)
+	err = app.repo.InsertIncidenceReportUpdate(update, partner.ID, transition)
 	if err != nil {
-		app.sendServerErrorResponse(w, r, err)
+		switch err {
+		case db.ErrEditConflict:
+			app.sendEditConflictResponse(w, r,
+				"incidence report was updated by another partner, fetch the report and retry")
+		case db.ErrIncidenceReportNotFound:
+			app.sendNotFoundResponse(w, r)
+		default:
+			app.sendServerErrorResponse(w, r, err)
+		}
 		return
 	}
+
 	app.sendAPIResponse(&responseWriterArgs{
 		writer:     w,
 		statusCode: 200,
 		status:     true,
 		message:    "Thanks for submitting this incidence report status update",
 	}, r, nil)
+
+	if transition != nil {
+		if transition.To == model.ReportConfirmedCounterfeit {
+			app.recordLeaderboardScore(report.UserID, model.MetricConfirmedReports, 1, transition.At)
+		}
+		app.notificationHub.Dispatch(model.NewIncidenceReportStateNotification(report.UserID, transition.To))
+		app.webhooks.PublishAsync(model.EventIncidenceReportStateChanged, map[string]interface{}{
+			"incidence_report_id": report.ID,
+			"transition":          transition,
+		})
+	}
+	app.pushToUser(report.UserID, messaging.Notification{
+		Title: "Incidence report update",
+		Body:  update.Message,
+	})
+}
+
+// pushToUser sends a push notification to the device of the user identified by uid.
+// Failures are logged since users without a push notification token
+// still receive notifications through the NotificationHub
+func (app *app) pushToUser(uid string, notification messaging.Notification) {
+	token, err := app.repo.FetchNotificationTokenByUserID(uid)
+	if err != nil {
+		logger.Logger.LogError(fmt.Sprintf("failed to fetch push notification token for user %s", uid),
+			"push to user", err)
+		return
+	}
+	if token == "" {
+		return
+	}
+	app.background(func() {
+		model.PushNotification{Notification: notification}.SendToUser(token)
+	})
 }
 
 // sendRewardsAlert mocks sending rewards alert to user
@@ -242,8 +300,15 @@ func (app *app) submitContactUsMessage(w http.ResponseWriter, r *http.Request) {
 	}, r, nil)
 }
 
+// serveUserInfo serves the user's profile.
+// METHOD: GET
+// Request must contain the user's session token
+// URL parameter: uid
 func (app *app) serveUserInfo(w http.ResponseWriter, r *http.Request) {
 	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
 
 	user, err := app.repo.FetchUser(uid)
 	if err != nil {
@@ -268,67 +333,32 @@ func (app *app) serveUserInfo(w http.ResponseWriter, r *http.Request) {
 	}, r, user)
 }
 
-// updateUser
-// Method: POST
-// Request Body:
-//		wallet_addr string
-//		dob time.Time
-// 		email string
-// 		user_id string *required
-func (app *app) updateUser(w http.ResponseWriter, r *http.Request) {
-	var user model.User
-	err := app.readJSON(w, r, &user)
-	if err != nil {
-		app.sendBadRequestResponse(w, r, err)
-		return
-	}
-
-	if user.UID == "" {
-		app.sendBadRequestResponse(w, r, errors.New("missing UID"))
-		return
-	}
-
-	if err := app.repo.UpdateUser(&user); err != nil {
-		app.sendServerErrorResponse(w, r, err)
-		return
-	}
-
-	app.sendAPIResponse(&responseWriterArgs{
-		writer:     w,
-		statusCode: 200,
-		status:     true,
-		message:    "User update successful",
-	}, r, nil)
-}
-
-// serveAllAirdropSubmission serves activities statistics
-// METHOD: GET
-// Request must contain admin authorization
-func (app *app) serveAllAirdropSubmission(w http.ResponseWriter, r *http.Request) {
-	reports, err := app.repo.FetchAllAirdropSubmissions()
-	if err != nil {
-		app.sendServerErrorResponse(w, r, err)
-		return
-	}
-
-	app.sendAPIResponse(&responseWriterArgs{
-		writer:     w,
-		statusCode: 200,
-		status:     true,
-		message:    "All participants' reports",
-	}, r, reports)
-	return
-}
-
 // serveAirdropSubmission
 // returns the task report submitted by user identified by
 // user_id in query parameter
 // METHOD: GET
-// Query parameter: user_id
+// Query parameters:
+//		user_id string *required
+//		campaign_id string (defaults to the user's latest submission)
 func (app *app) serveAirdropSubmission(w http.ResponseWriter, r *http.Request) {
-	userId := r.URL.Query().Get("user_id")
-	report, err := app.repo.FetchAirdropSubmissionByUserID(userId)
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+	userId := qs.Get("user_id")
+	campaignId := primitive.NilObjectID
+	if id := readQueryObjectID(qs, "campaign_id", errs); id != nil {
+		campaignId = *id
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	report, err := app.repo.FetchAirdropSubmission(userId, campaignId)
 	if err != nil {
+		if err == db.ErrNoSubmissionFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
 		app.sendServerErrorResponse(w, r, err)
 		return
 	}
@@ -345,11 +375,14 @@ func (app *app) serveAirdropSubmission(w http.ResponseWriter, r *http.Request) {
 // submitAirdropForm
 // METHOD: POST
 // Accept: application/json
+// Request Header: X-Device-ID (optional, identifies the device for sybil detection)
 // Request Body fields
+//			campaign_id string (required unless exactly one campaign is running, see GET /api/campaigns)
 // 			telegram_username string *required
 //			twitter_username string *required
-//			tweet_link string *required
-// 			wallet_address string (must be present if email_address is absent
+//			tweet_link string *required (a link to a tweet by twitter_username on twitter.com or x.com)
+//			youtube_username string
+// 			wallet_address string (the wallet linked to the user's account, must be present if email_address is absent
 //			email_address string (must be present if wallet_address is absent
 // 			user_id string *required
 func (app *app) submitAirdropForm(w http.ResponseWriter, r *http.Request) {
@@ -369,27 +402,70 @@ func (app *app) submitAirdropForm(w http.ResponseWriter, r *http.Request) {
 	// verify that user exist
 	user, err := app.repo.FetchUserInfo(report.UserID)
 	if err != nil {
-		if err == db.ErrUserNotFound || user.UID == "" {
+		if err == db.ErrUserNotFound {
 			app.sendBadRequestResponse(w, r, errors.New("UID missing"))
 			return
 		}
+		app.sendServerErrorResponse(w, r, err)
+		return
 	}
 
-	// verify that user hasn't made any previous submission
-	submission, err := app.repo.FetchAirdropSubmissionByUserID(user.UID)
+	// rewards are paid to the wallet, so it must be one the user proved they own
+	if report.WalletAddress != "" && (user.WalletVerifiedOn == nil || user.WalletAddress != report.WalletAddress) {
+		app.sendFailedValidationResponse(w, r, map[string]string{
+			"wallet_address": "must be the wallet linked to your account, see POST /api/users/{uid}/wallet/challenge",
+		})
+		return
+	}
+
+	campaign, errs, err := app.submissionCampaign(report.CampaignID, report.SubmittedOn)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	if errs != nil {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+	report.CampaignID = campaign.ID
+	report.IPAddress = clientIP(r)
+	report.DeviceID = deviceID(r)
+
+	// verify that user hasn't made any previous submission to the campaign
+	submission, err := app.repo.FetchAirdropSubmission(user.UID, campaign.ID)
 	if err != nil && err != db.ErrNoSubmissionFound {
 		app.sendServerErrorResponse(w, r, err)
 		return
 	}
 	if submission != nil {
-		app.sendEditConflictResponse(w, r, "airdrop participation already recorded")
+		app.sendEditConflictResponse(w, r, db.ErrDuplicateAirdropSubmission.Error())
+		return
+	}
+
+	related, errs, err := app.screenAirdropSubmission(&report)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	if errs != nil {
+		app.sendFailedValidationResponse(w, r, errs)
 		return
 	}
 
 	if err = app.repo.InsertAirdropSubmission(&report); err != nil {
+		if err == db.ErrDuplicateAirdropSubmission || err == db.ErrAirdropIdentifierInUse {
+			app.sendEditConflictResponse(w, r, err.Error())
+			return
+		}
 		app.sendServerErrorResponse(w, r, err)
 		return
 	}
+	if len(related) > 0 {
+		err := app.repo.FlagAirdropSubmissions(related, report.ID, report.Fraud.Score, report.Fraud.Reasons)
+		if err != nil {
+			logger.Logger.LogError("failed to flag related airdrop submissions", "submit airdrop form", err)
+		}
+	}
 
 	app.notificationHub.Dispatch(model.NewTaskReportNotification(report.UserID))
 	app.sendAPIResponse(&responseWriterArgs{
@@ -424,7 +500,11 @@ func (app *app) validateQrCode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	drug, err := app.repo.ValidateQrText(in.Data)
-	app.processValidation(w, r, drug, in.UserID, err)
+	app.processValidation(w, r, drug, &model.Scan{
+		UserID:           in.UserID,
+		ValidationOption: model.QrCode,
+		ValidationData:   in.Data,
+	}, err)
 }
 
 // validateShortCode
@@ -450,10 +530,14 @@ func (app *app) validateShortCode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	drug, err := app.repo.ValidateShortCode(in.Data)
-	app.processValidation(w, r, drug, in.UserID, err)
+	app.processValidation(w, r, drug, &model.Scan{
+		UserID:           in.UserID,
+		ValidationOption: model.ShortCode,
+		ValidationData:   in.Data,
+	}, err)
 }
 
-// validateShortCode
+// validateRFIDText
 // Method: POST
 // Accept application/json
 // Request Body Fields
@@ -475,8 +559,12 @@ func (app *app) validateRFIDText(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	drug, err := app.repo.ValidateShortCode(in.Data)
-	app.processValidation(w, r, drug, in.UserID, err)
+	drug, err := app.repo.ValidateRFIDText(in.Data)
+	app.processValidation(w, r, drug, &model.Scan{
+		UserID:           in.UserID,
+		ValidationOption: model.RFID,
+		ValidationData:   in.Data,
+	}, err)
 }
 
 // serveQrCode serves a single QrCode instance to client
@@ -503,23 +591,78 @@ func (app *app) serveQrCode(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// serveStarterPack serves new user with userId and a wallet address
+// serveStarterPack serves new user with userId, their referral code and their session credentials.
+// The session token authenticates the user's requests, e.g., to serveWalletAddress,
+// and is only ever returned in this response
 // METHOD: GET
 // Content-Type: application/json
+// Request Header: X-Device-ID (optional, identifies the device for referral abuse detection)
+// Query param: referral_code string (the code of the user who invited the new user)
 func (app *app) serveStarterPack(w http.ResponseWriter, r *http.Request) {
+	var referrer *model.User
+	code := model.NormaliseReferralCode(r.URL.Query().Get("referral_code"))
+	if code != "" {
+		var err error
+		if referrer, err = app.referrer(code); err != nil {
+			switch err {
+			case db.ErrReferralCodeNotFound:
+				app.sendFailedValidationResponse(w, r, map[string]string{"referral_code": err.Error()})
+			case errTooManyReferrals:
+				app.sendRateLimitExceededResponse(w, r, err.Error())
+			default:
+				app.sendServerErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
 	userId, err := app.repo.GenerateNewUserID()
 	if err != nil {
 		app.sendServerErrorResponse(w, r, err)
 		return
 	}
 
+	if err := app.repo.ClaimFirstSession(userId, time.Now()); err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	token, session, err := app.issueSession(userId)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	// users without a code get one when they list their referrals
+	referralCode, err := app.repo.AssignReferralCode(userId)
+	if err != nil {
+		logger.Logger.LogError("failed to assign referral code", "serve starter pack", err)
+	}
+	if referrer != nil {
+		err := app.repo.InsertReferral(&model.Referral{
+			ReferrerID: referrer.UID,
+			InviteeID:  userId,
+			Code:       code,
+			Status:     model.ReferralPending,
+			IPAddress:  clientIP(r),
+			DeviceID:   deviceID(r),
+			CreatedOn:  time.Now(),
+		})
+		if err != nil {
+			logger.Logger.LogError(fmt.Sprintf("failed to record referral of %s by %s", userId, referrer.UID),
+				"serve starter pack", err)
+		}
+	}
+
 	app.sendAPIResponse(&responseWriterArgs{
 		writer:     w,
 		statusCode: 200,
 		status:     true,
 		message:    "Welcome to HeartNet",
 	}, r, map[string]interface{}{
-		"user_id": userId,
+		"user_id":            userId,
+		"referral_code":      referralCode,
+		"session_token":      token,
+		"session_expires_on": session.ExpiresOn,
 	})
 	app.notificationHub.Dispatch(model.NewWelcomeNotification(userId))
 	return
@@ -534,9 +677,10 @@ func (app *app) checkStatus(w http.ResponseWriter, r *http.Request) {
 	}, r, nil)
 }
 
-// serveStarterPack serves returning user with their existing wallet address
+// serveWalletAddress serves returning user with their existing wallet address
 // METHOD: GET
 // Content-Type: application/json
+// Request must contain the user's session token
 // Query param: user_id string *required
 func (app *app) serveWalletAddress(w http.ResponseWriter, r *http.Request) {
 	userId := r.URL.Query().Get("user_id")
@@ -545,6 +689,18 @@ func (app *app) serveWalletAddress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// users type their uid in, a wrong check digit means a typo rather than an unknown user.
+	// uids of other lengths predate check digits
+	if len(userId) == app.uids.Length() && !app.uids.Valid(userId) {
+		app.sendFailedValidationResponse(w, r, map[string]string{
+			"user_id": "invalid user id, check it for typos",
+		})
+		return
+	}
+	if !app.sessionOwns(w, r, userId) {
+		return
+	}
+
 	user, err := app.repo.FetchUserInfo(userId)
 	if err == db.ErrUserNotFound {
 		app.sendAPIResponse(&responseWriterArgs{
@@ -572,20 +728,36 @@ var errFileTooLarge = errors.New("file too large")
 
 // submitIncidenceReport
 // METHOD: POST
+// Request must contain the reporter's session token
 // Content-type: multipart/form-data
 // Request Body:
-//		user_id string *required
-//		pharmacy_name string *required
+//		pharmacy_id string (id of a pharmacy in the registry, see GET /api/pharmacies)
+//		pharmacy_name string *required unless pharmacy_id is set
 //		description string *required
-//		pharmacy_location string *required
-// 		evidence_images multipartfile (Content-Type: application/png, Content-Encoding: zip) *required
-//		receipt multipartfile (Content-Type file/image)
+//		pharmacy_location string *required unless pharmacy_id is set
+//		latitude float, longitude float (where the incidence happened)
+//		drug_name string
+//		batch_number string
+//		report_token string (issued by the drug validation endpoints, links the report to the scan)
+// 		evidence_images multipartfile (a zip of images, or one part per image) *required
+//		receipt multipartfile (jpeg, png or webp image) *required
 //
-// Since there's currently no cap on the number of evidence_images that can be
-// attached to the request, all evidence_images must be zipped (i.e compressed as zip)
+// Not more than 10 evidence_images, of not more than 5mb each, can be attached to the request,
+// either zipped into a single part or as one part per image.
+// If anything fails, every image saved while handling the request is deleted.
+// Uploaded images are sniffed for their actual format, stripped of metadata (including
+// EXIF location data) and stored as a display version and a thumbnail.
+// Reports without a pharmacy_id are linked to the named pharmacy, which is suggested to the
+// registry once the report is stored if it isn't known.
+// Reports are filed under the user the session belongs to, who can only submit a limited number
+// of reports per hour and per day.
+// Resubmitting the images of a recent report is rejected, while reports that look like
+// duplicates of recent reports are accepted but flagged for moderation.
 func (app *app) submitIncidenceReport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 
-	// Max memory::32 MB
+	// Max memory::32 MB, larger uploads are spooled to temp files
+	// which are removed by net/http once the request is handled
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
 		app.sendBadRequestResponse(w, r, err)
 		return
@@ -593,6 +765,10 @@ func (app *app) submitIncidenceReport(w http.ResponseWriter, r *http.Request) {
 
 	report, errorType, err := app.extractIncidenceReport(r, app.config)
 	if err != nil {
+		if err == errTooManyIncidenceReports {
+			app.sendRateLimitExceededResponse(w, r, err.Error())
+			return
+		}
 		if errorType == db.InternalError {
 			app.sendServerErrorResponse(w, r, err)
 			return
@@ -601,10 +777,24 @@ func (app *app) submitIncidenceReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := app.moderateIncidenceReport(report); err != nil {
+		deleteBlobs(app.store, report.ImageKeys()...)
+		if err == errDuplicateIncidenceReport {
+			app.sendEditConflictResponse(w, r, err.Error())
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
 	if err := app.repo.SubmitIncidenceReport(report); err != nil {
+		deleteBlobs(app.store, report.ImageKeys()...)
 		app.sendServerErrorResponse(w, r, errors.Wrap(err, "error submitting incidence report"))
 		return
 	}
+	if report.PharmacyID == nil {
+		app.suggestPharmacy(report)
+	}
 
 	app.sendAPIResponse(&responseWriterArgs{
 		writer:     w,
@@ -614,6 +804,7 @@ func (app *app) submitIncidenceReport(w http.ResponseWriter, r *http.Request) {
 			"Our investigation partners will look into your report swiftly",
 	}, r, nil)
 	app.notificationHub.Dispatch(model.NewIncidenceReportNotification(report.UserID))
+	app.webhooks.PublishAsync(model.EventIncidenceReportCreated, incidenceReportEventData(report))
 }
 
 var wsUpgrader = websocket.Upgrader{
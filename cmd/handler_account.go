@@ -0,0 +1,589 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/mailer"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/Hrtnet/social-activities/internal/wallet"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+const (
+	// oneTimeCodeExpiry is how long a user has to answer an emailed one-time code
+	oneTimeCodeExpiry = 10 * time.Minute
+
+	// oneTimeCodeResendInterval is how long a user waits before another code is sent to the same email
+	oneTimeCodeResendInterval = time.Minute
+
+	// mailTimeout bounds the time spent sending an email
+	mailTimeout = 15 * time.Second
+)
+
+// issueSession creates a session for the user identified by uid and returns its token,
+// which is only ever returned to the client once
+func (app *app) issueSession(uid string) (string, *model.Session, error) {
+	token, err := generateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	session := &model.Session{
+		UserID:    uid,
+		TokenHash: model.HashToken(token),
+		CreatedOn: now,
+		ExpiresOn: now.Add(app.config.sessionTTL),
+	}
+	if err := app.repo.InsertSession(session); err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+// sessionOwns reports if the session authenticating r belongs to the user identified by uid,
+// sending a forbidden response if it doesn't
+func (app *app) sessionOwns(w http.ResponseWriter, r *http.Request, uid string) bool {
+	if contextGetSession(r).UserID != uid {
+		app.sendForbiddenResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// sendOneTimeCode emails a new one-time code for purpose to the user identified by uid at email.
+// Returns db.ErrOneTimeCodeTooSoon if a code was sent to email for purpose too recently
+func (app *app) sendOneTimeCode(purpose model.CodePurpose, uid, email string) error {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	now := time.Now()
+	err = app.repo.InsertOneTimeCode(&model.OneTimeCode{
+		Purpose:   purpose,
+		Email:     email,
+		UserID:    uid,
+		CodeHash:  model.HashToken(code),
+		CreatedOn: now,
+		ExpiresOn: now.Add(oneTimeCodeExpiry),
+	}, now.Add(-oneTimeCodeResendInterval))
+	if err != nil {
+		return err
+	}
+
+	msg := &mailer.Message{To: email}
+	switch purpose {
+	case model.CodeEmailVerification:
+		msg.Subject = "Verify your email address"
+		msg.Body = fmt.Sprintf("Your HeartNet verification code is %s.\n\n"+
+			"It expires in %d minutes. If you didn't request it, you can ignore this email.",
+			code, int(oneTimeCodeExpiry.Minutes()))
+	case model.CodeSessionClaim:
+		msg.Subject = "Sign in to your HeartNet account"
+		msg.Body = fmt.Sprintf("Your HeartNet sign in code is %s.\n\n"+
+			"It expires in %d minutes. If you didn't request it, someone may be trying to access "+
+			"your account, don't share the code with anyone.",
+			code, int(oneTimeCodeExpiry.Minutes()))
+	case model.CodeAccountRecovery:
+		msg.Subject = "Recover your HeartNet account"
+		msg.Body = fmt.Sprintf("Your HeartNet account recovery code is %s.\n\n"+
+			"It expires in %d minutes. If you didn't request it, someone may be trying to access "+
+			"your account, don't share the code with anyone.",
+			code, int(oneTimeCodeExpiry.Minutes()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+	return app.mailer.Send(ctx, msg)
+}
+
+// readEmail normalises email, recording a validation error in errs if it isn't a valid email address
+func readEmail(email string, errs map[string]string) string {
+	email = model.NormaliseEmail(email)
+	if err := validator.New().Var(email, "required,email"); err != nil {
+		errs["email"] = "must be a valid email address"
+	}
+	return email
+}
+
+// legacy session claim methods, see legacyClaimMethod
+const (
+	claimWithWallet = "wallet"
+	claimWithEmail  = "email"
+	claimWithUID    = "uid"
+)
+
+// legacyClaimMethod returns how user, who signed up before session credentials were introduced, proves
+// they own their account to claim their first session: with the wallet linked to it, with a code sent
+// to the email on it or, for accounts with neither, with their uid alone until the legacySessionsUntil
+// flag's date. It returns "" once accounts with neither can no longer claim a session
+func (app *app) legacyClaimMethod(user *model.User, now time.Time) string {
+	switch {
+	case user.WalletAddress != "":
+		return claimWithWallet
+	case user.Email != "":
+		return claimWithEmail
+	case now.Before(app.config.legacySessionsUntil):
+		return claimWithUID
+	default:
+		return ""
+	}
+}
+
+// createSessionChallenge starts the claim of the first session of a user who signed up before session
+// credentials were introduced, see claimSession. The response's method tells how the user proves they own
+// the account: wallet users sign the message of the challenge in the response with the wallet linked to
+// their account, email users answer the one-time code sent to the email on their account, and uid users,
+// whose account has neither, need nothing else until the legacySessionsUntil flag's date.
+// METHOD: POST
+// URL parameter: uid
+func (app *app) createSessionChallenge(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	user, method, ok := app.legacyUser(w, r, uid)
+	if !ok {
+		return
+	}
+
+	data := map[string]interface{}{"method": method}
+	message := "Claim your session with your user id"
+	switch method {
+	case claimWithWallet:
+		challenge, err := app.issueWalletChallenge(uid, user.WalletAddress,
+			"HeartNet wants you to sign in to your account with this wallet.")
+		if err != nil {
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+		data["challenge"] = challenge
+		message = "Sign the message with the wallet linked to your account to claim your session"
+	case claimWithEmail:
+		if err := app.sendOneTimeCode(model.CodeSessionClaim, uid, model.NormaliseEmail(user.Email)); err != nil {
+			if err == db.ErrOneTimeCodeTooSoon {
+				app.sendRateLimitExceededResponse(w, r, err.Error())
+				return
+			}
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+		message = "A code has been sent to the email on your account, send it back to claim your session"
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusCreated,
+		status:     true,
+		message:    message,
+	}, r, data)
+}
+
+// claimSession issues the first session of a user who signed up before session credentials
+// were introduced, once they prove they own the account as told by createSessionChallenge.
+// Challenges and codes are used up whether they are answered correctly or not. Email users' emails
+// are verified along the way, so that they can recover their account. A user's first session can
+// only be claimed once, users who lose it, or who signed up since, recover their account by email,
+// see recoverAccount.
+// METHOD: POST
+// URL parameter: uid
+// Request Body:
+//		signature string (required of wallet users, the hex encoded personal_sign signature of the challenge message)
+//		code string (required of email users)
+func (app *app) claimSession(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	var in struct {
+		Signature string `json:"signature"`
+		Code      string `json:"code"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	user, method, ok := app.legacyUser(w, r, uid)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	var verifiedEmail string
+	switch method {
+	case claimWithWallet:
+		if !app.checkSessionSignature(w, r, user, in.Signature) {
+			return
+		}
+	case claimWithEmail:
+		if !app.checkSessionCode(w, r, user, in.Code) {
+			return
+		}
+		verifiedEmail = model.NormaliseEmail(user.Email)
+	}
+
+	if err := app.repo.ClaimFirstSession(uid, now); err != nil {
+		switch err {
+		case db.ErrUserNotFound:
+			app.sendNotFoundResponse(w, r)
+		case db.ErrSessionAlreadyClaimed:
+			app.sendEditConflictResponse(w, r, err.Error())
+		default:
+			app.sendServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// an email verified by another user since stays theirs, the session is still earned
+	if verifiedEmail != "" {
+		if err := app.repo.VerifyUserEmail(uid, verifiedEmail, now); err != nil && err != db.ErrEmailInUse {
+			logger.Logger.LogError("failed to verify email of claimed session", "claim session", err)
+		}
+	}
+
+	token, session, err := app.issueSession(uid)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusCreated,
+		status:     true,
+		message:    "Session issued. Store the session token safely, it will not be shown again",
+	}, r, map[string]interface{}{
+		"session_token":      token,
+		"session_expires_on": session.ExpiresOn,
+	})
+}
+
+// checkSessionSignature checks that signature signs the message of the session challenge issued
+// to user with the wallet linked to their account, sending an error response if it doesn't
+func (app *app) checkSessionSignature(w http.ResponseWriter, r *http.Request, user *model.User, signature string) bool {
+	if signature == "" {
+		app.sendFailedValidationResponse(w, r, map[string]string{"signature": "must be provided"})
+		return false
+	}
+	challenge, err := app.repo.ConsumeWalletChallenge(user.UID, user.WalletAddress)
+	if err != nil {
+		if err == db.ErrWalletChallengeNotFound {
+			app.sendFailedValidationResponse(w, r, map[string]string{"signature": err.Error()})
+			return false
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return false
+	}
+	if err := wallet.VerifyPersonalSign(user.WalletAddress, challenge.Message, signature); err != nil {
+		if errors.Is(err, wallet.ErrInvalidSignature) || errors.Is(err, wallet.ErrSignerMismatch) {
+			app.sendFailedValidationResponse(w, r, map[string]string{"signature": err.Error()})
+			return false
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return false
+	}
+	return true
+}
+
+// checkSessionCode checks that code is the session claim code sent to the email on user's account,
+// sending an error response if it isn't
+func (app *app) checkSessionCode(w http.ResponseWriter, r *http.Request, user *model.User, code string) bool {
+	if code == "" {
+		app.sendFailedValidationResponse(w, r, map[string]string{"code": "must be provided"})
+		return false
+	}
+	issued, err := app.repo.ConsumeOneTimeCode(model.CodeSessionClaim, model.NormaliseEmail(user.Email), model.HashToken(code))
+	if err != nil {
+		if err == db.ErrOneTimeCodeNotFound || err == db.ErrOneTimeCodeMismatch {
+			app.sendFailedValidationResponse(w, r, map[string]string{"code": err.Error()})
+			return false
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return false
+	}
+	if issued.UserID != user.UID {
+		app.sendFailedValidationResponse(w, r, map[string]string{"code": db.ErrOneTimeCodeNotFound.Error()})
+		return false
+	}
+	return true
+}
+
+// legacyUser fetches the user identified by uid, and how they prove they own their account, if they can
+// still claim their first session, see legacyClaimMethod. It sends an error response if they can't
+func (app *app) legacyUser(w http.ResponseWriter, r *http.Request, uid string) (*model.User, string, bool) {
+	user, err := app.repo.FetchUser(uid)
+	if err != nil {
+		if err == db.ErrUserNotFound {
+			app.sendNotFoundResponse(w, r)
+			return nil, "", false
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return nil, "", false
+	}
+	if user.FirstSessionOn != nil {
+		app.sendEditConflictResponse(w, r, db.ErrSessionAlreadyClaimed.Error())
+		return nil, "", false
+	}
+	method := app.legacyClaimMethod(user, time.Now())
+	if method == "" {
+		app.sendForbiddenResponse(w, r)
+		return nil, "", false
+	}
+	return user, method, true
+}
+
+// refreshSession replaces the session authenticating the request with a new one, valid for the
+// sessionTTL flag's duration from now. Apps refresh their session before it expires, users whose
+// session expired recover their account by email, see recoverAccount.
+// METHOD: POST
+// Request must contain the user's session token
+func (app *app) refreshSession(w http.ResponseWriter, r *http.Request) {
+	current := contextGetSession(r)
+	token, session, err := app.issueSession(current.UserID)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	// the new token is only returned once, so it is returned even if the old one outlives it
+	if err := app.repo.DeleteSession(current.ID); err != nil {
+		logger.Logger.LogError("failed to revoke refreshed session", "refresh session", err)
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusCreated,
+		status:     true,
+		message:    "Session refreshed. Store the session token safely, it will not be shown again",
+	}, r, map[string]interface{}{
+		"session_token":      token,
+		"session_expires_on": session.ExpiresOn,
+	})
+}
+
+// requestEmailVerification emails a one-time code to the address the user wants to link to their account,
+// see verifyEmail. Requesting a new code invalidates the previous one.
+// METHOD: POST
+// Request must contain the user's session token
+// URL parameter: uid
+// Request Body:
+//		email string *required
+func (app *app) requestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+	var in struct {
+		Email string `json:"email"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	errs := make(map[string]string)
+	email := readEmail(in.Email, errs)
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	if err := app.sendOneTimeCode(model.CodeEmailVerification, uid, email); err != nil {
+		if err == db.ErrOneTimeCodeTooSoon {
+			app.sendRateLimitExceededResponse(w, r, err.Error())
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusAccepted,
+		status:     true,
+		message:    "A verification code has been sent to your email",
+	}, r, nil)
+}
+
+// verifyEmail links an email address to the user's account once they answer the one-time code
+// sent to it, see requestEmailVerification. Verified emails can be used to recover the account.
+// METHOD: POST
+// Request must contain the user's session token
+// URL parameter: uid
+// Request Body:
+//		email string *required
+//		code string *required
+func (app *app) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+	var in struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	errs := make(map[string]string)
+	email := readEmail(in.Email, errs)
+	if in.Code == "" {
+		errs["code"] = "must be provided"
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	code, err := app.repo.ConsumeOneTimeCode(model.CodeEmailVerification, email, model.HashToken(in.Code))
+	if err != nil {
+		if err == db.ErrOneTimeCodeNotFound || err == db.ErrOneTimeCodeMismatch {
+			app.sendFailedValidationResponse(w, r, map[string]string{"code": err.Error()})
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	if code.UserID != uid {
+		app.sendFailedValidationResponse(w, r, map[string]string{"code": db.ErrOneTimeCodeNotFound.Error()})
+		return
+	}
+
+	verifiedOn := time.Now()
+	if err := app.repo.VerifyUserEmail(uid, email, verifiedOn); err != nil {
+		switch err {
+		case db.ErrUserNotFound:
+			app.sendNotFoundResponse(w, r)
+		case db.ErrEmailInUse:
+			app.sendEditConflictResponse(w, r, err.Error())
+		default:
+			app.sendServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Email verified",
+	}, r, map[string]interface{}{
+		"email":             email,
+		"email_verified_on": verifiedOn,
+	})
+}
+
+// requestAccountRecovery emails a one-time code to a verified email address so that the user
+// who verified it can recover their account, e.g., after reinstalling the app, see recoverAccount.
+// The response is the same whether or not a user verified the email.
+// METHOD: POST
+// Request Body:
+//		email string *required
+func (app *app) requestAccountRecovery(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string `json:"email"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	errs := make(map[string]string)
+	email := readEmail(in.Email, errs)
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	user, err := app.repo.FetchUserByVerifiedEmail(email)
+	if err != nil && err != db.ErrUserNotFound {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	if user != nil {
+		err := app.sendOneTimeCode(model.CodeAccountRecovery, user.UID, email)
+		if err != nil && err != db.ErrOneTimeCodeTooSoon {
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusAccepted,
+		status:     true,
+		message:    "If this email is linked to an account, a recovery code has been sent to it",
+	}, r, nil)
+}
+
+// recoverAccount returns the uid of the user who verified an email address, along with new session
+// credentials, once the recovery code sent to the email is answered, see requestAccountRecovery.
+// The user's previous sessions are revoked.
+// METHOD: POST
+// Request Body:
+//		email string *required
+//		code string *required
+func (app *app) recoverAccount(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	errs := make(map[string]string)
+	email := readEmail(in.Email, errs)
+	if in.Code == "" {
+		errs["code"] = "must be provided"
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	code, err := app.repo.ConsumeOneTimeCode(model.CodeAccountRecovery, email, model.HashToken(in.Code))
+	if err != nil {
+		if err == db.ErrOneTimeCodeNotFound || err == db.ErrOneTimeCodeMismatch {
+			app.sendFailedValidationResponse(w, r, map[string]string{"code": err.Error()})
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	// the email must still be verified by the user the code was issued to
+	user, err := app.repo.FetchUserByVerifiedEmail(email)
+	if err != nil && err != db.ErrUserNotFound {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	if user == nil || user.UID != code.UserID {
+		app.sendFailedValidationResponse(w, r, map[string]string{"code": db.ErrOneTimeCodeNotFound.Error()})
+		return
+	}
+
+	if err := app.repo.DeleteUserSessions(user.UID); err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	token, session, err := app.issueSession(user.UID)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Account recovered. Store the session token safely, it will not be shown again",
+	}, r, map[string]interface{}{
+		"user_id":            user.UID,
+		"session_token":      token,
+		"session_expires_on": session.ExpiresOn,
+	})
+	app.notificationHub.Dispatch(model.NewWelcomeBackNotification(user.UID))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// csvFlushInterval is how many rows of an export are buffered before they are sent
+const csvFlushInterval = 200
+
+// readAirdropSubmissionFilter reads the airdrop submission filters shared by the
+// activities statistics and review queue endpoints from qs. Invalid values are recorded in errs
+func readAirdropSubmissionFilter(qs url.Values, errs map[string]string) model.AirdropSubmissionFilter {
+	filter := model.AirdropSubmissionFilter{
+		CampaignID:    readQueryObjectID(qs, "campaign_id", errs),
+		Status:        model.SubmissionStatus(readQueryString(qs, "status", "")),
+		SubmittedFrom: readQueryDate(qs, "from", false, errs),
+		SubmittedTo:   readQueryDate(qs, "to", true, errs),
+		Flagged:       readQueryBool(qs, "flagged", errs),
+		HasWallet:     readQueryBool(qs, "has_wallet", errs),
+	}
+	if filter.Status != "" && !filter.Status.IsValid() {
+		errs["status"] = "must be one of pending, approved, rejected"
+	}
+	return filter
+}
+
+// serveAllAirdropSubmission serves activities statistics, i.e., the airdrop submissions,
+// most recent first, a page at a time.
+// The next page is fetched by passing the next_cursor of the response as cursor,
+// next_cursor is empty on the last page.
+// METHOD: GET
+// Request must contain admin authorization
+// Query parameters (all optional):
+//		campaign_id string
+//		status string (one of pending, approved, rejected)
+//		has_wallet bool
+//		flagged bool
+//		from date (YYYY-MM-DD)
+//		to date (YYYY-MM-DD)
+//		cursor string
+//		limit int (not more than 100, defaults to 20)
+func (app *app) serveAllAirdropSubmission(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+	filter := readAirdropSubmissionFilter(qs, errs)
+	limit := readQueryInt(qs, "limit", model.DefaultPageSize, errs)
+	if limit < 1 || limit > model.MaxPageSize {
+		errs["limit"] = "must be between 1 and 100"
+	}
+	var cursor *model.SubmissionCursor
+	if token := qs.Get("cursor"); token != "" {
+		var err error
+		if cursor, err = model.ParseSubmissionCursor(token); err != nil {
+			errs["cursor"] = err.Error()
+		}
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	// one more submission than asked for tells if there is a next page
+	submissions, err := app.repo.FetchAirdropSubmissionsAfter(&filter, cursor, int64(limit)+1)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	nextCursor := ""
+	if len(*submissions) > limit {
+		*submissions = (*submissions)[:limit]
+		nextCursor = model.NewSubmissionCursor(&(*submissions)[limit-1]).String()
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "All participants' reports",
+	}, r, map[string]interface{}{
+		"submissions": model.AirdropSubmissionAdminViews(*submissions),
+		"next_cursor": nextCursor,
+	})
+}
+
+// serveAirdropStatistics serves aggregate statistics of the airdrop submissions: counts per day,
+// how many submissions completed the task of each platform and counts per review status.
+// METHOD: GET
+// Request must contain admin authorization
+// Query parameters (all optional): the filters of serveAllAirdropSubmission, except cursor and limit
+func (app *app) serveAirdropStatistics(w http.ResponseWriter, r *http.Request) {
+	errs := make(map[string]string)
+	filter := readAirdropSubmissionFilter(r.URL.Query(), errs)
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	stats, err := app.repo.FetchAirdropStats(&filter)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "activities statistics",
+	}, r, stats)
+}
+
+// exportAirdropSubmissions streams the airdrop submissions as a CSV file, most recent first,
+// e.g., for the rewards team to pay out rewards.
+// METHOD: GET
+// Request must contain admin authorization
+// Query parameters (all optional): the filters of serveAllAirdropSubmission, except cursor and limit
+func (app *app) exportAirdropSubmissions(w http.ResponseWriter, r *http.Request) {
+	errs := make(map[string]string)
+	filter := readAirdropSubmissionFilter(r.URL.Query(), errs)
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="airdrop-submissions-%s.csv"`, time.Now().Format("2006-01-02")))
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	_ = out.Write([]string{
+		"id", "campaign_id", "user_id", "telegram_username", "twitter_username", "tweet_link",
+		"youtube_username", "wallet_address", "email_address", "status", "flagged", "fraud_score",
+		"submitted_on", "reviewed_on",
+	})
+
+	rows := 0
+	err := app.repo.StreamAirdropSubmissions(&filter, func(s *model.AirdropSubmission) error {
+		if err := out.Write(submissionCSVRecord(s)); err != nil {
+			return err
+		}
+		rows++
+		if rows%csvFlushInterval == 0 {
+			out.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return out.Error()
+	})
+	out.Flush()
+
+	// the status line is already sent, the truncated file is all the client gets
+	if err != nil {
+		logger.Logger.LogError("failed to export airdrop submissions", "export airdrop submissions", err)
+		return
+	}
+	logger.Logger.LogServe(http.StatusOK, r)
+}
+
+// submissionCSVRecord returns the row of s in an airdrop submissions export
+func submissionCSVRecord(s *model.AirdropSubmission) []string {
+	campaignId, status, flagged, score, reviewedOn := "", model.SubmissionPending, false, 0, ""
+	if !s.CampaignID.IsZero() {
+		campaignId = s.CampaignID.Hex()
+	}
+	if s.Status != "" {
+		status = s.Status
+	}
+	if s.Fraud != nil {
+		flagged, score = s.Fraud.Flagged, s.Fraud.Score
+	}
+	if s.Review != nil {
+		reviewedOn = s.Review.ReviewedOn.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		s.ID.Hex(), campaignId, s.UserID, s.TelegramUsername, s.TwitterUsername, s.TweetLink,
+		s.YoutubeUsername, s.WalletAddress, s.EmailAddress, string(status), strconv.FormatBool(flagged),
+		strconv.Itoa(score), s.SubmittedOn.UTC().Format(time.RFC3339), reviewedOn,
+	}
+}
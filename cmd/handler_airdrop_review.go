@@ -0,0 +1,260 @@
+package main
+
+import (
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBulkReviews caps the submissions reviewed by a single bulk review request
+const maxBulkReviews = 100
+
+// reviewInput is the request body of the airdrop submission review endpoints
+type reviewInput struct {
+	Status model.SubmissionStatus `json:"status"`
+	Reason string                 `json:"reason"`
+}
+
+// validate trims in and returns its field-level errors
+func (in *reviewInput) validate() map[string]string {
+	errs := make(map[string]string)
+	in.Reason = strings.TrimSpace(in.Reason)
+	switch in.Status {
+	case model.SubmissionApproved:
+	case model.SubmissionRejected:
+		if in.Reason == "" {
+			errs["reason"] = "must be provided when rejecting a submission"
+		} else if len(in.Reason) > 500 {
+			errs["reason"] = "must not be more than 500 bytes long"
+		}
+	default:
+		errs["status"] = "must be one of approved, rejected"
+	}
+	return errs
+}
+
+// listAirdropSubmissionQueue serves a page of airdrop submissions for review.
+// Pending submissions are listed oldest first.
+// METHOD: GET
+// Request must contain admin authorization
+// Query parameters (all optional):
+//		status string (one of pending, approved, rejected, defaults to pending)
+//		campaign_id string
+//		flagged bool (only submissions that are, or aren't, flagged by sybil detection)
+//		has_wallet bool
+//		from date (YYYY-MM-DD)
+//		to date (YYYY-MM-DD)
+//		page int
+//		page_size int (not more than 100)
+func (app *app) listAirdropSubmissionQueue(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+
+	filter := readAirdropSubmissionFilter(qs, errs)
+	if filter.Status == "" {
+		filter.Status = model.SubmissionPending
+	}
+	filter.Pagination = model.Pagination{
+		Page:     readQueryInt(qs, "page", 1, errs),
+		PageSize: readQueryInt(qs, "page_size", model.DefaultPageSize, errs),
+	}
+	for key, message := range filter.Pagination.Validate() {
+		errs[key] = message
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	submissions, metadata, err := app.repo.FetchAirdropSubmissions(&filter)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "airdrop submissions",
+	}, r, map[string]interface{}{
+		"submissions": model.AirdropSubmissionAdminViews(*submissions),
+		"metadata":    metadata,
+	})
+}
+
+// reviewAirdropSubmission approves or rejects a pending airdrop submission.
+// Approving a submission rewards the user from the campaign's reward pool.
+// The user is notified either way.
+// METHOD: POST
+// Request must contain admin authorization
+// URL parameter: id (airdrop submission id)
+// Request Body:
+//		status string *required (one of approved, rejected)
+//		reason string (required when rejecting)
+func (app *app) reviewAirdropSubmission(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		app.sendNotFoundResponse(w, r)
+		return
+	}
+
+	var in reviewInput
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	if errs := in.validate(); len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	submission, err := app.applyReview(id, &in)
+	if err != nil {
+		switch err {
+		case db.ErrNoSubmissionFound:
+			app.sendNotFoundResponse(w, r)
+		case db.ErrSubmissionAlreadyReviewed, db.ErrRewardPoolExhausted:
+			app.sendEditConflictResponse(w, r, err.Error())
+		default:
+			app.sendServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Airdrop submission reviewed",
+	}, r, submission.AdminView())
+}
+
+// reviewAirdropSubmissions approves or rejects several pending airdrop submissions at once,
+// see reviewAirdropSubmission. Each submission is reviewed independently, the outcome
+// of each is reported in the response.
+// METHOD: POST
+// Request must contain admin authorization
+// Request Body:
+//		ids []string *required (not more than 100)
+//		status string *required (one of approved, rejected)
+//		reason string (required when rejecting)
+func (app *app) reviewAirdropSubmissions(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		IDs []primitive.ObjectID `json:"ids"`
+		reviewInput
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	errs := in.validate()
+	if len(in.IDs) == 0 || len(in.IDs) > maxBulkReviews {
+		errs["ids"] = "must contain between 1 and 100 ids"
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	type outcome struct {
+		ID     primitive.ObjectID     `json:"id"`
+		Status model.SubmissionStatus `json:"status,omitempty"`
+		Error  string                 `json:"error,omitempty"`
+	}
+	outcomes := make([]outcome, 0, len(in.IDs))
+	reviewed := 0
+	for _, id := range in.IDs {
+		submission, err := app.applyReview(id, &in.reviewInput)
+		switch err {
+		case nil:
+			reviewed++
+			outcomes = append(outcomes, outcome{ID: id, Status: submission.Status})
+		case db.ErrNoSubmissionFound, db.ErrSubmissionAlreadyReviewed, db.ErrRewardPoolExhausted:
+			outcomes = append(outcomes, outcome{ID: id, Error: err.Error()})
+		default:
+			logger.Logger.LogError("failed to review airdrop submission", "bulk review", err)
+			outcomes = append(outcomes, outcome{ID: id, Error: "the submission could not be reviewed, try again"})
+		}
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Airdrop submissions reviewed",
+	}, r, map[string]interface{}{
+		"reviewed": reviewed,
+		"results":  outcomes,
+	})
+}
+
+// applyReview moves the pending submission identified by id to in.Status.
+// Approved submissions made to a campaign are rewarded from its reward pool.
+// The user is notified of the outcome
+func (app *app) applyReview(id primitive.ObjectID, in *reviewInput) (*model.AirdropSubmission, error) {
+	submission, err := app.repo.FetchAirdropSubmissionByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if submission.IsReviewed() {
+		return nil, db.ErrSubmissionAlreadyReviewed
+	}
+
+	review := &model.SubmissionReview{ReviewedOn: time.Now()}
+	if in.Status == model.SubmissionRejected {
+		review.Reason = in.Reason
+	}
+
+	// submissions made before campaigns were introduced have no reward pool to pay from
+	var reward *model.Reward
+	if in.Status == model.SubmissionApproved && !submission.CampaignID.IsZero() {
+		campaign, err := app.repo.FetchCampaign(submission.CampaignID)
+		if err != nil {
+			return nil, err
+		}
+		reward = &model.Reward{
+			ID:           primitive.NewObjectID(),
+			UserID:       submission.UserID,
+			CampaignID:   campaign.ID,
+			SubmissionID: submission.ID,
+			Points:       campaign.RewardPool.PointsPerSubmission,
+			HrtTokens:    campaign.RewardPool.HrtTokensPerSubmission,
+			RecordedOn:   review.ReviewedOn,
+		}
+		if err := app.repo.ReserveCampaignReward(campaign.ID, reward.HrtTokens); err != nil {
+			return nil, err
+		}
+		review.RewardID = &reward.ID
+	}
+
+	submission, err = app.repo.ReviewAirdropSubmission(id, in.Status, review)
+	if err != nil {
+		if reward != nil {
+			if err := app.repo.ReleaseCampaignReward(reward.CampaignID, reward.HrtTokens); err != nil {
+				logger.Logger.LogError("failed to release campaign reward", "review airdrop submission", err)
+			}
+		}
+		return nil, err
+	}
+
+	if submission.Status == model.SubmissionRejected {
+		app.notificationHub.Dispatch(model.NewSubmissionRejectedNotification(submission.UserID, review.Reason))
+		return submission, nil
+	}
+	if reward != nil {
+		if err := app.repo.RecordReward(reward); err != nil {
+			return nil, errors.Wrapf(err, "submission %s approved but its reward wasn't recorded", id.Hex())
+		}
+		app.recordLeaderboardScore(reward.UserID, model.MetricPoints, reward.Points, reward.RecordedOn)
+	}
+	app.notificationHub.Dispatch(model.NewSubmissionApprovedNotification(submission.UserID, reward))
+	return submission, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+// createCampaign creates an airdrop campaign.
+// METHOD: POST
+// Request must contain admin authorization
+// Request Body:
+//		name string *required
+//		description string
+//		tasks []{title string *required, description string} *required
+//		starts_on time.Time *required
+//		ends_on time.Time *required (after starts_on)
+//		reward_pool {hrt_tokens int, hrt_tokens_per_submission int, points_per_submission int}
+func (app *app) createCampaign(w http.ResponseWriter, r *http.Request) {
+	var campaign model.Campaign
+	if err := app.readJSON(w, r, &campaign); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	campaign.ID = primitive.NilObjectID
+	campaign.RewardPool.DistributedHrtTokens = 0
+	campaign.CreatedOn = time.Now()
+
+	validate := validator.New()
+	if err := validate.Struct(campaign); err != nil {
+		errs := make(map[string]string)
+		for _, err := range err.(validator.ValidationErrors) {
+			errs[err.Field()] = fmt.Sprintf("%v is not a valid value for %s", err.Value(), err.Field())
+		}
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	if err := app.repo.InsertCampaign(&campaign); err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusCreated,
+		status:     true,
+		message:    "Campaign created",
+	}, r, campaign)
+}
+
+// listActiveCampaigns serves the airdrop campaigns currently accepting submissions,
+// those ending soonest first.
+// METHOD: GET
+func (app *app) listActiveCampaigns(w http.ResponseWriter, r *http.Request) {
+	campaigns, err := app.repo.FetchActiveCampaigns(time.Now())
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "active campaigns",
+	}, r, campaigns)
+}
+
+// listUserAirdropSubmissions serves the airdrop submissions a user made across campaigns,
+// most recent first.
+// METHOD: GET
+// Request must contain the user's session token
+// URL parameter: uid
+func (app *app) listUserAirdropSubmissions(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+	if err := app.repo.IsValidUser(uid); err != nil {
+		if err == db.ErrUserNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	submissions, err := app.repo.FetchAirdropSubmissionsByUserID(uid)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "your airdrop submissions",
+	}, r, submissions)
+}
+
+// submissionCampaign fetches the campaign identified by id that a submission is made at t to.
+// Older clients don't send a campaign id, their submissions go to the only running campaign,
+// provided there is exactly one.
+// If the campaign can't be submitted to, errs describes why
+func (app *app) submissionCampaign(id primitive.ObjectID, t time.Time) (campaign *model.Campaign, errs map[string]string, err error) {
+	if id.IsZero() {
+		campaigns, err := app.repo.FetchActiveCampaigns(t)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(*campaigns) != 1 {
+			return nil, map[string]string{"campaign_id": "must be provided, see GET /api/campaigns"}, nil
+		}
+		return &(*campaigns)[0], nil, nil
+	}
+
+	campaign, err = app.repo.FetchCampaign(id)
+	if err != nil {
+		if err == db.ErrCampaignNotFound {
+			return nil, map[string]string{"campaign_id": err.Error()}, nil
+		}
+		return nil, nil, err
+	}
+	if !campaign.IsRunning(t) {
+		return nil, map[string]string{"campaign_id": "campaign is not accepting submissions"}, nil
+	}
+	return campaign, nil, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/Hrtnet/social-activities/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"path"
+	"time"
+)
+
+// exportUserData serves a zip of everything HeartNet holds about the user: a JSON file per collection
+// holding user data, e.g., users.json, incidenceReports.json, and the images attached to their
+// incidence reports under images/.
+// METHOD: GET
+// Request must contain the user's session token
+// URL parameter: uid
+func (app *app) exportUserData(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+
+	// images are looked up first, so that a failure can still be reported with a proper status
+	reports, err := app.repo.FetchIncidenceReportsByUserID(uid)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="heartnet-%s-%s.zip"`, uid, time.Now().Format("2006-01-02")))
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	err = app.repo.ExportUserData(uid, func(collection string, documents []byte) error {
+		file, err := archive.Create(collection + ".json")
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(documents)
+		return err
+	})
+	if err == nil {
+		for _, report := range *reports {
+			if err = exportImages(app.store, archive, report.ImageKeys()...); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+
+	// the status line is already sent, the truncated archive is all the client gets
+	if err != nil {
+		logger.Logger.LogError("failed to export user data", "export user data", err)
+		return
+	}
+	logger.Logger.LogServe(http.StatusOK, r)
+}
+
+// exportImages copies the blobs stored under keys into archive, under images/.
+// Blobs that no longer exist are skipped
+func exportImages(store storage.BlobStore, archive *zip.Writer, keys ...string) error {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := exportImage(store, archive, key); err != nil && err != storage.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportImage(store storage.BlobStore, archive *zip.Writer, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	object, info, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	file, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     path.Join("images", path.Clean("/" + key)[1:]),
+		Method:   zip.Store,
+		Modified: info.ModTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, object)
+	return err
+}
+
+// deleteUser deletes the user's account. The user, their airdrop submissions, notifications, sessions
+// and leaderboard scores are erased. Their incidence reports, rewards, scans, referrals and the
+// pharmacies they suggested are kept for the investigations and accounting they are part of, but no
+// longer link to the user. The receipts attached to their incidence reports are deleted, and the
+// reporter's coordinates are removed from the reports and the webhook deliveries about them.
+// The deletion is recorded for audit without the user's uid, see model.AccountDeletion.
+// A deletion that failed is resumed by requesting it again.
+// METHOD: DELETE
+// Request must contain the user's session token
+// URL parameter: uid
+func (app *app) deleteUser(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+
+	deletion, err := app.accountDeletion(uid)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.repo.DeleteUserData(uid, deletion); err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	deleteBlobs(app.store, deletion.ImageKeys...)
+	deletion.DeletedImages = len(deletion.ImageKeys)
+	completedOn := time.Now()
+	deletion.CompletedOn = &completedOn
+	if err := app.repo.CompleteAccountDeletion(deletion); err != nil {
+		logger.Logger.LogError("failed to record account deletion", "delete user", err)
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Account deleted",
+	}, r, deletion)
+}
+
+// accountDeletion returns the pending deletion of the user's account, or records a new one, with the
+// keys of the receipts attached to the user's incidence reports. The keys are recorded before the
+// reports are anonymised, so that a deletion resumed after a failure still deletes the receipts
+func (app *app) accountDeletion(uid string) (*model.AccountDeletion, error) {
+	reports, err := app.repo.FetchIncidenceReportsByUserID(uid)
+	if err != nil {
+		return nil, err
+	}
+	var receipts []string
+	for _, report := range *reports {
+		for _, key := range []string{report.ReceiptImageUrl, report.ReceiptThumbnailUrl} {
+			if key != "" {
+				receipts = append(receipts, key)
+			}
+		}
+	}
+
+	deletion, err := app.repo.FetchPendingAccountDeletion(model.HashToken(uid))
+	if err == db.ErrAccountDeletionNotFound {
+		id := primitive.NewObjectID()
+		deletion = &model.AccountDeletion{
+			ID:          id,
+			UserIDHash:  model.HashToken(uid),
+			AnonymousID: "deleted-" + id.Hex(),
+			RequestedOn: time.Now(),
+			ImageKeys:   receipts,
+		}
+		return deletion, app.repo.InsertAccountDeletion(deletion)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(receipts) > 0 {
+		if err := app.repo.AddAccountDeletionImageKeys(deletion, receipts); err != nil {
+			return nil, err
+		}
+	}
+	return deletion, nil
+}
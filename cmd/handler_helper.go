@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/Hrtnet/social-activities/internal/db"
 	"github.com/Hrtnet/social-activities/internal/logger"
 	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/Hrtnet/social-activities/internal/storage"
+	"github.com/Hrtnet/social-activities/internal/wallet"
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
+	"net/url"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -159,14 +167,123 @@ func formatReadError(err error) error {
 	}
 }
 
+var (
+	// telegramUsername matches Telegram usernames: 5 to 32 letters, digits or underscores, starting with a letter
+	telegramUsername = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{4,31}$`)
+
+	// twitterUsername matches Twitter handles: up to 15 letters, digits or underscores
+	twitterUsername = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`)
+
+	// youtubeHandle matches YouTube handles: 3 to 30 letters, digits, underscores, hyphens or periods
+	youtubeHandle = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,30}$`)
+
+	// tweetPath matches the path of a link to a tweet, capturing the author's username and the status id
+	tweetPath = regexp.MustCompile(`^/([A-Za-z0-9_]{1,15})/status/([0-9]{1,20})/?$`)
+
+	// tweetHosts are the hosts tweet links may point to
+	tweetHosts = map[string]bool{
+		"twitter.com":        true,
+		"www.twitter.com":    true,
+		"mobile.twitter.com": true,
+		"x.com":              true,
+		"www.x.com":          true,
+		"mobile.x.com":       true,
+	}
+)
+
 // validateAirdropSubmission checks that the POSTed task report
-// contains the required field.
-// If one or more fields are missing, it reports an error
+// contains the required fields and that they are well formed.
+// Identifiers are normalised, e.g., usernames are trimmed, stripped of a leading "@"
+// and lowercased, and SubmittedOn is set.
+// If one or more fields are invalid, it reports an error per field, keyed by its json name
 func validateAirdropSubmission(report *model.AirdropSubmission) (errs map[string]string) {
-	return nil
+	errs = make(map[string]string)
+	report.ID = primitive.NilObjectID
+	report.TelegramUsername = normaliseUsername(report.TelegramUsername)
+	report.TwitterUsername = normaliseUsername(report.TwitterUsername)
+	report.YoutubeUsername = normaliseUsername(report.YoutubeUsername)
+	report.TweetLink = strings.TrimSpace(report.TweetLink)
+	report.WalletAddress = strings.TrimSpace(report.WalletAddress)
+	report.EmailAddress = strings.ToLower(strings.TrimSpace(report.EmailAddress))
+	report.UserID = strings.TrimSpace(report.UserID)
+	report.SubmittedOn = time.Now()
+	report.Status = model.SubmissionPending
+	report.Review = nil
+
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	})
+	if err := validate.Struct(report); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Tag() == "required" {
+				errs[err.Field()] = "must be provided"
+			} else {
+				errs[err.Field()] = fmt.Sprintf("%v is not a valid value for %s", err.Value(), err.Field())
+			}
+		}
+	}
+
+	if report.WalletAddress == "" && report.EmailAddress == "" {
+		errs["wallet_address"] = "either wallet_address or email_address must be provided"
+	} else if report.WalletAddress != "" {
+		address, err := wallet.Normalise(report.WalletAddress)
+		if err != nil {
+			errs["wallet_address"] = err.Error()
+		}
+		report.WalletAddress = address
+	}
+	if _, ok := errs["telegram_username"]; !ok && !telegramUsername.MatchString(report.TelegramUsername) {
+		errs["telegram_username"] = "must be 5 to 32 letters, digits or underscores, starting with a letter"
+	}
+	if _, ok := errs["twitter_username"]; !ok && !twitterUsername.MatchString(report.TwitterUsername) {
+		errs["twitter_username"] = "must be 1 to 15 letters, digits or underscores"
+	}
+	if report.YoutubeUsername != "" && !youtubeHandle.MatchString(report.YoutubeUsername) {
+		errs["youtube_username"] = "must be 3 to 30 letters, digits, underscores, hyphens or periods"
+	}
+	if _, ok := errs["tweet_link"]; !ok {
+		link, message := validateTweetLink(report.TweetLink, report.TwitterUsername)
+		if message != "" {
+			errs["tweet_link"] = message
+		}
+		report.TweetLink = link
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateTweetLink checks that link points to a tweet on twitter.com or x.com posted by username.
+// It returns the canonical link to the tweet, so that the many links to a tweet compare equal,
+// and a message describing what is wrong, which is empty if link is valid
+func validateTweetLink(link, username string) (canonical string, message string) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || !tweetHosts[strings.ToLower(u.Host)] {
+		return link, "must be a link to a tweet on twitter.com or x.com"
+	}
+	match := tweetPath.FindStringSubmatch(u.Path)
+	if match == nil {
+		return link, "must be a link to a tweet, e.g., https://x.com/<username>/status/<id>"
+	}
+	if !strings.EqualFold(match[1], username) {
+		return link, "must be a tweet posted by twitter_username"
+	}
+	return fmt.Sprintf("https://x.com/%s/status/%s", strings.ToLower(match[1]), match[2]), ""
 }
 
-func extractAnnouncement(r *http.Request, saveDir, apiUrl string) (*model.Announcement, db.ErrorType, error) {
+// normaliseUsername trims a social media username, strips its leading "@", if any,
+// and lowercases it since usernames are case-insensitive
+func normaliseUsername(username string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+}
+
+// extractAnnouncement extracts announcement data from the request.
+// The announcement image, if any, is saved into store under the saveDir key prefix
+// and its key is recorded as the announcement's ImageUrl.
+func extractAnnouncement(r *http.Request, store storage.BlobStore, saveDir string) (*model.Announcement, db.ErrorType, error) {
 	announcement := new(model.Announcement)
 
 	// extract other form values
@@ -181,7 +298,7 @@ func extractAnnouncement(r *http.Request, saveDir, apiUrl string) (*model.Announ
 	announcement.Url = r.PostFormValue("url")
 
 	// save image if found
-	file, header, err := r.FormFile("image")
+	file, _, err := r.FormFile("image")
 
 	if err != nil {
 
@@ -192,32 +309,34 @@ func extractAnnouncement(r *http.Request, saveDir, apiUrl string) (*model.Announ
 	}
 	defer file.Close()
 
-	parts := strings.Split(header.Filename, ".")
-	fileExtension := parts[len(parts)-1]
-	saveAs := fmt.Sprintf("%d_%s.%s", announcement.CreatedOn.Unix(),
-		strings.ReplaceAll(announcement.Title, " ", "_"), fileExtension)
+	saveAs := fmt.Sprintf("%s/%d_%s", saveDir, announcement.CreatedOn.Unix(),
+		strings.ReplaceAll(announcement.Title, " ", "_"))
 
-	savedFileUrl, err := saveFile(file, saveAs, saveDir)
+	saved, err := saveImage(store, file, saveAs)
 	if err != nil {
-		if err == errFileTooLarge {
-			return nil, db.ValidationError, errors.Wrap(err, "failed to save receipt image")
+		if isImageValidationError(err) {
+			return nil, db.ValidationError, errors.Wrap(err, "invalid announcement image")
 		}
 		return nil, db.InternalError, err
 	}
-	announcement.ImageUrl = fmt.Sprintf("%s%s", apiUrl, savedFileUrl)
+	announcement.ImageUrl = saved.key
+	announcement.ThumbnailUrl = saved.thumbnailKey
 
 	return announcement, db.None, nil
 }
 
 // extractIncidenceReport extracts incidence report data from the request.
 // Request content-type must be multipart/form-data.
-// Receipt file name is saved as userId_unixTime.
-// Evidence images are saved in a userId_unixTime directory
+// Receipt file name is saved as userId_unixNanoTime.
+// Evidence images are saved in a userId_unixNanoTime directory.
+// Image fields of the returned report hold blob keys rather than urls,
+// see app.resolveIncidenceReportUrls
 func (app *app) extractIncidenceReport(r *http.Request, cfg *config) (*model.IncidenceReport, db.ErrorType, error) {
 	report := new(model.IncidenceReport)
 
-	// check that user is valid
-	report.UserID = r.PostFormValue("user_id")
+	// the reporter is the user the session belongs to, whatever user_id older apps still send,
+	// so that limits and moderation apply to them
+	report.UserID = contextGetSession(r).UserID
 	err := app.repo.IsValidUser(report.UserID)
 	if err != nil {
 		if err == db.ErrUserNotFound {
@@ -225,95 +344,492 @@ func (app *app) extractIncidenceReport(r *http.Request, cfg *config) (*model.Inc
 		}
 		return nil, db.InternalError, err
 	}
+	if err := app.checkIncidenceReportLimits(report.UserID); err != nil {
+		return nil, db.ValidationError, err
+	}
 
 	// extract other form values
 	report.SubmittedOn = time.Now()
+	report.State = model.ReportNew
+	report.Transitions = []model.IncidenceReportTransition{{
+		To:    model.ReportNew,
+		Actor: "reporter",
+		At:    report.SubmittedOn,
+	}}
 	report.Description = r.PostFormValue("description")
-	report.PharmacyLocation = r.PostFormValue("pharmacy_location")
-	report.PharmacyName = r.PostFormValue("pharmacy_name")
+	report.PharmacyLocation = strings.TrimSpace(r.PostFormValue("pharmacy_location"))
+	report.PharmacyName = strings.TrimSpace(r.PostFormValue("pharmacy_name"))
+	report.DrugName = strings.TrimSpace(r.PostFormValue("drug_name"))
+
+	report.Coordinates, err = extractCoordinates(r)
+	if err != nil {
+		return nil, db.ValidationError, err
+	}
+	if errType, err := app.linkPharmacy(r, report); err != nil {
+		return nil, errType, err
+	}
+	if errType, err := app.linkScan(r, report); err != nil {
+		return nil, errType, err
+	}
 
 	if report.Description == "" || report.PharmacyName == "" || report.PharmacyLocation == "" {
 		return nil, db.ValidationError, errors.New("one of description, pharmacy location, or pharmacy name is missing")
 	}
 
 	// save receipt
-	file, header, err := r.FormFile("receipt")
+	file, _, err := r.FormFile("receipt")
 	if err != nil {
 		return nil, db.ValidationError, errors.Wrap(err, "invalid receipt image")
 	}
 	defer file.Close()
-	filenameParts := strings.Split(header.Filename, ".")
-	fileExtension := filenameParts[len(filenameParts)-1]
-	saveAs := fmt.Sprintf("%s_%d.%s", report.UserID, time.Now().Unix(), fileExtension)
-	savedFileUrl, err := saveFile(file, saveAs, cfg.incidenceReportReceiptImagePath)
+	saveAs := fmt.Sprintf("%s/%s_%d", cfg.incidenceReportReceiptImagePath, report.UserID, time.Now().UnixNano())
+	saved, err := saveImage(app.store, file, saveAs)
 	if err != nil {
-		if err == errFileTooLarge {
-			return nil, db.ValidationError, errors.Wrap(err, "failed to save receipt image")
+		if isImageValidationError(err) {
+			return nil, db.ValidationError, errors.Wrap(err, "invalid receipt image")
 		}
 		return nil, db.InternalError, err
 	}
-	report.ReceiptImageUrl = fmt.Sprintf("%s%s", app.config.apiUrl, savedFileUrl)
+	report.ReceiptImageUrl = saved.key
+	report.ReceiptThumbnailUrl = saved.thumbnailKey
 
 	// save evidence images
-	zippedFiles, header, err := r.FormFile("evidence_images")
+	evidence, errType, err := app.extractEvidenceImages(r, report.UserID)
 	if err != nil {
-		os.Remove(report.ReceiptImageUrl)
-		return nil, db.ValidationError, errors.Wrap(err, "invalid evidence images")
+		deleteBlobs(app.store, report.ReceiptImageUrl, report.ReceiptThumbnailUrl)
+		return nil, errType, err
+	}
+	keys, thumbnailKeys, fingerprints := splitSavedImages(evidence)
+	report.EvidenceImagesUrl = keys
+	report.EvidenceThumbnailsUrl = thumbnailKeys
+	report.ImageFingerprints = append([]model.ImageFingerprint{imageFingerprint(saved.fingerprint)}, fingerprints...)
+	return report, db.None, nil
+}
+
+// extractCoordinates extracts the optional latitude and longitude form values
+func extractCoordinates(r *http.Request) (*model.GeoPoint, error) {
+	latValue, lngValue := r.PostFormValue("latitude"), r.PostFormValue("longitude")
+	if latValue == "" && lngValue == "" {
+		return nil, nil
 	}
 
-	saveDir := fmt.Sprintf("%s/%s_%d", cfg.incidenceReportDrugImagePath, report.UserID, time.Now().Unix())
-	savePaths, errType, err := unzipAndSave(zippedFiles, header, saveDir, app.config.apiUrl)
+	lat, latErr := strconv.ParseFloat(latValue, 64)
+	lng, lngErr := strconv.ParseFloat(lngValue, 64)
+	if latErr != nil || lngErr != nil || !model.ValidCoordinates(lat, lng) {
+		return nil, errors.New("latitude and longitude must both be set to a valid latitude and longitude")
+	}
+	return model.NewGeoPoint(lat, lng), nil
+}
+
+// linkPharmacy links report to the pharmacy registry.
+// If the pharmacy_id form value is set, the registered pharmacy fills in the report's
+// pharmacy name, location and coordinates where the reporter left them out.
+// Otherwise report is linked to the pharmacy with the name and address given by the reporter,
+// if there is one. Unknown pharmacies are only suggested once the report is stored, see suggestPharmacy
+func (app *app) linkPharmacy(r *http.Request, report *model.IncidenceReport) (db.ErrorType, error) {
+	if pharmacyId := r.PostFormValue("pharmacy_id"); pharmacyId != "" {
+		id, err := primitive.ObjectIDFromHex(pharmacyId)
+		if err != nil {
+			return db.ValidationError, db.ErrPharmacyNotFound
+		}
+		pharmacy, err := app.repo.FetchPharmacy(id)
+		if err != nil {
+			if err == db.ErrPharmacyNotFound {
+				return db.ValidationError, err
+			}
+			return db.InternalError, err
+		}
+
+		report.PharmacyID = &pharmacy.ID
+		if report.PharmacyName == "" {
+			report.PharmacyName = pharmacy.Name
+		}
+		if report.PharmacyLocation == "" {
+			report.PharmacyLocation = pharmacy.Address
+		}
+		if report.Coordinates == nil {
+			report.Coordinates = pharmacy.Location
+		}
+		return db.None, nil
+	}
+
+	// incomplete reports are rejected by the caller
+	if report.PharmacyName == "" || report.PharmacyLocation == "" {
+		return db.None, nil
+	}
+
+	pharmacy, err := app.repo.FetchPharmacyBySearchKey(model.PharmacySearchKey(report.PharmacyName, report.PharmacyLocation))
 	if err != nil {
-		return nil, errType, err
+		if err == db.ErrPharmacyNotFound {
+			return db.None, nil
+		}
+		return db.InternalError, err
 	}
-	// todo obtain correct save path
-	report.EvidenceImagesUrl = *savePaths
-	return report, db.None, nil
+	report.PharmacyID = &pharmacy.ID
+	if report.Coordinates == nil {
+		report.Coordinates = pharmacy.Location
+	}
+	return db.None, nil
 }
 
-func (app *app) processValidation(w http.ResponseWriter, r *http.Request, drug *model.Drug, userId string, err error) {
-	if err == db.ErrDrugNotFound {
-		app.sendDrugNotFoundResponse(w, r)
-		app.notificationHub.Dispatch(model.NewValidationNotification(userId, "Drug not found"))
+// suggestPharmacy suggests the pharmacy named by the stored report to the registry and links report to it.
+// Reports are accepted without a pharmacy, so failures are only logged
+func (app *app) suggestPharmacy(report *model.IncidenceReport) {
+	pharmacy := &model.Pharmacy{
+		Name:        report.PharmacyName,
+		Address:     report.PharmacyLocation,
+		Location:    report.Coordinates,
+		SuggestedBy: report.UserID,
+		CreatedOn:   time.Now(),
+	}
+	if err := app.repo.SuggestPharmacy(pharmacy); err != nil {
+		logger.Logger.LogError("failed to suggest pharmacy", "suggest pharmacy", err)
 		return
 	}
+	if err := app.repo.LinkIncidenceReportPharmacy(report.ID, pharmacy.ID); err != nil {
+		logger.Logger.LogError("failed to link incidence report to suggested pharmacy", "suggest pharmacy", err)
+		return
+	}
+	report.PharmacyID = &pharmacy.ID
+}
+
+// linkScan links report to the scan identified by the report_token form value, if any,
+// copying the scanned drug's details into the report.
+// Details the scan couldn't provide, e.g., for drugs that weren't found, are read from the form
+func (app *app) linkScan(r *http.Request, report *model.IncidenceReport) (db.ErrorType, error) {
+	token := r.PostFormValue("report_token")
+	if token == "" {
+		report.BatchNumber = strings.TrimSpace(r.PostFormValue("batch_number"))
+		return db.None, nil
+	}
 
+	scanId, err := app.verifyReportToken(token, report.UserID)
+	if err != nil {
+		return db.ValidationError, err
+	}
+	scan, err := app.repo.FetchScan(scanId)
 	if err != nil {
+		if err == db.ErrScanNotFound {
+			return db.ValidationError, errInvalidReportToken
+		}
+		return db.InternalError, err
+	}
+
+	report.ScanID = &scan.ID
+	report.DrugID = scan.DrugID
+	report.ValidationOption = scan.ValidationOption
+	report.ValidationData = scan.ValidationData
+	report.BatchNumber = scan.BatchNumber
+	if report.BatchNumber == "" {
+		report.BatchNumber = strings.TrimSpace(r.PostFormValue("batch_number"))
+	}
+	if report.DrugName == "" {
+		report.DrugName = scan.DrugName
+	}
+	return db.None, nil
+}
+
+// extractEvidenceImages saves the evidence_images of the multipart/form-data request
+// into a userId_unixTime directory of the blob store.
+// evidence_images is either a single zip of images or one part per image
+func (app *app) extractEvidenceImages(r *http.Request, userId string) ([]savedImage, db.ErrorType, error) {
+	var files []*multipart.FileHeader
+	if r.MultipartForm != nil {
+		files = r.MultipartForm.File["evidence_images"]
+	}
+
+	saveDir := fmt.Sprintf("%s/%s_%d", app.config.incidenceReportDrugImagePath, userId, time.Now().UnixNano())
+	return saveEvidenceImages(app.store, files, saveDir)
+}
+
+// signedUrlExpiry is how long urls granting access to private blobs remain valid
+const signedUrlExpiry = 15 * time.Minute
+
+// publicUrl resolves the key of a publicly readable blob into its url.
+// Documents stored before blob keys were introduced hold full urls,
+// which are returned unchanged
+func (app *app) publicUrl(key string) string {
+	if key == "" || strings.HasPrefix(key, "http://") || strings.HasPrefix(key, "https://") {
+		return key
+	}
+	return app.store.URL(key)
+}
+
+// signedUrl resolves the key of a private blob into a url
+// that grants access to it for signedUrlExpiry
+func (app *app) signedUrl(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "http://") || strings.HasPrefix(key, "https://") {
+		return key, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return app.store.SignedURL(ctx, key, signedUrlExpiry)
+}
+
+// resolveAnnouncementUrls replaces the blob keys stored in announcement with urls
+func (app *app) resolveAnnouncementUrls(announcement *model.Announcement) {
+	announcement.ImageUrl = app.publicUrl(announcement.ImageUrl)
+	announcement.ThumbnailUrl = app.publicUrl(announcement.ThumbnailUrl)
+}
+
+// resolveIncidenceReportUrls replaces the blob keys stored in report with signed urls.
+// If thumbnailsOnly is true, full size images are left out of report, which
+// lets partners triage reports they are not assigned to without exposing all evidence.
+// Incidence report images are private, so resolveIncidenceReportUrls must only be
+// invoked on reports served to the reporter or HeartNet partners
+func (app *app) resolveIncidenceReportUrls(report *model.IncidenceReport, thumbnailsOnly bool) error {
+	var err error
+	resolve := func(key string) string {
+		url, signErr := app.signedUrl(key)
+		if signErr != nil {
+			err = signErr
+		}
+		return url
+	}
+
+	report.ReceiptThumbnailUrl = resolve(report.ReceiptThumbnailUrl)
+	for i := range report.EvidenceThumbnailsUrl {
+		report.EvidenceThumbnailsUrl[i] = resolve(report.EvidenceThumbnailsUrl[i])
+	}
+
+	if thumbnailsOnly {
+		report.ReceiptImageUrl = ""
+		report.EvidenceImagesUrl = []string{}
+	} else {
+		report.ReceiptImageUrl = resolve(report.ReceiptImageUrl)
+		for i := range report.EvidenceImagesUrl {
+			report.EvidenceImagesUrl[i] = resolve(report.EvidenceImagesUrl[i])
+		}
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to sign incidence report image urls")
+	}
+	return nil
+}
+
+// readQueryString returns the value of key in qs, or defaultValue if key is absent
+func readQueryString(qs url.Values, key, defaultValue string) string {
+	value := strings.TrimSpace(qs.Get(key))
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// readQueryInt returns the integer value of key in qs, or defaultValue if key is absent.
+// If the value isn't an integer, an error is recorded in errs
+func readQueryInt(qs url.Values, key string, defaultValue int, errs map[string]string) int {
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		errs[key] = "must be an integer value"
+		return defaultValue
+	}
+	return i
+}
+
+// readQueryFloat returns the float value of key in qs, or defaultValue if key is absent.
+// If the value isn't a number, an error is recorded in errs
+func readQueryFloat(qs url.Values, key string, defaultValue float64, errs map[string]string) float64 {
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		errs[key] = "must be a number"
+		return defaultValue
+	}
+	return f
+}
+
+// readQueryNear returns the point described by the lat and lng values of qs
+// and the radius_km around it, which defaults to model.DefaultSearchRadiusKm.
+// A nil point is returned if neither lat nor lng is present.
+// Invalid values are recorded in errs
+func readQueryNear(qs url.Values, errs map[string]string) (*model.GeoPoint, float64) {
+	radiusKm := readQueryFloat(qs, "radius_km", model.DefaultSearchRadiusKm, errs)
+	if radiusKm <= 0 || radiusKm > model.MaxSearchRadiusKm {
+		errs["radius_km"] = fmt.Sprintf("must be more than 0 and not more than %.0f", model.MaxSearchRadiusKm)
+	}
+	if qs.Get("lat") == "" && qs.Get("lng") == "" {
+		return nil, radiusKm
+	}
+
+	lat := readQueryFloat(qs, "lat", 0, errs)
+	lng := readQueryFloat(qs, "lng", 0, errs)
+	if qs.Get("lat") == "" || qs.Get("lng") == "" || !model.ValidCoordinates(lat, lng) {
+		errs["lat"] = "lat and lng must both be set to a valid latitude and longitude"
+		return nil, radiusKm
+	}
+	return model.NewGeoPoint(lat, lng), radiusKm
+}
+
+// readQueryObjectID returns the object id value of key in qs, or nil if key is absent.
+// If the value isn't an object id, an error is recorded in errs
+func readQueryObjectID(qs url.Values, key string, errs map[string]string) *primitive.ObjectID {
+	value := qs.Get(key)
+	if value == "" {
+		return nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		errs[key] = "must be a valid id"
+		return nil
+	}
+	return &id
+}
+
+// readQueryDate returns the date value of key in qs, formatted as YYYY-MM-DD.
+// If endOfDay is true, the last instant of the date is returned so that the date
+// can be used as an inclusive upper bound.
+// The zero time is returned if key is absent. If the value isn't a date, an error is recorded in errs
+func readQueryDate(qs url.Values, key string, endOfDay bool, errs map[string]string) time.Time {
+	value := qs.Get(key)
+	if value == "" {
+		return time.Time{}
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		errs[key] = "must be a date formatted as YYYY-MM-DD"
+		return time.Time{}
+	}
+	if endOfDay {
+		date = date.Add(24*time.Hour - time.Nanosecond)
+	}
+	return date
+}
+
+// readQueryBool returns the boolean value of key in qs, or nil if key is absent.
+// If the value isn't a boolean, an error is recorded in errs
+func readQueryBool(qs url.Values, key string, errs map[string]string) *bool {
+	value := qs.Get(key)
+	if value == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		errs[key] = "must be a boolean value"
+		return nil
+	}
+	return &b
+}
+
+// processValidation responds to a drug validation request and records it as a scan.
+// The response carries a report_token that pre-fills an incidence report about the scanned drug,
+// see submitIncidenceReport
+func (app *app) processValidation(w http.ResponseWriter, r *http.Request, drug *model.Drug, scan *model.Scan, err error) {
+	if err != nil && err != db.ErrDrugNotFound {
 		errs := make(map[string]string)
 		errs["error"] = err.Error()
 		app.sendFailedValidationResponse(w, r, errs)
 		return
 	}
-	app.notificationHub.Dispatch(model.NewValidationNotification(userId, "Drug is authentic"))
-	app.sendDrugFoundResponse(w, r, drug)
+
+	scan.ScannedOn = time.Now()
+	scan.Result = model.ScanUnsafe
+	if drug != nil {
+		scan.DrugID = &drug.ID
+		scan.DrugName = drug.Name
+		scan.BatchNumber = drug.BatchNumber
+		scan.Result = model.ScanSafe
+
+		// check that drug is not expiring in the next 7 days
+		if time.Now().Add(time.Hour * 168).After(drug.Expiry) {
+			scan.Result = model.ScanExpired
+		}
+	}
+
+	// failing to record the scan shouldn't fail the validation,
+	// the user just can't pre-fill a report from it
+	report := map[string]interface{}{}
+	if err := app.repo.InsertScan(scan); err != nil {
+		logger.Logger.LogError("failed to record scan", "process validation", err)
+	} else {
+		report["scan_id"] = scan.ID
+		report["report_token"] = app.reportToken(scan)
+		report["drug_name"] = scan.DrugName
+		report["batch_number"] = scan.BatchNumber
+		app.scoreScan(r, scan)
+	}
+
+	if drug == nil {
+		app.sendDrugNotFoundResponse(w, r, report)
+		app.notificationHub.Dispatch(model.NewValidationNotification(scan.UserID, "Drug not found"))
+	} else {
+		app.notificationHub.Dispatch(model.NewValidationNotification(scan.UserID, "Drug is authentic"))
+		app.sendDrugFoundResponse(w, r, drug, scan.Result, report)
+	}
+
+	// a user's first validation completes their referral, if they were referred
+	app.completeReferral(scan.UserID)
+}
+
+// scoreScan adds scan to its user's scans on the leaderboards if it is the first time
+// the user, authenticated by their session, validated an unexpired drug.
+// Validations without a session still work but aren't scored, since anyone can send a user's uid
+func (app *app) scoreScan(r *http.Request, scan *model.Scan) {
+	if scan.DrugID == nil || scan.Result != model.ScanSafe {
+		return
+	}
+
+	session, err := app.authenticateUser(r)
+	if err != nil {
+		logger.Logger.LogError("failed to authenticate scan", "score scan", err)
+		return
+	}
+	if session == nil || session.UserID != scan.UserID {
+		return
+	}
+
+	first, err := app.repo.IsFirstDrugScan(scan)
+	if err != nil {
+		logger.Logger.LogError("failed to check earlier scans of drug", "score scan", err)
+		return
+	}
+	if first {
+		app.recordLeaderboardScore(scan.UserID, model.MetricScans, 1, scan.ScannedOn)
+	}
 }
 
 // sendDrugNotFoundResponse sends appropriate response if drug is not found in repo.
-func (app *app) sendDrugNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+// report pre-fills an incidence report about the scan
+func (app *app) sendDrugNotFoundResponse(w http.ResponseWriter, r *http.Request, report map[string]interface{}) {
 	app.sendAPIResponse(&responseWriterArgs{
 		writer:     w,
 		statusCode: 200,
 		status:     true,
 		message:    "Not Found",
-	}, r, map[string]string{
-		"report_type": "Unsafe",
+	}, r, map[string]interface{}{
+		"report_type": model.ScanUnsafe,
+		"report":      report,
 	})
 }
 
 // sendDrugFoundResponse sends safe or expiry product response,
-// depending on if product expires in the next 7 days
-func (app *app) sendDrugFoundResponse(w http.ResponseWriter, r *http.Request, drug *model.Drug) {
-
-	// check that drug is not expiring in the next 7 days
-	if time.Now().Add(time.Hour * 168).After(drug.Expiry) {
+// depending on if product expires in the next 7 days.
+// report pre-fills an incidence report about the scan
+func (app *app) sendDrugFoundResponse(w http.ResponseWriter, r *http.Request, drug *model.Drug,
+	result model.ScanResult, report map[string]interface{}) {
+	if result == model.ScanExpired {
 		app.sendAPIResponse(&responseWriterArgs{
 			writer:     w,
 			statusCode: 200,
 			status:     true,
 			message:    "Expired Drug",
 		}, r, map[string]interface{}{
-			"report_type": "expired",
+			"report_type": model.ScanExpired,
 			"drug":        drug,
+			"report":      report,
 		})
 		return
 	}
@@ -324,8 +840,9 @@ func (app *app) sendDrugFoundResponse(w http.ResponseWriter, r *http.Request, dr
 		status:     true,
 		message:    "Valid Drug",
 	}, r, map[string]interface{}{
-		"report_type": "safe",
+		"report_type": model.ScanSafe,
 		"drug":        drug,
+		"report":      report,
 	})
 }
 
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listIncidenceReports serves a page of incidence reports for partners to triage.
+// Listed reports carry signed thumbnail urls only, full size images are served
+// with the report details.
+// METHOD: GET
+// Request must contain partner authorization
+// Query parameters (all optional):
+//		state string (one of new, under_investigation, confirmed_counterfeit, dismissed)
+//		pharmacy_name string
+//		location string
+//		drug string
+//		drug_id string
+//		batch string (exact batch number, case-insensitive)
+//		pharmacy_id string
+//		lat float, lng float (restricts the listing to reports around this point)
+//		radius_km float (defaults to 10, not more than 500)
+//		flagged bool (only reports that are, or aren't, flagged for moderation)
+//		q string (full-text search on description)
+//		from date (YYYY-MM-DD)
+//		to date (YYYY-MM-DD)
+//		sort string (one of submitted_on, state, pharmacy_name, prefix with - for descending order)
+//		page int
+//		page_size int (not more than 100)
+func (app *app) listIncidenceReports(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+
+	filter := model.IncidenceReportFilter{
+		State:         model.IncidenceReportState(readQueryString(qs, "state", "")),
+		PharmacyName:  readQueryString(qs, "pharmacy_name", ""),
+		Location:      readQueryString(qs, "location", ""),
+		Drug:          readQueryString(qs, "drug", ""),
+		Search:        readQueryString(qs, "q", ""),
+		SubmittedFrom: readQueryDate(qs, "from", false, errs),
+		SubmittedTo:   readQueryDate(qs, "to", true, errs),
+		Sort:          readQueryString(qs, "sort", "-submitted_on"),
+		Pagination: model.Pagination{
+			Page:     readQueryInt(qs, "page", 1, errs),
+			PageSize: readQueryInt(qs, "page_size", model.DefaultPageSize, errs),
+		},
+	}
+	filter.Near, filter.RadiusKm = readQueryNear(qs, errs)
+	filter.PharmacyID = readQueryObjectID(qs, "pharmacy_id", errs)
+	filter.DrugID = readQueryObjectID(qs, "drug_id", errs)
+	filter.Batch = readQueryString(qs, "batch", "")
+	if flagged := qs.Get("flagged"); flagged != "" {
+		value, err := strconv.ParseBool(flagged)
+		if err != nil {
+			errs["flagged"] = "must be a boolean value"
+		}
+		filter.Flagged = &value
+	}
+
+	if filter.State != "" && !filter.State.IsValid() {
+		errs["state"] = "unknown incidence report state"
+	}
+	if _, ok := model.IncidenceReportSortFields[strings.TrimPrefix(filter.Sort, "-")]; !ok {
+		errs["sort"] = "invalid sort value"
+	}
+	for key, message := range filter.Pagination.Validate() {
+		errs[key] = message
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	reports, metadata, err := app.repo.FetchIncidenceReports(&filter)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	for i := range *reports {
+		if err := app.resolveIncidenceReportUrls(&(*reports)[i], true); err != nil {
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "incidence reports",
+	}, r, map[string]interface{}{
+		"incidence_reports": reports,
+		"metadata":          metadata,
+	})
+}
+
+// showIncidenceReport serves the details of an incidence report, including its
+// updates and state transitions.
+// Only partners assigned to the report by an admin can view it, see assignIncidenceReportPartners.
+// METHOD: GET
+// Request must contain partner authorization
+// URL parameter: id (incidence report id)
+func (app *app) showIncidenceReport(w http.ResponseWriter, r *http.Request) {
+	partner := contextGetPartner(r)
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		app.sendNotFoundResponse(w, r)
+		return
+	}
+
+	report, err := app.repo.FetchIncidenceReport(id)
+	if err != nil {
+		if err == db.ErrIncidenceReportNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	if !report.IsAssignedTo(partner.ID) {
+		app.sendForbiddenResponse(w, r)
+		return
+	}
+
+	if err := app.resolveIncidenceReportUrls(report, false); err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    fmt.Sprintf("incidence report %s", report.ID.Hex()),
+	}, r, report)
+}
+
+// listUserIncidenceReports serves every incidence report submitted by a user,
+// along with their state, updates and signed evidence urls.
+// METHOD: GET
+// Request must contain the user's session token
+// URL parameter: uid
+func (app *app) listUserIncidenceReports(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+	if err := app.repo.IsValidUser(uid); err != nil {
+		if err == db.ErrUserNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	reports, err := app.repo.FetchIncidenceReportsByUserID(uid)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	for i := range *reports {
+		if err := app.resolveIncidenceReportUrls(&(*reports)[i], false); err != nil {
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "your incidence reports",
+	}, r, reports)
+}
+
+// submitIncidenceReportComment records a follow-up comment from the reporter
+// on one of their open incidence reports.
+// METHOD: POST
+// Content-type: application/json
+// Request must contain the user's session token
+// URL parameters: uid, id (incidence report id)
+// Request Body:
+//		message string *required (not more than 1000 characters)
+func (app *app) submitIncidenceReportComment(w http.ResponseWriter, r *http.Request) {
+	if !app.sessionOwns(w, r, chi.URLParam(r, "uid")) {
+		return
+	}
+	var in struct {
+		Message string `json:"message"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	if strings.TrimSpace(in.Message) == "" || len(in.Message) > 1000 {
+		app.sendFailedValidationResponse(w, r, map[string]string{
+			"message": "message is required and must not be more than 1000 characters",
+		})
+		return
+	}
+
+	app.insertReporterFollowUp(w, r, strings.TrimSpace(in.Message), nil)
+}
+
+// submitIncidenceReportEvidence attaches extra evidence images to one of the
+// reporter's open incidence reports.
+// METHOD: POST
+// Content-type: multipart/form-data
+// Request must contain the user's session token
+// URL parameters: uid, id (incidence report id)
+// Request Body:
+// 		evidence_images multipartfile (a zip of images, or one part per image) *required
+//		message string
+func (app *app) submitIncidenceReportEvidence(w http.ResponseWriter, r *http.Request) {
+	if !app.sessionOwns(w, r, chi.URLParam(r, "uid")) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	// Max memory::32 MB
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	// the report is checked before saving any image so that
+	// images aren't stored for reports that can't be followed up on
+	report, ok := app.fetchReporterIncidenceReport(w, r)
+	if !ok {
+		return
+	}
+
+	evidence, errorType, err := app.extractEvidenceImages(r, report.UserID)
+	if err != nil {
+		if errorType == db.InternalError {
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+		app.sendFailedValidationResponse(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+
+	message := strings.TrimSpace(r.PostFormValue("message"))
+	if message == "" {
+		message = fmt.Sprintf("Added %d evidence image(s)", len(evidence))
+	}
+	if !app.insertReporterFollowUp(w, r, message, evidence) {
+		keys, thumbnailKeys, _ := splitSavedImages(evidence)
+		deleteBlobs(app.store, append(keys, thumbnailKeys...)...)
+	}
+}
+
+// insertReporterFollowUp records a follow-up on the incidence report identified by the
+// uid and id URL parameters and sends the response.
+// Returns false if the follow-up wasn't recorded
+func (app *app) insertReporterFollowUp(w http.ResponseWriter, r *http.Request,
+	message string, evidence []savedImage) bool {
+	report, ok := app.fetchReporterIncidenceReport(w, r)
+	if !ok {
+		return false
+	}
+
+	followUp := &model.IncidenceReportUpdate{
+		ID:                primitive.NewObjectID(),
+		IncidenceReportID: report.ID,
+		Message:           message,
+		SentBy:            "reporter",
+		FromReporter:      true,
+		SentOn:            time.Now(),
+	}
+	keys, thumbnailKeys, fingerprints := splitSavedImages(evidence)
+	err := app.repo.InsertReporterFollowUp(followUp, report.UserID, keys, thumbnailKeys, fingerprints)
+	if err != nil {
+		if err == db.ErrEditConflict {
+			app.sendEditConflictResponse(w, r, "incidence report is closed and can no longer be followed up on")
+			return false
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return false
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Your follow-up has been added to the incidence report",
+	}, r, followUp)
+	return true
+}
+
+// fetchReporterIncidenceReport fetches the incidence report identified by the id URL parameter,
+// provided it was submitted by the user identified by the uid URL parameter and is still open.
+// If ok is false, an error response has already been sent
+func (app *app) fetchReporterIncidenceReport(w http.ResponseWriter, r *http.Request) (report *model.IncidenceReport, ok bool) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		app.sendNotFoundResponse(w, r)
+		return nil, false
+	}
+
+	report, err = app.repo.FetchIncidenceReport(id)
+	if err != nil {
+		if err == db.ErrIncidenceReportNotFound {
+			app.sendNotFoundResponse(w, r)
+			return nil, false
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	// reports of other users are reported as not found rather than forbidden
+	// so that report ids can't be probed
+	if report.UserID != chi.URLParam(r, "uid") {
+		app.sendNotFoundResponse(w, r)
+		return nil, false
+	}
+	if !report.CurrentState().IsOpen() {
+		app.sendEditConflictResponse(w, r, "incidence report is closed and can no longer be followed up on")
+		return nil, false
+	}
+	return report, true
+}
+
+// listIncidenceReportDrugGroups serves the drug batches with the most incidence reports,
+// for partners to spot counterfeit batches.
+// Reports are grouped by drug name and batch number, ignoring case.
+// METHOD: GET
+// Request must contain partner authorization
+// Query parameters (all optional):
+//		state string (one of new, under_investigation, confirmed_counterfeit, dismissed)
+//		drug string
+//		batch string (exact batch number, case-insensitive)
+//		from date (YYYY-MM-DD)
+//		to date (YYYY-MM-DD)
+//		page int
+//		page_size int (not more than 100)
+func (app *app) listIncidenceReportDrugGroups(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+
+	filter := model.IncidenceReportFilter{
+		State:         model.IncidenceReportState(readQueryString(qs, "state", "")),
+		Drug:          readQueryString(qs, "drug", ""),
+		Batch:         readQueryString(qs, "batch", ""),
+		SubmittedFrom: readQueryDate(qs, "from", false, errs),
+		SubmittedTo:   readQueryDate(qs, "to", true, errs),
+		Pagination: model.Pagination{
+			Page:     readQueryInt(qs, "page", 1, errs),
+			PageSize: readQueryInt(qs, "page_size", model.DefaultPageSize, errs),
+		},
+	}
+
+	if filter.State != "" && !filter.State.IsValid() {
+		errs["state"] = "unknown incidence report state"
+	}
+	for key, message := range filter.Pagination.Validate() {
+		errs[key] = message
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	groups, metadata, err := app.repo.FetchIncidenceReportDrugGroups(&filter)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "incidence reports per drug batch",
+	}, r, map[string]interface{}{
+		"drug_groups": groups,
+		"metadata":    metadata,
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"net/http"
+	"time"
+)
+
+// recordLeaderboardScore adds amount to the metric of the user identified by uid on the leaderboards,
+// leaderboards are kept up to date as users score rather than computed on every request.
+// Failures are logged since they shouldn't fail the request that scored
+func (app *app) recordLeaderboardScore(uid string, metric model.LeaderboardMetric, amount int, at time.Time) {
+	if amount <= 0 {
+		return
+	}
+	if err := app.repo.IncrementLeaderboardScore(uid, metric, amount, at); err != nil {
+		logger.Logger.LogError(fmt.Sprintf("failed to record %s leaderboard score of %s", metric, uid),
+			"record leaderboard score", err)
+	}
+}
+
+// serveLeaderboard serves the users with the highest scores of a metric over the current week or all time.
+// User ids are masked, the requesting user's own rank is included if user_id is set and they have scored.
+// METHOD: GET
+// Query parameters (all optional):
+//		period string (one of weekly, all_time, defaults to weekly)
+//		metric string (one of scans, confirmed_reports, points, defaults to scans)
+//		user_id string
+//		limit int (not more than 100, defaults to 20)
+func (app *app) serveLeaderboard(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+
+	period := model.LeaderboardPeriod(readQueryString(qs, "period", string(model.LeaderboardWeekly)))
+	metric := model.LeaderboardMetric(readQueryString(qs, "metric", string(model.MetricScans)))
+	userId := readQueryString(qs, "user_id", "")
+	limit := readQueryInt(qs, "limit", model.DefaultPageSize, errs)
+	if !period.IsValid() {
+		errs["period"] = "must be one of weekly, all_time"
+	}
+	if !metric.IsValid() {
+		errs["metric"] = "must be one of scans, confirmed_reports, points"
+	}
+	if limit < 1 || limit > model.MaxPageSize {
+		errs["limit"] = "must be between 1 and 100"
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	key := period.Key(time.Now())
+	scores, err := app.repo.FetchLeaderboard(key, metric, int64(limit))
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	leaderboard := model.Leaderboard{
+		Period:  period,
+		Metric:  metric,
+		Entries: make([]model.LeaderboardEntry, 0, len(*scores)),
+	}
+	if period == model.LeaderboardWeekly {
+		leaderboard.Week = key
+	}
+	for i, score := range *scores {
+		leaderboard.Entries = append(leaderboard.Entries, model.LeaderboardEntry{
+			Rank:   i + 1,
+			UserID: model.MaskUID(score.UserID),
+			Score:  score.Score(metric),
+		})
+	}
+
+	if userId != "" {
+		rank, score, err := app.repo.FetchLeaderboardRank(key, metric, userId)
+		if err != nil && err != db.ErrLeaderboardScoreNotFound {
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+		if err == nil {
+			leaderboard.You = &model.LeaderboardEntry{Rank: rank, UserID: model.MaskUID(userId), Score: score}
+		}
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "leaderboard",
+	}, r, leaderboard)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+// createPartner registers a new HeartNet partner.
+// The partner's api key is only ever returned in this response.
+// METHOD: POST
+// Request must contain admin authorization
+// Request Body:
+//		name string *required
+//		email string *required
+func (app *app) createPartner(w http.ResponseWriter, r *http.Request) {
+	var partner model.Partner
+	if err := app.readJSON(w, r, &partner); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(partner); err != nil {
+		errs := make(map[string]string)
+		for _, err := range err.(validator.ValidationErrors) {
+			errs[err.Field()] = err.Error()
+		}
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	apiKey, err := generateAPIKey()
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	partner.APIKeyHash = model.HashAPIKey(apiKey)
+	partner.CreatedOn = time.Now()
+
+	if err := app.repo.InsertPartner(&partner); err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusCreated,
+		status:     true,
+		message:    "Partner registered. Store the api key safely, it will not be shown again",
+	}, r, map[string]interface{}{
+		"partner": partner,
+		"api_key": apiKey,
+	})
+}
+
+// assignIncidenceReportPartners grants partners access to an incidence report
+// and its evidence images.
+// METHOD: POST
+// Request must contain admin authorization
+// URL parameter: id (incidence report id)
+// Request Body:
+//		partner_ids []string *required
+func (app *app) assignIncidenceReportPartners(w http.ResponseWriter, r *http.Request) {
+	reportId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		app.sendNotFoundResponse(w, r)
+		return
+	}
+
+	var in struct {
+		PartnerIDs []primitive.ObjectID `json:"partner_ids"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	if len(in.PartnerIDs) == 0 {
+		app.sendFailedValidationResponse(w, r, map[string]string{"partner_ids": "at least one partner id is required"})
+		return
+	}
+
+	err = app.repo.AssignIncidenceReportPartners(reportId, in.PartnerIDs)
+	if err != nil {
+		switch err {
+		case db.ErrIncidenceReportNotFound:
+			app.sendNotFoundResponse(w, r)
+		case db.ErrPartnerNotFound:
+			app.sendFailedValidationResponse(w, r, map[string]string{"partner_ids": "unknown partner id"})
+		default:
+			app.sendServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Partners assigned to incidence report",
+	}, r, nil)
+}
+
+// generateAPIKey returns a random 256-bit key, hex encoded
+func generateAPIKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.Wrap(err, "failed to generate api key")
+	}
+	return hex.EncodeToString(key), nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// listPharmacies serves a page of the pharmacy registry, e.g., for reporters
+// to pick the pharmacy an incidence report is about.
+// Registered pharmacies are listed before suggested ones.
+// METHOD: GET
+// Query parameters (all optional):
+//		q string (matches pharmacy name and address)
+//		status string (one of registered, suggested)
+//		lat float, lng float (restricts the listing to pharmacies around this point)
+//		radius_km float (defaults to 10, not more than 500)
+//		page int
+//		page_size int (not more than 100)
+func (app *app) listPharmacies(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+
+	filter := model.PharmacyFilter{
+		Search: readQueryString(qs, "q", ""),
+		Status: model.PharmacyStatus(readQueryString(qs, "status", "")),
+		Pagination: model.Pagination{
+			Page:     readQueryInt(qs, "page", 1, errs),
+			PageSize: readQueryInt(qs, "page_size", model.DefaultPageSize, errs),
+		},
+	}
+	filter.Near, filter.RadiusKm = readQueryNear(qs, errs)
+
+	if filter.Status != "" && filter.Status != model.PharmacyRegistered && filter.Status != model.PharmacySuggested {
+		errs["status"] = "must be one of registered, suggested"
+	}
+	for key, message := range filter.Pagination.Validate() {
+		errs[key] = message
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	pharmacies, metadata, err := app.repo.FetchPharmacies(&filter)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "pharmacies",
+	}, r, map[string]interface{}{
+		"pharmacies": pharmacies,
+		"metadata":   metadata,
+	})
+}
+
+// pharmacyInput is the request body of the admin pharmacy endpoints
+type pharmacyInput struct {
+	Name          string   `json:"name"`
+	Address       string   `json:"address"`
+	LicenceNumber string   `json:"licence_number"`
+	Latitude      *float64 `json:"latitude"`
+	Longitude     *float64 `json:"longitude"`
+}
+
+// location returns the point described by in's latitude and longitude, which may be nil.
+// Invalid values are recorded in errs
+func (in *pharmacyInput) location(errs map[string]string) *model.GeoPoint {
+	if in.Latitude == nil && in.Longitude == nil {
+		return nil
+	}
+	if in.Latitude == nil || in.Longitude == nil || !model.ValidCoordinates(*in.Latitude, *in.Longitude) {
+		errs["latitude"] = "latitude and longitude must both be set to a valid latitude and longitude"
+		return nil
+	}
+	return model.NewGeoPoint(*in.Latitude, *in.Longitude)
+}
+
+// createPharmacy adds a registered pharmacy to the registry.
+// METHOD: POST
+// Request must contain admin authorization
+// Request Body:
+//		name string *required
+//		address string *required
+//		licence_number string *required
+//		latitude float, longitude float
+func (app *app) createPharmacy(w http.ResponseWriter, r *http.Request) {
+	var in pharmacyInput
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	errs := make(map[string]string)
+	pharmacy := model.Pharmacy{
+		Name:          strings.TrimSpace(in.Name),
+		Address:       strings.TrimSpace(in.Address),
+		LicenceNumber: strings.TrimSpace(in.LicenceNumber),
+		Location:      in.location(errs),
+		Status:        model.PharmacyRegistered,
+		CreatedOn:     time.Now(),
+	}
+	pharmacy.SearchKey = model.PharmacySearchKey(pharmacy.Name, pharmacy.Address)
+	if pharmacy.Name == "" {
+		errs["name"] = "must be provided"
+	}
+	if pharmacy.Address == "" {
+		errs["address"] = "must be provided"
+	}
+	if pharmacy.LicenceNumber == "" {
+		errs["licence_number"] = "must be provided"
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	if err := app.repo.InsertPharmacy(&pharmacy); err != nil {
+		if err == db.ErrDuplicatePharmacy {
+			app.sendFailedValidationResponse(w, r, map[string]string{"licence_number": err.Error()})
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusCreated,
+		status:     true,
+		message:    "Pharmacy registered",
+	}, r, pharmacy)
+}
+
+// registerPharmacy vets a pharmacy suggested by reporters, turning it into a registered pharmacy.
+// Already registered pharmacies can be updated the same way.
+// METHOD: POST
+// Request must contain admin authorization
+// URL parameter: id (pharmacy id)
+// Request Body:
+//		licence_number string *required
+//		latitude float, longitude float
+func (app *app) registerPharmacy(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		app.sendNotFoundResponse(w, r)
+		return
+	}
+
+	var in pharmacyInput
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	errs := make(map[string]string)
+	location := in.location(errs)
+	licenceNumber := strings.TrimSpace(in.LicenceNumber)
+	if licenceNumber == "" {
+		errs["licence_number"] = "must be provided"
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	pharmacy, err := app.repo.RegisterPharmacy(id, licenceNumber, location)
+	if err != nil {
+		switch err {
+		case db.ErrPharmacyNotFound:
+			app.sendNotFoundResponse(w, r)
+		case db.ErrDuplicatePharmacy:
+			app.sendFailedValidationResponse(w, r, map[string]string{"licence_number": err.Error()})
+		default:
+			app.sendServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Pharmacy registered",
+	}, r, pharmacy)
+}
+
+// listPharmacyHotspots serves the pharmacies with the most incidence reports,
+// optionally around a point, e.g., the partner's location.
+// METHOD: GET
+// Request must contain partner authorization
+// Query parameters (all optional):
+//		lat float, lng float (restricts the aggregation to reports around this point)
+//		radius_km float (defaults to 10, not more than 500)
+//		state string (one of new, under_investigation, confirmed_counterfeit, dismissed)
+//		drug string
+//		from date (YYYY-MM-DD)
+//		to date (YYYY-MM-DD)
+//		page int
+//		page_size int (not more than 100)
+func (app *app) listPharmacyHotspots(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+
+	filter := model.IncidenceReportFilter{
+		State:         model.IncidenceReportState(readQueryString(qs, "state", "")),
+		Drug:          readQueryString(qs, "drug", ""),
+		SubmittedFrom: readQueryDate(qs, "from", false, errs),
+		SubmittedTo:   readQueryDate(qs, "to", true, errs),
+		Pagination: model.Pagination{
+			Page:     readQueryInt(qs, "page", 1, errs),
+			PageSize: readQueryInt(qs, "page_size", model.DefaultPageSize, errs),
+		},
+	}
+	filter.Near, filter.RadiusKm = readQueryNear(qs, errs)
+
+	if filter.State != "" && !filter.State.IsValid() {
+		errs["state"] = "unknown incidence report state"
+	}
+	for key, message := range filter.Pagination.Validate() {
+		errs[key] = message
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	hotspots, metadata, err := app.repo.FetchPharmacyHotspots(&filter)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "pharmacy hotspots",
+	}, r, map[string]interface{}{
+		"hotspots": hotspots,
+		"metadata": metadata,
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+const (
+	// referralSharedIPLimit is how many rewarded referrals of the same referrer can come from an IP address,
+	// a few are allowed since users behind the same network, e.g., a mobile carrier, share addresses
+	referralSharedIPLimit = 3
+
+	// maxListedReferrals caps the referrals listed by listUserReferrals
+	maxListedReferrals = 50
+)
+
+var errTooManyReferrals = errors.New("this referral code was used too often today, please try again later")
+
+// referrer returns the user who owns the referral code, provided the code can bring in another user today.
+// Returns db.ErrReferralCodeNotFound if no user owns code and errTooManyReferrals if the code
+// reached the daily referral limit
+func (app *app) referrer(code string) (*model.User, error) {
+	user, err := app.repo.FetchUserByReferralCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if limit := app.config.referrals.dailyLimit; limit > 0 {
+		count, err := app.repo.CountReferralsSince(user.UID, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		if count >= int64(limit) {
+			return nil, errTooManyReferrals
+		}
+	}
+	return user, nil
+}
+
+// completeReferral rewards the referrer of the user identified by inviteeId, if any, the first time
+// the user validates a drug. Referrals whose invitee signed up from the device, or too often from
+// the IP address, of the referrer's other rewarded referrals aren't rewarded, nor are referrals of
+// users who reached the reward cap.
+// Failures are logged since they shouldn't fail the validation
+func (app *app) completeReferral(inviteeId string) {
+	referral, err := app.repo.CompleteReferral(inviteeId, time.Now())
+	if err != nil {
+		if err != db.ErrReferralNotFound {
+			logger.Logger.LogError(fmt.Sprintf("failed to complete referral of %s", inviteeId), "complete referral", err)
+		}
+		return
+	}
+
+	reason, err := app.referralRewardDenial(referral)
+	if err != nil {
+		logger.Logger.LogError(fmt.Sprintf("failed to reward referral %s", referral.ID.Hex()), "complete referral", err)
+		return
+	}
+	if reason != "" {
+		if err := app.repo.SettleReferral(referral.ID, model.ReferralUnrewarded, reason, primitive.NilObjectID); err != nil {
+			logger.Logger.LogError(fmt.Sprintf("failed to settle referral %s", referral.ID.Hex()), "complete referral", err)
+		}
+		return
+	}
+
+	reward := &model.Reward{
+		UserID:     referral.ReferrerID,
+		ReferralID: referral.ID,
+		Points:     app.config.referrals.rewardPoints,
+		HrtTokens:  app.config.referrals.rewardHrtTokens,
+		RecordedOn: time.Now(),
+	}
+	if err := app.repo.RecordReward(reward); err != nil {
+		logger.Logger.LogError(fmt.Sprintf("failed to reward referral %s", referral.ID.Hex()), "complete referral", err)
+		if err := app.repo.ReleaseReferralReward(referral.ReferrerID); err != nil {
+			logger.Logger.LogError("failed to release referral reward", "complete referral", err)
+		}
+		return
+	}
+	app.recordLeaderboardScore(reward.UserID, model.MetricPoints, reward.Points, reward.RecordedOn)
+	if err := app.repo.SettleReferral(referral.ID, model.ReferralRewarded, "", reward.ID); err != nil {
+		logger.Logger.LogError(fmt.Sprintf("failed to settle referral %s", referral.ID.Hex()), "complete referral", err)
+	}
+	app.notificationHub.Dispatch(model.NewReferralRewardNotification(referral.ReferrerID, reward))
+}
+
+// referralRewardDenial returns why referral shouldn't be rewarded, or an empty string if it should,
+// in which case a reward is reserved against the referrer's reward cap
+func (app *app) referralRewardDenial(referral *model.Referral) (string, error) {
+	sameDevice, sameIP, err := app.repo.CountRewardedReferralsSharingSignup(referral)
+	if err != nil {
+		return "", err
+	}
+	if sameDevice > 0 {
+		return "invitee signed up from the device of another rewarded referral", nil
+	}
+	if sameIP >= referralSharedIPLimit {
+		return "too many rewarded referrals signed up from the invitee's IP address", nil
+	}
+
+	if err := app.repo.ReserveReferralReward(referral.ReferrerID, app.config.referrals.rewardCap); err != nil {
+		if err == db.ErrReferralRewardCapReached {
+			return "referrer reached the referral reward cap", nil
+		}
+		return "", err
+	}
+	return "", nil
+}
+
+// listUserReferrals serves the user's referral code, which is generated on first request
+// for users who signed up before referral codes were introduced, along with their referrals.
+// Invitees' uids are masked, see model.MaskUID, since uids are account credentials.
+// METHOD: GET
+// Request must contain the user's session token
+// URL parameter: uid
+func (app *app) listUserReferrals(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+
+	code, err := app.repo.AssignReferralCode(uid)
+	if err != nil {
+		if err == db.ErrUserNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	summary := &model.ReferralSummary{Code: code}
+	if err := app.repo.FetchReferralSummary(uid, maxListedReferrals, summary); err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	for i := range summary.Referrals {
+		summary.Referrals[i].InviteeID = model.MaskUID(summary.Referrals[i].InviteeID)
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "referrals",
+	}, r, summary)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const userEditConflictMessage = "user was modified by another request, fetch the user again and retry"
+
+// readOnlyUserFields are the fields of a user that can't be patched, with the reason given to clients
+var readOnlyUserFields = map[string]string{
+	"id":                 "cannot be changed",
+	"user_id":            "cannot be changed",
+	"wallet_addr":        "wallets are linked by proving ownership, see POST /api/users/{uid}/wallet/challenge",
+	"wallet_verified_on": "cannot be changed",
+	"email_verified_on":  "cannot be changed",
+	"referral_code":      "cannot be changed",
+}
+
+// patchUser partially updates the user's profile with JSON merge-patch semantics (RFC 7396):
+// fields left out of the request body are unchanged and fields set to null are removed.
+// The request body must contain the version of the user the client last read, the update is
+// rejected with a conflict if the user changed since. Every update is recorded, see listUserChanges:
+// the change is recorded first and removed if the update fails, so that no update goes unrecorded.
+// Emails can be removed, but are only linked by verifying them, see requestEmailVerification.
+// METHOD: PATCH
+// Request must contain the user's session token
+// URL parameter: uid
+// Request Body:
+//		version int *required
+//		dob date (YYYY-MM-DD or RFC 3339) or null, users must be at least 13 years old
+//		email null
+//		push_notification_token string or null
+func (app *app) patchUser(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+
+	var body map[string]json.RawMessage
+	if err := app.readJSON(w, r, &body); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	app.applyUserPatch(w, r, uid, body)
+}
+
+// updateUserSunset is when POST /api/update-user is removed, see updateUser
+const updateUserSunset = "Sun, 31 Jan 2027 00:00:00 GMT"
+
+// updateUser is the deprecated alias of patchUser for apps released before it, served until
+// updateUserSunset. The uid is read from the body, and the update applies to the latest
+// version of the user when the body has no version, as these apps don't know about versions.
+// Responses carry the Deprecation and Sunset headers.
+// Method: POST
+// Request must contain the user's session token
+// Request Body:
+//		user_id string *required
+//		version int
+//		dob date (YYYY-MM-DD or RFC 3339) or null, users must be at least 13 years old
+//		push_notification_token string or null
+func (app *app) updateUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Sunset", updateUserSunset)
+
+	var body map[string]json.RawMessage
+	if err := app.readJSON(w, r, &body); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+	var uid string
+	if err := json.Unmarshal(body["user_id"], &uid); err != nil || uid == "" {
+		app.sendFailedValidationResponse(w, r, map[string]string{"user_id": "must be provided"})
+		return
+	}
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+	w.Header().Set("Link", fmt.Sprintf(`</api/users/%s>; rel="successor-version"`, uid))
+	delete(body, "user_id")
+
+	if _, ok := body["version"]; !ok {
+		user, err := app.repo.FetchUser(uid)
+		if err != nil {
+			if err == db.ErrUserNotFound {
+				app.sendNotFoundResponse(w, r)
+				return
+			}
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+		body["version"], _ = json.Marshal(user.Version)
+	}
+	app.applyUserPatch(w, r, uid, body)
+}
+
+// applyUserPatch applies the merge-patch body to the user identified by uid, see patchUser
+func (app *app) applyUserPatch(w http.ResponseWriter, r *http.Request, uid string, body map[string]json.RawMessage) {
+	user, err := app.repo.FetchUser(uid)
+	if err != nil {
+		if err == db.ErrUserNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	errs := make(map[string]string)
+	version := readPatchVersion(body, errs)
+	patch, changes := readUserPatch(body, user, time.Now(), errs)
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+	if version != user.Version {
+		app.sendEditConflictResponse(w, r, userEditConflictMessage)
+		return
+	}
+
+	if !patch.IsEmpty() {
+		change := model.UserChange{
+			UserID:    uid,
+			Version:   version + 1,
+			Changes:   changes,
+			ChangedOn: time.Now(),
+		}
+		if err := app.repo.InsertUserChange(&change); err != nil {
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+
+		user, err = app.repo.PatchUser(uid, version, patch)
+		if err != nil {
+			if err := app.repo.DeleteUserChange(change.ID); err != nil {
+				logger.Logger.LogError("failed to remove the change of a failed user update", "patch user", err)
+			}
+			switch err {
+			case db.ErrUserNotFound:
+				app.sendNotFoundResponse(w, r)
+			case db.ErrEditConflict:
+				app.sendEditConflictResponse(w, r, userEditConflictMessage)
+			default:
+				app.sendServerErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "User update successful",
+	}, r, user)
+}
+
+// readPatchVersion reads the required version member of a patch body, recording a validation error in errs
+func readPatchVersion(body map[string]json.RawMessage, errs map[string]string) int {
+	raw, ok := body["version"]
+	if !ok {
+		errs["version"] = "must be provided"
+		return 0
+	}
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil || version < 0 {
+		errs["version"] = "must be the version of the user last read"
+	}
+	return version
+}
+
+// readUserPatch turns the merge-patch body into the update of user, leaving out fields that
+// don't change, and the changes to record in the user's history. Invalid fields are recorded in errs
+func readUserPatch(body map[string]json.RawMessage, user *model.User, now time.Time,
+	errs map[string]string) (*model.UserPatch, []model.UserFieldChange) {
+
+	patch := &model.UserPatch{Set: make(map[string]interface{})}
+	changes := make([]model.UserFieldChange, 0)
+
+	for key := range body {
+		switch key {
+		case "version", "dob", "email", "push_notification_token":
+		default:
+			if message, ok := readOnlyUserFields[key]; ok {
+				errs[key] = message
+			} else {
+				errs[key] = "unknown field"
+			}
+		}
+	}
+
+	if raw, ok := body["dob"]; ok {
+		dob, isNull := readPatchDate(raw, now, errs)
+		switch {
+		case isNull && !user.DateOfBirth.IsZero():
+			patch.Unset = append(patch.Unset, "dob")
+			changes = append(changes, model.UserFieldChange{Field: "dob", From: user.DateOfBirth})
+		case !isNull && !dob.IsZero() && !dob.Equal(user.DateOfBirth):
+			patch.Set["dob"] = dob
+			change := model.UserFieldChange{Field: "dob", To: dob}
+			if !user.DateOfBirth.IsZero() {
+				change.From = user.DateOfBirth
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	if raw, ok := body["email"]; ok {
+		email, isNull := readPatchString(raw, "email", errs)
+		switch {
+		case isNull && user.Email != "":
+			patch.Unset = append(patch.Unset, "email", "emailVerifiedOn")
+			changes = append(changes, model.UserFieldChange{Field: "email", From: user.Email})
+		case !isNull && errs["email"] == "":
+			if email = readEmail(email, errs); errs["email"] == "" && email != user.Email {
+				errs["email"] = "emails are linked by verifying them, see POST /api/users/{uid}/email/verification"
+			}
+		}
+	}
+
+	// push notification tokens are credentials, their values aren't kept in the history
+	if raw, ok := body["push_notification_token"]; ok {
+		token, isNull := readPatchString(raw, "push_notification_token", errs)
+		token = strings.TrimSpace(token)
+		switch {
+		case (isNull || token == "") && user.PushNotificationToken != "":
+			patch.Unset = append(patch.Unset, "pushNotificationToken")
+			changes = append(changes, model.UserFieldChange{Field: "push_notification_token"})
+		case token != "" && token != user.PushNotificationToken:
+			patch.Set["pushNotificationToken"] = token
+			changes = append(changes, model.UserFieldChange{Field: "push_notification_token"})
+		}
+	}
+	return patch, changes
+}
+
+// readPatchString reads a string or null member of a patch body, recording a validation error in errs
+func readPatchString(raw json.RawMessage, key string, errs map[string]string) (value string, isNull bool) {
+	if string(raw) == "null" {
+		return "", true
+	}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		errs[key] = "must be a string or null"
+	}
+	return value, false
+}
+
+// readPatchDate reads the date of birth member of a patch body, which is either null or a date in
+// the past at least model.MinUserAge years before now. Invalid dates are recorded in errs
+func readPatchDate(raw json.RawMessage, now time.Time, errs map[string]string) (dob time.Time, isNull bool) {
+	value, isNull := readPatchString(raw, "dob", errs)
+	if isNull || errs["dob"] != "" {
+		return time.Time{}, isNull
+	}
+
+	dob, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		if dob, err = time.Parse(time.RFC3339, value); err != nil {
+			errs["dob"] = "must be a date (YYYY-MM-DD)"
+			return time.Time{}, false
+		}
+	}
+	switch {
+	case !dob.Before(now):
+		errs["dob"] = "must be in the past"
+	case dob.AddDate(model.MinUserAge, 0, 0).After(now):
+		errs["dob"] = fmt.Sprintf("users must be at least %d years old", model.MinUserAge)
+	default:
+		return dob, false
+	}
+	return time.Time{}, false
+}
+
+// listUserChanges serves a page of the change history of the user's profile, most recent first.
+// METHOD: GET
+// Request must contain the user's session token
+// URL parameter: uid
+// Query parameters (all optional):
+//		page int
+//		page_size int (not more than 100)
+func (app *app) listUserChanges(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+	pagination := model.Pagination{
+		Page:     readQueryInt(qs, "page", 1, errs),
+		PageSize: readQueryInt(qs, "page_size", model.DefaultPageSize, errs),
+	}
+	for key, message := range pagination.Validate() {
+		errs[key] = message
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	changes, metadata, err := app.repo.FetchUserChanges(uid, pagination)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "user changes",
+	}, r, map[string]interface{}{
+		"changes":  changes,
+		"metadata": metadata,
+	})
+}
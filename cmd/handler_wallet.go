@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/Hrtnet/social-activities/internal/wallet"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+// walletChallengeExpiry is how long a user has to sign a wallet challenge
+const walletChallengeExpiry = 10 * time.Minute
+
+// createWalletChallenge issues the message a user signs with their wallet to link it to their account,
+// see verifyWallet. Requesting a new challenge invalidates the previous one.
+// Users with a wallet linked must unlink it before linking another, see unlinkWallet.
+// METHOD: POST
+// Request must contain the user's session token
+// URL parameter: uid
+// Request Body:
+//		address string *required (the EVM address of the wallet)
+func (app *app) createWalletChallenge(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+	var in struct {
+		Address string `json:"address"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	address, err := wallet.Normalise(in.Address)
+	if err != nil {
+		app.sendFailedValidationResponse(w, r, map[string]string{"address": err.Error()})
+		return
+	}
+	user, err := app.repo.FetchUser(uid)
+	if err != nil {
+		if err == db.ErrUserNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	if user.WalletAddress != "" && user.WalletAddress != address {
+		app.sendEditConflictResponse(w, r, db.ErrWalletAlreadyLinked.Error())
+		return
+	}
+
+	challenge, err := app.issueWalletChallenge(uid, address, "HeartNet wants you to link this wallet to your account.")
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusCreated,
+		status:     true,
+		message:    "Sign the message with your wallet to link it to your account",
+	}, r, challenge)
+}
+
+// issueWalletChallenge stores a new challenge for the user identified by uid to sign with the wallet at address,
+// replacing any challenge previously issued to them. intro tells the user what signing the challenge does
+func (app *app) issueWalletChallenge(uid, address, intro string) (*model.WalletChallenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	challenge := &model.WalletChallenge{
+		UserID:  uid,
+		Address: address,
+		Message: fmt.Sprintf("%s\n\nWallet: %s\nUser ID: %s\nNonce: %s\nIssued at: %s",
+			intro, address, uid, hex.EncodeToString(nonce), now.UTC().Format(time.RFC3339)),
+		CreatedOn: now,
+		ExpiresOn: now.Add(walletChallengeExpiry),
+	}
+	if err := app.repo.InsertWalletChallenge(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// verifyWallet links a wallet to the user's account once they prove they own it
+// by signing the message of their challenge, see createWalletChallenge.
+// The challenge is used up whether the signature is valid or not.
+// METHOD: POST
+// Request must contain the user's session token
+// URL parameter: uid
+// Request Body:
+//		address string *required (the address the challenge was issued for)
+//		signature string *required (the hex encoded personal_sign signature of the challenge message)
+func (app *app) verifyWallet(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+	var in struct {
+		Address   string `json:"address"`
+		Signature string `json:"signature"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	address, err := wallet.Normalise(in.Address)
+	if err != nil {
+		app.sendFailedValidationResponse(w, r, map[string]string{"address": err.Error()})
+		return
+	}
+	if in.Signature == "" {
+		app.sendFailedValidationResponse(w, r, map[string]string{"signature": "must be provided"})
+		return
+	}
+
+	challenge, err := app.repo.ConsumeWalletChallenge(uid, address)
+	if err != nil {
+		if err == db.ErrWalletChallengeNotFound {
+			app.sendFailedValidationResponse(w, r, map[string]string{"address": err.Error()})
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := wallet.VerifyPersonalSign(address, challenge.Message, in.Signature); err != nil {
+		if errors.Is(err, wallet.ErrInvalidSignature) || errors.Is(err, wallet.ErrSignerMismatch) {
+			app.sendFailedValidationResponse(w, r, map[string]string{"signature": err.Error()})
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	verifiedOn := time.Now()
+	if err := app.repo.LinkWallet(uid, address, verifiedOn); err != nil {
+		if err == db.ErrUserNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		if err == db.ErrWalletInUse || err == db.ErrWalletAlreadyLinked {
+			app.sendEditConflictResponse(w, r, err.Error())
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Wallet linked to your account",
+	}, r, map[string]interface{}{
+		"wallet_addr":        address,
+		"wallet_verified_on": verifiedOn,
+	})
+}
+
+// unlinkWallet removes the wallet linked to the user's account, so that another wallet can be linked.
+// METHOD: DELETE
+// Request must contain the user's session token
+// URL parameter: uid
+func (app *app) unlinkWallet(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	if !app.sessionOwns(w, r, uid) {
+		return
+	}
+
+	if err := app.repo.UnlinkWallet(uid); err != nil {
+		if err == db.ErrUserNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Wallet unlinked from your account",
+	}, r, nil)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/Hrtnet/social-activities/internal/webhook"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxWebhooksPerPartner caps the webhooks a partner can register
+const maxWebhooksPerPartner = 10
+
+// createWebhook registers a url the partner receives events on, see package webhook
+// for the format and signature of deliveries.
+// The webhook secret is only ever returned in this response.
+// METHOD: POST
+// Request must contain partner authorization
+// Request Body:
+//		url string *required (https, or http in development, resolving to public addresses only)
+//		events []string (any of incidence_report.created, incidence_report.state_changed,
+//			recall.issued, defaults to every event)
+func (app *app) createWebhook(w http.ResponseWriter, r *http.Request) {
+	partner := contextGetPartner(r)
+	var in struct {
+		URL    string                   `json:"url"`
+		Events []model.WebhookEventType `json:"events"`
+	}
+	if err := app.readJSON(w, r, &in); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	errs := make(map[string]string)
+	target, err := url.Parse(strings.TrimSpace(in.URL))
+	switch {
+	case err != nil || target.Host == "" || (target.Scheme != "https" && target.Scheme != "http"):
+		errs["url"] = "must be an absolute http(s) url"
+	case target.Scheme == "http" && app.config.environment == model.Production:
+		errs["url"] = "must be an https url"
+	default:
+		if err := webhook.CheckDestination(r.Context(), target.Hostname()); err != nil {
+			if err == webhook.ErrPrivateDestination {
+				errs["url"] = "must not point to a private, loopback or link-local address"
+			} else {
+				errs["url"] = "host could not be resolved"
+			}
+		}
+	}
+	if len(in.Events) == 0 {
+		in.Events = model.WebhookEventTypes
+	}
+	for _, event := range in.Events {
+		if !event.IsValid() {
+			errs["events"] = fmt.Sprintf("unknown event %s", event)
+		}
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	existing, err := app.repo.FetchWebhooksByPartnerID(partner.ID)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	if len(*existing) >= maxWebhooksPerPartner {
+		app.sendFailedValidationResponse(w, r, map[string]string{
+			"url": fmt.Sprintf("not more than %d webhooks can be registered", maxWebhooksPerPartner),
+		})
+		return
+	}
+
+	secret, err := generateAPIKey()
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	webhook := &model.Webhook{
+		PartnerID: partner.ID,
+		URL:       target.String(),
+		Secret:    secret,
+		Events:    in.Events,
+		CreatedOn: time.Now(),
+	}
+	if err := app.repo.InsertWebhook(webhook); err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusCreated,
+		status:     true,
+		message:    "Webhook registered. Store the secret safely, it will not be shown again",
+	}, r, map[string]interface{}{
+		"webhook": webhook,
+		"secret":  secret,
+	})
+}
+
+// listWebhooks serves the webhooks of the partner.
+// METHOD: GET
+// Request must contain partner authorization
+func (app *app) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.repo.FetchWebhooksByPartnerID(contextGetPartner(r).ID)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "webhooks",
+	}, r, webhooks)
+}
+
+// deleteWebhook deletes one of the partner's webhooks. Pending deliveries to it are cancelled.
+// METHOD: DELETE
+// Request must contain partner authorization
+// URL parameter: id (webhook id)
+func (app *app) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		app.sendNotFoundResponse(w, r)
+		return
+	}
+
+	if err := app.repo.DeleteWebhook(id, contextGetPartner(r).ID); err != nil {
+		if err == db.ErrWebhookNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "Webhook deleted",
+	}, r, nil)
+}
+
+// listWebhookDeliveries serves the delivery log of one of the partner's webhooks, most recent first.
+// METHOD: GET
+// Request must contain partner authorization
+// URL parameter: id (webhook id)
+// Query parameters (all optional):
+//		status string (one of pending, delivered, failed)
+//		page int
+//		page_size int (not more than 100)
+func (app *app) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.fetchPartnerWebhook(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+	filter := model.WebhookDeliveryFilter{
+		WebhookID: webhook.ID,
+		Status:    model.DeliveryStatus(readQueryString(qs, "status", "")),
+		Pagination: model.Pagination{
+			Page:     readQueryInt(qs, "page", 1, errs),
+			PageSize: readQueryInt(qs, "page_size", model.DefaultPageSize, errs),
+		},
+	}
+	switch filter.Status {
+	case "", model.DeliveryPending, model.DeliveryDelivered, model.DeliveryFailed:
+	default:
+		errs["status"] = "must be one of pending, delivered, failed"
+	}
+	for key, message := range filter.Pagination.Validate() {
+		errs[key] = message
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	deliveries, metadata, err := app.repo.FetchWebhookDeliveries(&filter)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "webhook deliveries",
+	}, r, map[string]interface{}{
+		"deliveries": deliveries,
+		"metadata":   metadata,
+	})
+}
+
+// replayWebhookDelivery sends the event of a past delivery again, as a new delivery.
+// Any delivery can be replayed, e.g., to recover events a receiver failed to process.
+// METHOD: POST
+// Request must contain partner authorization
+// URL parameter: id (webhook delivery id)
+func (app *app) replayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		app.sendNotFoundResponse(w, r)
+		return
+	}
+
+	delivery, err := app.repo.FetchWebhookDelivery(id)
+	if err != nil {
+		if err == db.ErrWebhookDeliveryNotFound {
+			app.sendNotFoundResponse(w, r)
+			return
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+	if delivery.PartnerID != contextGetPartner(r).ID {
+		app.sendNotFoundResponse(w, r)
+		return
+	}
+	if _, ok := app.fetchPartnerWebhook(w, r, delivery.WebhookID.Hex()); !ok {
+		return
+	}
+
+	replay, err := app.webhooks.Replay(delivery)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusAccepted,
+		status:     true,
+		message:    "Webhook delivery scheduled",
+	}, r, replay)
+}
+
+// fetchPartnerWebhook fetches the webhook identified by id, provided it belongs to the partner.
+// If ok is false, an error response has already been sent
+func (app *app) fetchPartnerWebhook(w http.ResponseWriter, r *http.Request, id string) (webhook *model.Webhook, ok bool) {
+	webhookId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		app.sendNotFoundResponse(w, r)
+		return nil, false
+	}
+
+	webhook, err = app.repo.FetchWebhook(webhookId)
+	if err != nil {
+		if err == db.ErrWebhookNotFound {
+			app.sendNotFoundResponse(w, r)
+			return nil, false
+		}
+		app.sendServerErrorResponse(w, r, err)
+		return nil, false
+	}
+	if webhook.PartnerID != contextGetPartner(r).ID {
+		app.sendNotFoundResponse(w, r)
+		return nil, false
+	}
+	return webhook, true
+}
+
+// createRecall issues a recall of a drug batch and broadcasts it to partner webhooks.
+// METHOD: POST
+// Request must contain partner authorization
+// Request Body:
+//		drug_name string *required
+//		batch_number string *required
+//		reason string *required
+//		manufacturer string
+//		drug_id string
+func (app *app) createRecall(w http.ResponseWriter, r *http.Request) {
+	partner := contextGetPartner(r)
+	var recall model.Recall
+	if err := app.readJSON(w, r, &recall); err != nil {
+		app.sendBadRequestResponse(w, r, err)
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(recall); err != nil {
+		errs := make(map[string]string)
+		for _, err := range err.(validator.ValidationErrors) {
+			errs[err.Field()] = err.Error()
+		}
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+	recall.ID = primitive.NilObjectID
+	recall.IssuedBy = partner.Name
+	recall.IssuedByID = partner.ID
+	recall.IssuedOn = time.Now()
+
+	if err := app.repo.InsertRecall(&recall); err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: http.StatusCreated,
+		status:     true,
+		message:    "Recall issued",
+	}, r, recall)
+	app.webhooks.PublishAsync(model.EventRecallIssued, recall)
+}
+
+// listRecalls serves a page of drug recalls, most recent first.
+// METHOD: GET
+// Query parameters (all optional):
+//		page int
+//		page_size int (not more than 100)
+func (app *app) listRecalls(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+	pagination := model.Pagination{
+		Page:     readQueryInt(qs, "page", 1, errs),
+		PageSize: readQueryInt(qs, "page_size", model.DefaultPageSize, errs),
+	}
+	for key, message := range pagination.Validate() {
+		errs[key] = message
+	}
+	if len(errs) > 0 {
+		app.sendFailedValidationResponse(w, r, errs)
+		return
+	}
+
+	recalls, metadata, err := app.repo.FetchRecalls(pagination)
+	if err != nil {
+		app.sendServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendAPIResponse(&responseWriterArgs{
+		writer:     w,
+		statusCode: 200,
+		status:     true,
+		message:    "recalls",
+	}, r, map[string]interface{}{
+		"recalls":  recalls,
+		"metadata": metadata,
+	})
+}
+
+// incidenceReportEventData is the data of incidence report webhook events.
+// Images are left out since they are private, partners fetch them with the report details
+func incidenceReportEventData(report *model.IncidenceReport) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                report.ID,
+		"state":             report.CurrentState(),
+		"pharmacy_id":       report.PharmacyID,
+		"pharmacy_name":     report.PharmacyName,
+		"pharmacy_location": report.PharmacyLocation,
+		"coordinates":       report.Coordinates,
+		"drug_name":         report.DrugName,
+		"drug_id":           report.DrugID,
+		"batch_number":      report.BatchNumber,
+		"description":       report.Description,
+		"moderation":        report.Moderation,
+		"submitted_on":      report.SubmittedOn,
+	}
+}
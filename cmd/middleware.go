@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"github.com/Hrtnet/social-activities/internal/db"
 	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
 	"github.com/pkg/errors"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
 )
 
@@ -32,3 +36,98 @@ func (app *app) recoverer(next http.Handler) http.Handler {
 	}
 	return http.HandlerFunc(fn)
 }
+
+// requireAdmin rejects requests that don't carry the admin api key
+// as a bearer token in the Authorization header
+func (app *app) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := bearerToken(r)
+		if app.config.adminApiKey == "" || key == "" ||
+			subtle.ConstantTimeCompare([]byte(key), []byte(app.config.adminApiKey)) != 1 {
+			app.sendUnauthorizedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requirePartner rejects requests that don't carry a partner api key
+// as a bearer token in the Authorization header.
+// The authenticated model.Partner is attached to the request context,
+// see contextGetPartner
+func (app *app) requirePartner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		partner, err := app.authenticatePartner(r)
+		if err != nil {
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+		if partner == nil {
+			app.sendUnauthorizedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, contextSetPartner(r, partner))
+	})
+}
+
+// authenticatePartner returns the partner whose api key is carried by r.
+// A nil partner and nil error are returned if r carries no valid partner api key
+func (app *app) authenticatePartner(r *http.Request) (*model.Partner, error) {
+	key := bearerToken(r)
+	if key == "" {
+		return nil, nil
+	}
+	partner, err := app.repo.FetchPartnerByAPIKey(key)
+	if err != nil {
+		if err == db.ErrPartnerNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return partner, nil
+}
+
+// authenticateUser returns the session whose token is carried by r.
+// A nil session and nil error are returned if r carries no valid session token
+func (app *app) authenticateUser(r *http.Request) (*model.Session, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	session, err := app.repo.FetchSession(model.HashToken(token))
+	if err != nil {
+		if err == db.ErrSessionNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// requireUser rejects requests that don't carry a user's session token
+// as a bearer token in the Authorization header, see app.issueSession.
+// The authenticated model.Session is attached to the request context,
+// see contextGetSession
+func (app *app) requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := app.authenticateUser(r)
+		if err != nil {
+			app.sendServerErrorResponse(w, r, err)
+			return
+		}
+		if session == nil {
+			app.sendUnauthorizedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, contextSetSession(r, session))
+	})
+}
+
+// bearerToken extracts the token from an Authorization header of the form "Bearer <token>"
+func bearerToken(r *http.Request) string {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
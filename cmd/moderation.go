@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/Hrtnet/social-activities/internal/imaging"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errTooManyIncidenceReports  = errors.New("you have submitted too many incidence reports, please try again later")
+	errDuplicateIncidenceReport = errors.New("you have already submitted this incidence report")
+)
+
+// checkIncidenceReportLimits returns errTooManyIncidenceReports if the user identified by uid
+// has reached either the hourly or the daily incidence report submission limit
+func (app *app) checkIncidenceReportLimits(uid string) error {
+	limits := []struct {
+		max    int
+		period time.Duration
+	}{
+		{app.config.incidenceReports.hourlyLimit, time.Hour},
+		{app.config.incidenceReports.dailyLimit, 24 * time.Hour},
+	}
+	for _, limit := range limits {
+		if limit.max <= 0 {
+			continue
+		}
+		count, err := app.repo.CountIncidenceReportsSince(uid, time.Now().Add(-limit.period))
+		if err != nil {
+			return err
+		}
+		if count >= int64(limit.max) {
+			return errTooManyIncidenceReports
+		}
+	}
+	return nil
+}
+
+// moderateIncidenceReport compares report with the similar reports submitted within the
+// duplicate window and flags it for moderation if it looks like a duplicate or spam.
+// errDuplicateIncidenceReport is returned if the reporter already submitted any of report's images,
+// i.e., the report is a resubmission
+func (app *app) moderateIncidenceReport(report *model.IncidenceReport) error {
+	since := report.SubmittedOn.Add(-app.config.incidenceReports.duplicateWindow)
+	candidates, err := app.repo.FetchSimilarIncidenceReports(report, since)
+	if err != nil {
+		return err
+	}
+
+	reasons := make(map[string]bool)
+	var similarReports []primitive.ObjectID
+	for _, other := range *candidates {
+		var reason string
+		sameUser := other.UserID == report.UserID
+		switch {
+		case sharesImage(report, &other, false):
+			if sameUser {
+				return errDuplicateIncidenceReport
+			}
+			reason = "contains an image attached to a report by another user"
+		case sharesImage(report, &other, true):
+			reason = "contains images similar to those of a recent report"
+		case sameUser && samePharmacy(report, &other):
+			reason = "the reporter recently reported the same pharmacy"
+		case sameUser && report.DrugName != "" && strings.EqualFold(report.DrugName, other.DrugName):
+			reason = "the reporter recently reported the same drug"
+		default:
+			continue
+		}
+		reasons[reason] = true
+		similarReports = append(similarReports, other.ID)
+	}
+
+	if len(similarReports) == 0 {
+		return nil
+	}
+	report.Moderation = &model.ReportModeration{
+		Flagged:        true,
+		SimilarReports: similarReports,
+	}
+	for reason := range reasons {
+		report.Moderation.Reasons = append(report.Moderation.Reasons, reason)
+	}
+	sort.Strings(report.Moderation.Reasons)
+	return nil
+}
+
+// sharesImage reports if a and b have an image in common.
+// If perceptual is false, only identical uploads count,
+// otherwise visually similar images count as well
+func sharesImage(a, b *model.IncidenceReport, perceptual bool) bool {
+	for _, fa := range a.ImageFingerprints {
+		for _, fb := range b.ImageFingerprints {
+			if !perceptual {
+				if fa.ContentHash == fb.ContentHash {
+					return true
+				}
+				continue
+			}
+
+			ha, errA := strconv.ParseUint(fa.PerceptualHash, 16, 64)
+			hb, errB := strconv.ParseUint(fb.PerceptualHash, 16, 64)
+			if errA == nil && errB == nil && imaging.HammingDistance(ha, hb) <= imaging.SimilarityThreshold {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func samePharmacy(a, b *model.IncidenceReport) bool {
+	return a.PharmacyID != nil && b.PharmacyID != nil && *a.PharmacyID == *b.PharmacyID
+}
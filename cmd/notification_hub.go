@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/Hrtnet/social-activities/internal/logger"
 	"github.com/Hrtnet/social-activities/internal/model"
 	"github.com/gorilla/websocket"
 	"sync"
+	"time"
 )
 
+// closeFrameTimeout bounds the time spent sending the close frame of a websocket connection
+const closeFrameTimeout = 5 * time.Second
+
 // NotificationHub implements a simple notification system.
 type NotificationHub struct {
 
@@ -18,6 +23,9 @@ type NotificationHub struct {
 	connLock sync.RWMutex
 
 	storage NotificationRepo
+
+	// inFlight tracks the notifications being dispatched, see NotificationHub.Wait
+	inFlight sync.WaitGroup
 }
 
 func NewNotificationHub(storage NotificationRepo) *NotificationHub {
@@ -39,7 +47,9 @@ func (hub *NotificationHub) AddConnection(userId string, conn *websocket.Conn) {
 // has an active websocket connection and save to NotificationRepo
 // regardless
 func (hub *NotificationHub) Dispatch(notification *model.Notification) {
+	hub.inFlight.Add(1)
 	go func() {
+		defer hub.inFlight.Done()
 		hub.connLock.RLock()
 		conn, ok := hub.connections[notification.UserID]
 		if ok {
@@ -54,7 +64,9 @@ func (hub *NotificationHub) Dispatch(notification *model.Notification) {
 
 func (hub *NotificationHub) DispatchAllUnread(forUserId string) {
 
+	hub.inFlight.Add(1)
 	go func() {
+		defer hub.inFlight.Done()
 
 		// fetch all unread notifications from storage
 		notifications, err := hub.storage.FetchAllUnreadNotifications(forUserId)
@@ -82,3 +94,27 @@ func (hub *NotificationHub) RemoveConnection(userId string) {
 	logger.Logger.LogInfo(fmt.Sprintf("removed user %s websocket connection from pool", userId))
 	hub.connLock.Unlock()
 }
+
+// CloseConnections sends a close frame to every websocket connection, telling clients
+// the server is going away so they reconnect later, then closes the connections
+func (hub *NotificationHub) CloseConnections() {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+
+	hub.connLock.Lock()
+	defer hub.connLock.Unlock()
+	for userId, conn := range hub.connections {
+		err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeFrameTimeout))
+		if err != nil {
+			logger.Logger.LogWarn(fmt.Sprintf("failed to send close frame to user %s", userId),
+				"close websocket connections", err)
+		}
+		conn.Close()
+		delete(hub.connections, userId)
+	}
+}
+
+// Wait waits for the notifications being dispatched to be sent and saved.
+// Returns ctx.Err() if ctx is done first
+func (hub *NotificationHub) Wait(ctx context.Context) error {
+	return waitContext(ctx, &hub.inFlight)
+}
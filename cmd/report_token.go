@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// reportTokenExpiry is how long after a scan the user can file a report pre-filled from it
+const reportTokenExpiry = 24 * time.Hour
+
+var errInvalidReportToken = errors.New("invalid or expired report token")
+
+// reportToken issues the token that links an incidence report to scan.
+// Tokens are formatted as scanId.expires.signature and are only valid for the user who scanned
+func (app *app) reportToken(scan *model.Scan) string {
+	query := app.urlSigner.Sign(reportTokenKey(scan.ID, scan.UserID), time.Now().Add(reportTokenExpiry))
+	return fmt.Sprintf("%s.%s.%s", scan.ID.Hex(), query.Get("expires"), query.Get("signature"))
+}
+
+// verifyReportToken returns the id of the scan token was issued for.
+// errInvalidReportToken is returned if token wasn't issued to the user identified by userId, or has expired
+func (app *app) verifyReportToken(token, userId string) (primitive.ObjectID, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return primitive.NilObjectID, errInvalidReportToken
+	}
+	scanId, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return primitive.NilObjectID, errInvalidReportToken
+	}
+
+	query := url.Values{}
+	query.Set("expires", parts[1])
+	query.Set("signature", parts[2])
+	if err := app.urlSigner.Verify(reportTokenKey(scanId, userId), query); err != nil {
+		return primitive.NilObjectID, errInvalidReportToken
+	}
+	return scanId, nil
+}
+
+func reportTokenKey(scanId primitive.ObjectID, userId string) string {
+	return fmt.Sprintf("report-token:%s:%s", scanId.Hex(), userId)
+}
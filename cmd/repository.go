@@ -2,11 +2,14 @@ package main
 
 import (
 	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/Hrtnet/social-activities/internal/webhook"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 type Repository interface {
 	Validator
+	webhook.Repo
 
 	// Disconnect the from repo source in case of any fatal event.
 	Disconnect() error
@@ -16,26 +19,92 @@ type Repository interface {
 	FetchRandomQRCode() (string, error)
 	GenerateNewUserID() (string, error)
 
-	FetchAllAirdropSubmissions() (*[]model.AirdropSubmission, error)
-
-	// FetchAirdropSubmissionByUserID fetches the airdrop submission
-	// submitted by userId.
+	// FetchAirdropSubmission fetches the airdrop submission made by userId
+	// to the campaign identified by campaignId, or the latest one if campaignId is the nil id.
 	// Returns db.ErrNoSubmissionFound if no airdrop submission was found.
-	FetchAirdropSubmissionByUserID(userId string) (*model.AirdropSubmission, error)
+	FetchAirdropSubmission(userId string, campaignId primitive.ObjectID) (*model.AirdropSubmission, error)
+
+	// FetchAirdropSubmissionsByUserID fetches every airdrop submission made by userId, most recent first
+	FetchAirdropSubmissionsByUserID(userId string) (*[]model.AirdropSubmission, error)
 
 	// InsertAirdropSubmission inserts a airdrop submission document into the database.
-	// Note that InsertAirdropSubmission does not check submission.UserID is valid
+	// Note that InsertAirdropSubmission does not check submission.UserID is valid.
+	// Returns db.ErrDuplicateAirdropSubmission if the user already made a submission to the campaign
+	// and db.ErrAirdropIdentifierInUse if another user already submitted any of its identifiers to the campaign
 	InsertAirdropSubmission(submission *model.AirdropSubmission) error
 
-	InsertContactUs(message *model.ContactUs) error
+	InsertCampaign(campaign *model.Campaign) error
+
+	// FetchCampaign fetches the campaign identified by id.
+	// Returns db.ErrCampaignNotFound if id is unknown
+	FetchCampaign(id primitive.ObjectID) (*model.Campaign, error)
+
+	// FetchActiveCampaigns fetches the campaigns running at t, those ending soonest first
+	FetchActiveCampaigns(t time.Time) (*[]model.Campaign, error)
+
+	// FetchAirdropSubmissions fetches a page of the airdrop submissions matching filter.
+	// Pending submissions are listed oldest first, others most recent first
+	FetchAirdropSubmissions(filter *model.AirdropSubmissionFilter) (*[]model.AirdropSubmission, model.Metadata, error)
+
+	// FetchAirdropSubmissionsAfter fetches at most limit airdrop submissions matching filter,
+	// most recent first, starting right after cursor, or from the start if cursor is nil
+	FetchAirdropSubmissionsAfter(filter *model.AirdropSubmissionFilter, cursor *model.SubmissionCursor,
+		limit int64) (*[]model.AirdropSubmission, error)
+
+	// StreamAirdropSubmissions calls fn with every airdrop submission matching filter, most recent first.
+	// Streaming stops at the first error returned by fn, which is returned
+	StreamAirdropSubmissions(filter *model.AirdropSubmissionFilter, fn func(*model.AirdropSubmission) error) error
+
+	// FetchAirdropStats aggregates the airdrop submissions matching filter
+	FetchAirdropStats(filter *model.AirdropSubmissionFilter) (*model.AirdropStats, error)
+
+	// FetchAirdropSubmissionByID fetches the airdrop submission identified by id.
+	// Returns db.ErrNoSubmissionFound if id is unknown
+	FetchAirdropSubmissionByID(id primitive.ObjectID) (*model.AirdropSubmission, error)
+
+	// ReviewAirdropSubmission moves the pending submission identified by id to status, recording review.
+	// Returns db.ErrNoSubmissionFound if id is unknown
+	// and db.ErrSubmissionAlreadyReviewed if the submission isn't pending
+	ReviewAirdropSubmission(id primitive.ObjectID, status model.SubmissionStatus,
+		review *model.SubmissionReview) (*model.AirdropSubmission, error)
 
-	UpdateUser(user *model.User) error
+	// ReserveCampaignReward sets hrtTokens aside from the reward pool of the campaign identified by id.
+	// Returns db.ErrCampaignNotFound if id is unknown
+	// and db.ErrRewardPoolExhausted if the pool can't pay hrtTokens
+	ReserveCampaignReward(id primitive.ObjectID, hrtTokens int) error
+
+	// ReleaseCampaignReward returns hrtTokens reserved with ReserveCampaignReward to the pool
+	ReleaseCampaignReward(id primitive.ObjectID, hrtTokens int) error
+
+	// FetchRelatedAirdropSubmissions fetches at most limit submissions of users other than submission's
+	// that share any identifier, IP address or device with submission, across campaigns
+	FetchRelatedAirdropSubmissions(submission *model.AirdropSubmission, limit int64) (*[]model.AirdropSubmission, error)
+
+	// FlagAirdropSubmissions flags the submissions identified by ids as related to the submission
+	// identified by relatedId, adding reasons and raising their fraud score to at least score
+	FlagAirdropSubmissions(ids []primitive.ObjectID, relatedId primitive.ObjectID, score int, reasons []string) error
+
+	InsertContactUs(message *model.ContactUs) error
 
 	// FetchUser fetches user identified by uid.
 	// Returns db.ErrUserNotFound if user not found.
 	// Other errors can be treated as internal error
 	FetchUser(uid string) (*model.User, error)
 
+	// PatchUser applies patch to the user identified by uid, provided the user is still at version,
+	// and returns the patched user, whose version is incremented.
+	// Returns db.ErrUserNotFound if uid is unknown and db.ErrEditConflict if the user is at another version
+	PatchUser(uid string, version int, patch *model.UserPatch) (*model.User, error)
+
+	// InsertUserChange records change ahead of the update it describes
+	InsertUserChange(change *model.UserChange) error
+
+	// DeleteUserChange removes the change identified by id, e.g., when the update it describes failed
+	DeleteUserChange(id primitive.ObjectID) error
+
+	// FetchUserChanges fetches a page of the change history of the user identified by uid, most recent first
+	FetchUserChanges(uid string, pagination model.Pagination) (*[]model.UserChange, model.Metadata, error)
+
 	// IsValidUser checks if id exists in repo.
 	// Returns db.ErrUserNotFound if not found, db error otherwise
 	IsValidUser(id string) error
@@ -48,19 +117,254 @@ type Repository interface {
 	InsertAnnouncement(announcement *model.Announcement) error
 	FetchAnnouncements() (*[]model.Announcement, error)
 
+	// InsertIncidenceReportUpdate records update sent by the partner identified by partnerId,
+	// who must be assigned to the report, see AssignIncidenceReportPartners.
+	// If transition is not nil, the report is also moved to transition.To, provided the
+	// report is still in transition.From. Otherwise, db.ErrEditConflict is returned.
+	// Returns db.ErrIncidenceReportNotFound if the report does not exist or the partner
+	// is not assigned to it.
 	// InsertIncidenceReportUpdate should only be called by
 	// partners with HeartNet
-	InsertIncidenceReportUpdate(update *model.IncidenceReportUpdate) error
+	InsertIncidenceReportUpdate(update *model.IncidenceReportUpdate, partnerId primitive.ObjectID,
+		transition *model.IncidenceReportTransition) error
 
-	RecordReward(reward model.Reward) error
+	RecordReward(reward *model.Reward) error
 
 	// FetchUserInfo fetches the model.User.
 	// Returns db.ErrUserNotFound if uid is not found in repo
 	FetchUserInfo(uid string) (*model.User, error)
 
 	SubmitIncidenceReport(report *model.IncidenceReport) error
-	FetchIncidenceReporterUID(id primitive.ObjectID) (string, error)
+
+	// FetchIncidenceReport fetches the incidence report identified by id.
+	// Returns db.ErrIncidenceReportNotFound if not found
+	FetchIncidenceReport(id primitive.ObjectID) (*model.IncidenceReport, error)
+
+	// FetchIncidenceReports fetches the page of incidence reports matching filter.
+	// Updates and Transitions of the returned reports are not populated
+	FetchIncidenceReports(filter *model.IncidenceReportFilter) (*[]model.IncidenceReport, model.Metadata, error)
+
+	// FetchIncidenceReportsByUserID fetches every incidence report submitted by
+	// the user identified by uid, most recent first
+	FetchIncidenceReportsByUserID(uid string) (*[]model.IncidenceReport, error)
+
+	// InsertReporterFollowUp records a follow-up sent by the reporter identified by uid,
+	// and attaches evidenceKeys (with their thumbnailKeys and fingerprints) to the report.
+	// Returns db.ErrEditConflict if the report is no longer open
+	InsertReporterFollowUp(followUp *model.IncidenceReportUpdate, uid string,
+		evidenceKeys, thumbnailKeys []string, fingerprints []model.ImageFingerprint) error
+
+	// CountIncidenceReportsSince counts the incidence reports the user identified by uid submitted since since
+	CountIncidenceReportsSince(uid string, since time.Time) (int64, error)
+
+	// FetchSimilarIncidenceReports fetches the incidence reports submitted since since that were
+	// submitted by the same user as report, are about the same pharmacy or drug, or share an image with report.
+	// Only the fields needed for duplicate detection are populated
+	FetchSimilarIncidenceReports(report *model.IncidenceReport, since time.Time) (*[]model.IncidenceReport, error)
 	FetchNotificationTokenByUserID(uid string) (string, error)
+
+	// FetchIncidenceReportByImageKey fetches the incidence report that owns the image
+	// stored under the blob key.
+	// Returns db.ErrIncidenceReportNotFound if no report owns the image
+	FetchIncidenceReportByImageKey(key string) (*model.IncidenceReport, error)
+
+	// AssignIncidenceReportPartners grants partners identified by partnerIds access to
+	// the incidence report identified by reportId.
+	// Returns db.ErrPartnerNotFound if any of partnerIds is unknown
+	// and db.ErrIncidenceReportNotFound if reportId is unknown
+	AssignIncidenceReportPartners(reportId primitive.ObjectID, partnerIds []primitive.ObjectID) error
+
+	InsertPartner(partner *model.Partner) error
+
+	// FetchPartnerByAPIKey fetches the partner that was issued apiKey.
+	// Returns db.ErrPartnerNotFound if no partner was issued apiKey
+	FetchPartnerByAPIKey(apiKey string) (*model.Partner, error)
+
+	// InsertPharmacy adds pharmacy to the registry.
+	// Returns db.ErrDuplicatePharmacy if its licence number is already registered
+	InsertPharmacy(pharmacy *model.Pharmacy) error
+
+	// FetchPharmacy fetches the pharmacy identified by id.
+	// Returns db.ErrPharmacyNotFound if id is unknown
+	FetchPharmacy(id primitive.ObjectID) (*model.Pharmacy, error)
+
+	// FetchPharmacyBySearchKey fetches the pharmacy with searchKey, registered pharmacies first.
+	// Returns db.ErrPharmacyNotFound if there is none
+	FetchPharmacyBySearchKey(searchKey string) (*model.Pharmacy, error)
+
+	// SuggestPharmacy links a pharmacy named by a reporter to the registry, reusing a pharmacy
+	// with the same name and address if there is one. pharmacy is updated with the stored pharmacy
+	SuggestPharmacy(pharmacy *model.Pharmacy) error
+
+	// LinkIncidenceReportPharmacy links the incidence report identified by id, if it isn't linked yet,
+	// to the pharmacy identified by pharmacyId
+	LinkIncidenceReportPharmacy(id, pharmacyId primitive.ObjectID) error
+
+	// RegisterPharmacy vets the pharmacy identified by id.
+	// Returns db.ErrPharmacyNotFound if id is unknown
+	// and db.ErrDuplicatePharmacy if licenceNumber belongs to another pharmacy
+	RegisterPharmacy(id primitive.ObjectID, licenceNumber string, location *model.GeoPoint) (*model.Pharmacy, error)
+
+	FetchPharmacies(filter *model.PharmacyFilter) (*[]model.Pharmacy, model.Metadata, error)
+
+	// FetchPharmacyHotspots counts the incidence reports matching filter per pharmacy,
+	// most reported pharmacies first
+	FetchPharmacyHotspots(filter *model.IncidenceReportFilter) (*[]model.PharmacyHotspot, model.Metadata, error)
+
+	// FetchIncidenceReportDrugGroups counts the incidence reports matching filter per drug and batch,
+	// most reported batches first
+	FetchIncidenceReportDrugGroups(filter *model.IncidenceReportFilter) (*[]model.DrugReportGroup, model.Metadata, error)
+
+	InsertScan(scan *model.Scan) error
+
+	// IsFirstDrugScan reports if scan, once inserted, is the first time its user scanned its drug
+	IsFirstDrugScan(scan *model.Scan) (bool, error)
+
+	// FetchScan fetches the scan identified by id.
+	// Returns db.ErrScanNotFound if id is unknown
+	FetchScan(id primitive.ObjectID) (*model.Scan, error)
+
+	InsertWebhook(webhook *model.Webhook) error
+
+	FetchWebhooksByPartnerID(partnerId primitive.ObjectID) (*[]model.Webhook, error)
+
+	// DeleteWebhook deletes the webhook identified by id if it belongs to the partner identified by partnerId.
+	// Returns db.ErrWebhookNotFound otherwise
+	DeleteWebhook(id, partnerId primitive.ObjectID) error
+
+	FetchWebhookDeliveries(filter *model.WebhookDeliveryFilter) (*[]model.WebhookDelivery, model.Metadata, error)
+
+	// FetchWebhookDelivery fetches the webhook delivery identified by id.
+	// Returns db.ErrWebhookDeliveryNotFound if id is unknown
+	FetchWebhookDelivery(id primitive.ObjectID) (*model.WebhookDelivery, error)
+
+	InsertRecall(recall *model.Recall) error
+
+	// FetchRecalls fetches a page of recalls, most recent first
+	FetchRecalls(pagination model.Pagination) (*[]model.Recall, model.Metadata, error)
+
+	// InsertWalletChallenge stores challenge, replacing any challenge previously issued to the same user
+	InsertWalletChallenge(challenge *model.WalletChallenge) error
+
+	// ConsumeWalletChallenge fetches and deletes the unexpired challenge issued to the user identified
+	// by uid for the wallet at address.
+	// Returns db.ErrWalletChallengeNotFound if there is no such challenge
+	ConsumeWalletChallenge(uid, address string) (*model.WalletChallenge, error)
+
+	// LinkWallet sets the wallet of the user identified by uid to the verified address,
+	// provided the user has no wallet linked or has address linked already.
+	// Returns db.ErrUserNotFound if uid is unknown, db.ErrWalletAlreadyLinked if the user has
+	// another wallet linked and db.ErrWalletInUse if another user linked the wallet
+	LinkWallet(uid, address string, verifiedOn time.Time) error
+
+	// UnlinkWallet removes the wallet linked to the user identified by uid.
+	// Returns db.ErrUserNotFound if uid is unknown
+	UnlinkWallet(uid string) error
+
+	// AssignReferralCode returns the referral code of the user identified by uid, generating one if needed.
+	// Returns db.ErrUserNotFound if uid is unknown
+	AssignReferralCode(uid string) (string, error)
+
+	// FetchUserByReferralCode fetches the user who owns the referral code.
+	// Returns db.ErrReferralCodeNotFound if no user owns code
+	FetchUserByReferralCode(code string) (*model.User, error)
+
+	// InsertReferral records referral in the referral graph.
+	// Returns db.ErrAlreadyReferred if the invitee was already referred
+	InsertReferral(referral *model.Referral) error
+
+	// CountReferralsSince counts the users the user identified by referrerId referred since since
+	CountReferralsSince(referrerId string, since time.Time) (int64, error)
+
+	// CompleteReferral marks the pending referral of the user identified by inviteeId as completed.
+	// Returns db.ErrReferralNotFound if the user has no pending referral, e.g., because it was already completed
+	CompleteReferral(inviteeId string, completedOn time.Time) (*model.Referral, error)
+
+	// CountRewardedReferralsSharingSignup counts the other rewarded referrals of referral's referrer
+	// whose invitees signed up from referral's device, and from its IP address
+	CountRewardedReferralsSharingSignup(referral *model.Referral) (sameDevice, sameIP int64, err error)
+
+	// ReserveReferralReward counts a referral reward against the user identified by referrerId.
+	// Returns db.ErrReferralRewardCapReached if the user was already rewarded for max referrals
+	ReserveReferralReward(referrerId string, max int) error
+
+	// ReleaseReferralReward takes back a reward reserved with ReserveReferralReward
+	ReleaseReferralReward(referrerId string) error
+
+	// SettleReferral records the outcome of the completed referral identified by id
+	SettleReferral(id primitive.ObjectID, status model.ReferralStatus, reason string, rewardId primitive.ObjectID) error
+
+	// FetchReferralSummary counts the referrals of the user identified by referrerId per status
+	// and fetches the limit most recent ones into summary
+	FetchReferralSummary(referrerId string, limit int64, summary *model.ReferralSummary) error
+
+	// IncrementLeaderboardScore adds amount to the metric of the user identified by uid,
+	// on the all-time leaderboard and on the weekly leaderboard of the week of at
+	IncrementLeaderboardScore(uid string, metric model.LeaderboardMetric, amount int, at time.Time) error
+
+	// FetchLeaderboard fetches the limit best scores of metric for the period identified by periodKey,
+	// see model.LeaderboardPeriod.Key
+	FetchLeaderboard(periodKey string, metric model.LeaderboardMetric, limit int64) (*[]model.LeaderboardScore, error)
+
+	// FetchLeaderboardRank returns the rank and score of the user identified by uid on the leaderboard
+	// of metric for the period identified by periodKey.
+	// Returns db.ErrLeaderboardScoreNotFound if the user hasn't scored
+	FetchLeaderboardRank(periodKey string, metric model.LeaderboardMetric, uid string) (rank, score int, err error)
+
+	// InsertOneTimeCode stores code, replacing any code issued for the same purpose and email before notBefore.
+	// Returns db.ErrOneTimeCodeTooSoon if the previous code is more recent
+	InsertOneTimeCode(code *model.OneTimeCode, notBefore time.Time) error
+
+	// ConsumeOneTimeCode checks codeHash against the unexpired code issued for purpose and email,
+	// deleting the code if it matches.
+	// Returns db.ErrOneTimeCodeNotFound if there is no such code and db.ErrOneTimeCodeMismatch
+	// if codeHash doesn't match
+	ConsumeOneTimeCode(purpose model.CodePurpose, email, codeHash string) (*model.OneTimeCode, error)
+
+	// VerifyUserEmail sets the email of the user identified by uid to the verified email.
+	// Returns db.ErrUserNotFound if uid is unknown and db.ErrEmailInUse if another user verified email
+	VerifyUserEmail(uid, email string, verifiedOn time.Time) error
+
+	// FetchUserByVerifiedEmail fetches the user who verified email.
+	// Returns db.ErrUserNotFound if no user verified email
+	FetchUserByVerifiedEmail(email string) (*model.User, error)
+
+	// ClaimFirstSession records that the first session of the user identified by uid is issued.
+	// Returns db.ErrUserNotFound if uid is unknown and db.ErrSessionAlreadyClaimed if it already was
+	ClaimFirstSession(uid string, at time.Time) error
+
+	InsertSession(session *model.Session) error
+
+	// FetchSession fetches the unexpired session whose token hashes to tokenHash.
+	// Returns db.ErrSessionNotFound if there is no such session
+	FetchSession(tokenHash string) (*model.Session, error)
+
+	// DeleteSession revokes the session identified by id
+	DeleteSession(id primitive.ObjectID) error
+
+	// DeleteUserSessions revokes every session of the user identified by uid
+	DeleteUserSessions(uid string) error
+
+	// ExportUserData calls fn with the documents of the user identified by uid in each collection
+	// holding user data, as a JSON array. Streaming stops at the first error returned by fn
+	ExportUserData(uid string, fn func(collection string, documents []byte) error) error
+
+	// InsertAccountDeletion records that a user requested to delete their account
+	InsertAccountDeletion(deletion *model.AccountDeletion) error
+
+	// FetchPendingAccountDeletion returns the account deletion of the user whose uid hashes to uidHash
+	// that was requested but not completed. Returns db.ErrAccountDeletionNotFound if there's none
+	FetchPendingAccountDeletion(uidHash string) (*model.AccountDeletion, error)
+
+	// AddAccountDeletionImageKeys records keys among the image keys of deletion
+	AddAccountDeletionImageKeys(deletion *model.AccountDeletion, keys []string) error
+
+	// DeleteUserData erases the data of the user identified by uid, anonymising the records kept
+	// for investigations and accounting with deletion.AnonymousID. It can be retried if it fails
+	DeleteUserData(uid string, deletion *model.AccountDeletion) error
+
+	// CompleteAccountDeletion records the outcome of deletion once the user's data is deleted
+	CompleteAccountDeletion(deletion *model.AccountDeletion) error
 }
 
 type NotificationRepo interface {
@@ -1,22 +1,20 @@
 package main
 
 import (
-	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-	"github.com/pkg/errors"
-	"io/ioutil"
 	"net/http"
 	"time"
 )
 
 func (app *app) routes() http.Handler {
 	corsOptions := cors.Options{
-		AllowedOrigins:   []string{"http://*", "https://*"}, // Use this to allow specific origin hosts
-		AllowedMethods:   []string{"GET", "POST"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedOrigins: []string{"http://*", "https://*"}, // Use this to allow specific origin hosts
+		AllowedMethods: []string{"GET", "POST", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token",
+			"X-Device-ID", "Range", "If-None-Match", "If-Modified-Since", "If-Range"},
+		ExposedHeaders:   []string{"Link", "ETag", "Content-Range", "Accept-Ranges"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}
@@ -33,44 +31,82 @@ func (app *app) routes() http.Handler {
 
 	mux.Get("/api/res/images/*", app.serveImages)
 	mux.Get("/api/health", app.checkStatus)
-	mux.Get("/api/activities-statistics", app.serveAllAirdropSubmission)
 	mux.Get("/api/new-user", app.serveStarterPack)
 	mux.Get("/api/qr-code", app.serveQrCode)
 	mux.Get("/api/task-report", app.serveAirdropSubmission)
-	mux.Get("/api/wallet-address", app.serveWalletAddress)
-	mux.Get("/api/user/{uid}", app.serveUserInfo)
 	mux.Get("/api/announcements", app.serveAnnouncements)
 	mux.Get("/api/notifications/{user_id}", app.notifications)
+	mux.Get("/api/pharmacies", app.listPharmacies)
+	mux.Get("/api/recalls", app.listRecalls)
+	mux.Get("/api/campaigns", app.listActiveCampaigns)
+	mux.Get("/api/leaderboard", app.serveLeaderboard)
 
-	mux.Post("/api/incidence-report", app.submitIncidenceReport)
+	mux.Post("/api/users/{uid}/sessions/challenge", app.createSessionChallenge)
+	mux.Post("/api/users/{uid}/sessions", app.claimSession)
+	mux.Post("/api/account-recovery", app.requestAccountRecovery)
+	mux.Post("/api/account-recovery/verify", app.recoverAccount)
 	mux.Post("/api/task-report", app.submitAirdropForm)
 	mux.Post("/api/validate-qr", app.validateQrCode)
 	mux.Post("/api/validate-code", app.validateShortCode)
 	mux.Post("/api/validate-rfid", app.validateRFIDText)
 	mux.Post("/api/contact-us", app.submitContactUsMessage)
-	mux.Post("/api/update-user", app.updateUser)
 	mux.Post("/api/reward-alert", app.sendRewardsAlert)
-	mux.Post("/api/report-status", app.submitIncidenceReportStatus)
 	mux.Post("/api/announcement", app.submitAnnouncement)
 
-	mux.MethodNotAllowed(app.sendMethodNotAllowedResponse)
-	mux.NotFound(app.sendNotFoundResponse)
-	return mux
-}
+	mux.Group(func(user chi.Router) {
+		user.Use(app.requireUser)
+		user.Post("/api/sessions/refresh", app.refreshSession)
+		user.Post("/api/incidence-report", app.submitIncidenceReport)
+		user.Get("/api/wallet-address", app.serveWalletAddress)
+		user.Get("/api/user/{uid}", app.serveUserInfo)
+		user.Post("/api/users/{uid}/wallet/challenge", app.createWalletChallenge)
+		user.Post("/api/users/{uid}/wallet/verify", app.verifyWallet)
+		user.Delete("/api/users/{uid}/wallet", app.unlinkWallet)
+		user.Get("/api/users/{uid}/incidence-reports", app.listUserIncidenceReports)
+		user.Get("/api/users/{uid}/referrals", app.listUserReferrals)
+		user.Get("/api/users/{uid}/airdrop-submissions", app.listUserAirdropSubmissions)
+		user.Post("/api/users/{uid}/incidence-reports/{id}/comments", app.submitIncidenceReportComment)
+		user.Post("/api/users/{uid}/incidence-reports/{id}/evidence", app.submitIncidenceReportEvidence)
+		user.Post("/api/users/{uid}/email/verification", app.requestEmailVerification)
+		user.Post("/api/users/{uid}/email/verify", app.verifyEmail)
+		user.Get("/api/users/{uid}/export", app.exportUserData)
+		user.Patch("/api/users/{uid}", app.patchUser)
+		user.Post("/api/update-user", app.updateUser)
+		user.Get("/api/users/{uid}/changes", app.listUserChanges)
+		user.Delete("/api/users/{uid}", app.deleteUser)
+	})
 
-func (app *app) serveImages(w http.ResponseWriter, r *http.Request) {
+	mux.Group(func(partner chi.Router) {
+		partner.Use(app.requirePartner)
+		partner.Get("/api/partner/incidence-reports", app.listIncidenceReports)
+		partner.Get("/api/partner/incidence-reports/drugs", app.listIncidenceReportDrugGroups)
+		partner.Get("/api/partner/incidence-reports/{id}", app.showIncidenceReport)
+		partner.Get("/api/partner/pharmacies/hotspots", app.listPharmacyHotspots)
+		partner.Post("/api/report-status", app.submitIncidenceReportStatus)
+		partner.Post("/api/partner/recalls", app.createRecall)
+		partner.Get("/api/partner/webhooks", app.listWebhooks)
+		partner.Post("/api/partner/webhooks", app.createWebhook)
+		partner.Delete("/api/partner/webhooks/{id}", app.deleteWebhook)
+		partner.Get("/api/partner/webhooks/{id}/deliveries", app.listWebhookDeliveries)
+		partner.Post("/api/partner/webhook-deliveries/{id}/replay", app.replayWebhookDelivery)
+	})
 
-	file := r.URL.Path
-
-	fileBytes, err := ioutil.ReadFile("." + file)
-	if err != nil {
-		app.sendServerErrorResponse(w, r,
-			errors.Wrap(err, fmt.Sprintf("failed to read file %s", file)))
-		return
-	}
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.WriteHeader(http.StatusOK)
-	w.Write(fileBytes)
-	return
+	mux.Group(func(admin chi.Router) {
+		admin.Use(app.requireAdmin)
+		admin.Post("/api/admin/partners", app.createPartner)
+		admin.Post("/api/admin/campaigns", app.createCampaign)
+		admin.Get("/api/activities-statistics", app.serveAllAirdropSubmission)
+		admin.Get("/api/activities-statistics/summary", app.serveAirdropStatistics)
+		admin.Get("/api/activities-statistics/export", app.exportAirdropSubmissions)
+		admin.Get("/api/admin/airdrop-submissions", app.listAirdropSubmissionQueue)
+		admin.Post("/api/admin/airdrop-submissions/review", app.reviewAirdropSubmissions)
+		admin.Post("/api/admin/airdrop-submissions/{id}/review", app.reviewAirdropSubmission)
+		admin.Post("/api/admin/incidence-reports/{id}/partners", app.assignIncidenceReportPartners)
+		admin.Post("/api/admin/pharmacies", app.createPharmacy)
+		admin.Post("/api/admin/pharmacies/{id}/register", app.registerPharmacy)
+	})
 
+	mux.MethodNotAllowed(app.sendMethodNotAllowedResponse)
+	mux.NotFound(app.sendNotFoundResponse)
+	return mux
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serve runs the server until it is interrupted or terminated, e.g., by Heroku on every deploy,
+// then shuts it down gracefully, see app.shutdown
+func (app *app) serve() error {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           app.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      20 * time.Second,
+		MaxHeaderBytes:    2048,
+	}
+
+	// websocket connections are hijacked, Shutdown neither closes nor waits for them
+	server.RegisterOnShutdown(app.notificationHub.CloseConnections)
+
+	workers, stopWorkers := context.WithCancel(context.Background())
+	app.background(func() {
+		app.webhooks.Run(workers)
+	})
+
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+
+		logger.Logger.LogInfo(fmt.Sprintf("shutting down server, received %s", sig))
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+		shutdownErr <- app.shutdown(ctx, server, stopWorkers)
+	}()
+
+	logger.Logger.LogInfo(
+		fmt.Sprintf("starting server in %s mode on port %d", app.config.environment.String(), app.config.port))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		stopWorkers()
+		return err
+	}
+	if err := <-shutdownErr; err != nil {
+		return err
+	}
+	logger.Logger.LogInfo("stopped server")
+	return nil
+}
+
+// shutdown stops server from accepting requests and closes websocket connections with a close frame,
+// then waits until the requests in flight, the notifications being dispatched and the background work,
+// webhook deliveries included, complete, stopping workers once no more work can be started.
+// Returns an error if ctx is done first
+func (app *app) shutdown(ctx context.Context, server *http.Server, stopWorkers context.CancelFunc) error {
+	defer stopWorkers()
+
+	if err := server.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "failed to complete requests in flight")
+	}
+	if err := app.notificationHub.Wait(ctx); err != nil {
+		return errors.Wrap(err, "failed to complete notification dispatches in flight")
+	}
+	stopWorkers()
+	if err := waitContext(ctx, &app.backgroundTasks); err != nil {
+		return errors.Wrap(err, "failed to complete background work in flight")
+	}
+	return nil
+}
+
+// background runs fn in a goroutine the server waits for when it shuts down.
+// Panics are recovered and logged since they would otherwise take the server down
+func (app *app) background(fn func()) {
+	app.backgroundTasks.Add(1)
+	go func() {
+		defer app.backgroundTasks.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Logger.LogError("background task panicked", "run background task", fmt.Errorf("%v", err))
+			}
+		}()
+		fn()
+	}()
+}
+
+// waitContext waits for wg, returning ctx.Err() if ctx is done first
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
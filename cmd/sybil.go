@@ -0,0 +1,152 @@
+package main
+
+import (
+	"github.com/Hrtnet/social-activities/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// relatedSubmissionsLimit caps the submissions a new airdrop submission is compared with
+	relatedSubmissionsLimit = 100
+
+	// fraudFlagScore is the fraud score from which a submission is flagged in the review queue
+	fraudFlagScore = 40
+
+	// sharedIPUsers is how many other users must have submitted from an IP address for it to count,
+	// since users behind the same network, e.g., a mobile carrier, share addresses
+	sharedIPUsers = 3
+	sharedIPScore = 20
+
+	// maxDeviceIDLength bounds the X-Device-ID header
+	maxDeviceIDLength = 128
+)
+
+// sybilSignals are the identifiers two submissions by different users shouldn't share.
+// field is the json name of identifiers that must also be unique within a campaign, if any
+var sybilSignals = []struct {
+	field  string
+	score  int
+	reason string
+	value  func(s *model.AirdropSubmission) string
+}{
+	{"wallet_address", 60, "wallet address used by another user",
+		func(s *model.AirdropSubmission) string { return s.WalletAddress }},
+	{"tweet_link", 60, "tweet submitted by another user",
+		func(s *model.AirdropSubmission) string { return s.TweetLink }},
+	{"email_address", 50, "email address used by another user",
+		func(s *model.AirdropSubmission) string { return s.EmailAddress }},
+	{"telegram_username", 40, "Telegram username used by another user",
+		func(s *model.AirdropSubmission) string { return s.TelegramUsername }},
+	{"twitter_username", 40, "Twitter username used by another user",
+		func(s *model.AirdropSubmission) string { return s.TwitterUsername }},
+	{"", 40, "submitted from a device used by another user",
+		func(s *model.AirdropSubmission) string { return s.DeviceID }},
+}
+
+// screenAirdropSubmission runs the sybil detection pass on submission before it is inserted.
+// Identifiers already used by another participant of the same campaign are rejected, errs maps
+// the offending fields to a message.
+// Otherwise, if submission shares identifiers, its device or its IP address with other users'
+// submissions, across campaigns, submission.Fraud is set and the ids of those submissions are
+// returned so that they can be flagged in turn, see app.repo.FlagAirdropSubmissions
+func (app *app) screenAirdropSubmission(submission *model.AirdropSubmission) (related []primitive.ObjectID, errs map[string]string, err error) {
+	candidates, err := app.repo.FetchRelatedAirdropSubmissions(submission, relatedSubmissionsLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errs = make(map[string]string)
+	reasons := make(map[string]bool)
+	score := 0
+	ipUsers := make(map[string]bool)
+	for _, other := range *candidates {
+		shares := false
+		for _, signal := range sybilSignals {
+			value := signal.value(submission)
+			if value == "" || value != signal.value(&other) {
+				continue
+			}
+			if signal.field != "" && other.CampaignID == submission.CampaignID {
+				errs[signal.field] = "already used by another participant of this campaign"
+			}
+			if !reasons[signal.reason] {
+				reasons[signal.reason] = true
+				score += signal.score
+			}
+			shares = true
+		}
+		if submission.IPAddress != "" && submission.IPAddress == other.IPAddress {
+			ipUsers[other.UserID] = true
+		}
+		if shares {
+			related = append(related, other.ID)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	if len(ipUsers) >= sharedIPUsers {
+		reasons["submitted from an IP address shared by several users"] = true
+		score += sharedIPScore
+		for _, other := range *candidates {
+			if other.IPAddress == submission.IPAddress {
+				related = append(related, other.ID)
+			}
+		}
+	}
+	if score == 0 {
+		return nil, nil, nil
+	}
+
+	related = uniqueObjectIDs(related)
+	submission.Fraud = &model.SubmissionFraud{
+		Flagged:            score >= fraudFlagScore,
+		Score:              score,
+		RelatedSubmissions: related,
+	}
+	for reason := range reasons {
+		submission.Fraud.Reasons = append(submission.Fraud.Reasons, reason)
+	}
+	sort.Strings(submission.Fraud.Reasons)
+	if !submission.Fraud.Flagged {
+		return nil, nil, nil
+	}
+	return related, nil, nil
+}
+
+// uniqueObjectIDs removes duplicates from ids, keeping the first occurrence of each id
+func uniqueObjectIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	result := ids[:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// clientIP returns the IP address r was sent from.
+// middleware.RealIP has already replaced r.RemoteAddr with the address forwarded by proxies, if any
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// deviceID returns the device identifier the app sends in the X-Device-ID header, if any
+func deviceID(r *http.Request) string {
+	id := strings.TrimSpace(r.Header.Get("X-Device-ID"))
+	if len(id) > maxDeviceIDLength {
+		id = id[:maxDeviceIDLength]
+	}
+	return id
+}
@@ -3,144 +3,266 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/Hrtnet/social-activities/internal/db"
+	"github.com/Hrtnet/social-activities/internal/imaging"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/Hrtnet/social-activities/internal/storage"
 	"github.com/pkg/errors"
 	"io"
 	"mime/multipart"
-	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
+	"time"
 )
 
-// unzipAndSave
-// Returns save directory.
-func unzipAndSave(source multipart.File, header *multipart.FileHeader, destination, apiUrl string) (*[]string, db.ErrorType, error) {
+const (
+	maxImageSize = 5 << 20
 
-	// save file in temp dir
-	// Custom dir name to avoid directory name collision if
-	// multiple go routines invoke function at a time
-	tempSavePath := fmt.Sprintf("*./temp-%s", header.Filename)
-	err := os.MkdirAll(tempSavePath, 0700)
-	if err != nil {
-		return nil, db.InternalError, errors.Wrap(err, "error creating temp save path")
-	}
-	defer os.RemoveAll(tempSavePath) // clean up tempSavePath
+	// maxEvidenceImages caps the evidence images a single upload can attach
+	maxEvidenceImages = 10
 
-	url, err := saveFile(source, header.Filename, tempSavePath)
-	if err == errFileTooLarge {
-		return nil, db.ValidationError, err
-	}
-	if err != nil {
-		return nil, db.InternalError, errors.Wrap(err, "error: temporarily saving zipped file failed")
-	}
+	// maxZipEntries caps the entries of zipped evidence images, directories and non-image files included
+	maxZipEntries = 50
 
-	// open source zipped file
-	reader, err := zip.OpenReader(url)
-	if err != nil {
-		return nil, db.ValidationError, errors.Wrap(err, "invalid zipped file")
-	}
-	defer reader.Close()
+	// maxUnzippedSize caps the total size of zipped evidence images once decompressed
+	maxUnzippedSize = maxEvidenceImages * maxImageSize
 
-	// convert destination to absolute path
-	destination, err = filepath.Abs(destination)
-	if err != nil {
-		return nil, db.InternalError, errors.Wrap(err, "error obtaining destination's absolute path: unzipAndSave")
+	// maxUploadSize caps the body of requests uploading images
+	maxUploadSize = maxUnzippedSize + 2*maxImageSize
+)
+
+var (
+	errTooManyImages = fmt.Errorf("too many evidence images, not more than %d images can be uploaded at once", maxEvidenceImages)
+	errZipTooLarge   = errors.New("zipped evidence images are too large once unzipped")
+	errInvalidZip    = errors.New("invalid zip file")
+	errMissingImages = errors.New("evidence images are missing")
+)
+
+// zipMagic starts every zip file
+var zipMagic = []byte("PK\x03\x04")
+
+// saveEvidenceImages saves the uploaded evidence images into store under the
+// destination key prefix, through the image processing pipeline.
+// files is either a single zip of images or the images themselves, one per part.
+// Either every image is saved, or none: images saved before a failure are deleted.
+func saveEvidenceImages(store storage.BlobStore, files []*multipart.FileHeader, destination string) ([]savedImage, db.ErrorType, error) {
+	if len(files) == 0 {
+		return nil, db.ValidationError, errMissingImages
 	}
 
-	// unpack each file inside zipped file to destination
-	wd, _ := os.Getwd()
-	var savePaths []string
-	for _, f := range reader.File {
-		savedPath, err := unpackFile(f, destination)
+	if len(files) == 1 {
+		file, err := files[0].Open()
 		if err != nil {
-			return nil, db.ValidationError, errors.Wrap(err, "unable to unpack file: invalid zip file")
+			return nil, db.InternalError, errors.Wrap(err, "error opening uploaded file")
+		}
+		defer file.Close()
+
+		magic := make([]byte, len(zipMagic))
+		if _, err := file.ReadAt(magic, 0); err == nil && bytes.Equal(magic, zipMagic) {
+			return unzipAndSave(store, file, files[0].Size, destination)
 		}
+	}
 
-		// treat save path
-		savedPath = strings.TrimPrefix(savedPath, wd)
-		savedPath = fmt.Sprintf("%s%s", apiUrl, savedPath)
-		savePaths = append(savePaths, savedPath)
+	if len(files) > maxEvidenceImages {
+		return nil, db.ValidationError, errTooManyImages
 	}
 
-	return &savePaths, db.None, nil
+	var images []savedImage
+	for i, header := range files {
+		saved, err := saveUploadedImage(store, header, fmt.Sprintf("%s/%d", destination, i))
+		if err != nil {
+			deleteSavedImages(store, images)
+			if isImageValidationError(err) {
+				return nil, db.ValidationError, errors.Wrap(err, fmt.Sprintf("invalid evidence image %s", header.Filename))
+			}
+			return nil, db.InternalError, err
+		}
+		images = append(images, *saved)
+	}
+	return images, db.None, nil
 }
 
-// unpackFile unpacks a single zip file into destination
-func unpackFile(file *zip.File, destination string) (string, error) {
+func saveUploadedImage(store storage.BlobStore, header *multipart.FileHeader, key string) (*savedImage, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening uploaded file")
+	}
+	defer file.Close()
+	return saveImage(store, file, key)
+}
 
-	// check that file paths are not vulnerable to Zip Slip attack
-	// described at https://snyk.io/research/zip-slip-vulnerability
-	filePath := filepath.Join(destination, file.Name)
-	if !strings.HasPrefix(filePath, filepath.Clean(destination)+string(os.PathSeparator)) {
-		return "", fmt.Errorf("invalid file path: %s", filePath)
+// unzipAndSave unpacks every image in the zipped source, of the given size, into store
+// under the destination key prefix, through the image processing pipeline.
+// The zip is read in place, nothing is written to disk.
+// Zips with too many entries, or whose entries add up to more than maxUnzippedSize,
+// are rejected before anything is decompressed. Since the declared sizes can't be trusted,
+// each entry is also cut off at maxImageSize while being decompressed.
+// Either every image is saved, or none: images saved before a failure are deleted.
+func unzipAndSave(store storage.BlobStore, source io.ReaderAt, size int64, destination string) ([]savedImage, db.ErrorType, error) {
+	reader, err := zip.NewReader(source, size)
+	if err != nil {
+		return nil, db.ValidationError, errInvalidZip
 	}
 
-	// create directory tree corresponding to file path into destination path
-	if file.FileInfo().IsDir() {
-		if err := os.MkdirAll(filePath, 0700); err != nil {
-			return "", err
+	if len(reader.File) > maxZipEntries {
+		return nil, db.ValidationError, errTooManyImages
+	}
+	var fileCount int
+	var unzippedSize uint64
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
 		}
-		return "", nil
+		fileCount++
+		unzippedSize += f.UncompressedSize64
 	}
-
-	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
-		return "", err
+	if fileCount == 0 {
+		return nil, db.ValidationError, errMissingImages
+	}
+	if fileCount > maxEvidenceImages {
+		return nil, db.ValidationError, errTooManyImages
+	}
+	if unzippedSize > maxUnzippedSize {
+		return nil, db.ValidationError, errZipTooLarge
 	}
 
-	// create destination file for unzipped file content
-	destinationFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-	if err != nil {
-		return "", err
+	// unpack each file inside zipped file to destination, keyed by its position like
+	// uploaded images, since entry names can collide once their extensions are replaced,
+	// e.g., a.jpg and a.png
+	var images []savedImage
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		saved, err := unpackFile(store, f, fmt.Sprintf("%s/%d", destination, len(images)))
+		if err != nil {
+			deleteSavedImages(store, images)
+			if isImageValidationError(err) {
+				return nil, db.ValidationError, errors.Wrap(err, fmt.Sprintf("invalid evidence image %s", f.Name))
+			}
+			switch errors.Cause(err) {
+			case zip.ErrFormat, zip.ErrAlgorithm, zip.ErrChecksum:
+				return nil, db.ValidationError, errInvalidZip
+			}
+			return nil, db.InternalError, errors.Wrap(err, "unable to unpack file")
+		}
+		images = append(images, *saved)
 	}
-	defer destinationFile.Close()
 
-	// unzip the content of file and copy it to destination file
+	return images, db.None, nil
+}
+
+// unpackFile unpacks a single zipped image into store as key, see saveImage,
+// through the image processing pipeline.
+// The name of file isn't used, so zipped paths can't escape the destination of the images (Zip Slip)
+func unpackFile(store storage.BlobStore, file *zip.File, key string) (*savedImage, error) {
 	zippedFile, err := file.Open()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer zippedFile.Close()
 
-	if _, err := io.Copy(destinationFile, zippedFile); err != nil {
-		return "", err
+	return saveImage(store, zippedFile, key)
+}
+
+// savedImage holds the keys of the stored versions of an uploaded image
+// and the fingerprint of the upload
+type savedImage struct {
+	key          string
+	thumbnailKey string
+	fingerprint  imaging.Fingerprint
+}
+
+// splitSavedImages splits images into their keys, thumbnail keys and fingerprints, in the same order
+func splitSavedImages(images []savedImage) (keys, thumbnailKeys []string, fingerprints []model.ImageFingerprint) {
+	for _, image := range images {
+		keys = append(keys, image.key)
+		thumbnailKeys = append(thumbnailKeys, image.thumbnailKey)
+		fingerprints = append(fingerprints, imageFingerprint(image.fingerprint))
 	}
-	savedPath := fmt.Sprintf("%s/%s", destination, file.Name)
-	return savedPath, nil
+	return keys, thumbnailKeys, fingerprints
 }
 
-// saveFile renames file to saveAs and saves file into savePath.
-// If file is bigger than 5mb, errFileTooLarge is returned.
-// Any other error returned is a server error.
-// Note that saveAs must not end with a trailing slash.
-// saveFile closes file before returning
-func saveFile(file multipart.File, saveAs, savePath string) (fileUrl string, err error) {
-	defer file.Close()
+// imageFingerprint converts fingerprint into its stored form
+func imageFingerprint(fingerprint imaging.Fingerprint) model.ImageFingerprint {
+	return model.ImageFingerprint{
+		ContentHash:    fingerprint.ContentHash,
+		PerceptualHash: strconv.FormatUint(fingerprint.PerceptualHash, 16),
+	}
+}
+
+// saveImage runs file through the image processing pipeline and puts the display
+// version as key.<ext> and the thumbnail as key_thumb.<ext> into store,
+// where ext is derived from the actual image format rather than the uploaded file name.
+// If file is bigger than maxImageSize, errFileTooLarge is returned.
+// If file isn't a jpeg, png or webp image, imaging.ErrUnsupportedFormat is returned.
+// Use isImageValidationError to distinguish client errors from server errors.
+// Note that key must not contain a file extension.
+func saveImage(store storage.BlobStore, file io.Reader, key string) (*savedImage, error) {
 	buffer := &bytes.Buffer{}
-	fileSize, err := buffer.ReadFrom(file)
+	fileSize, err := buffer.ReadFrom(io.LimitReader(file, maxImageSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading file")
+	}
+	if fileSize > maxImageSize {
+		return nil, errFileTooLarge
+	}
 
+	processed, err := imaging.Process(buffer.Bytes())
 	if err != nil {
-		return "", errors.Wrap(err, "error reading file")
+		return nil, err
 	}
 
-	const fiveMB = 5 << 20
-	if fileSize > fiveMB {
-		return "", errFileTooLarge
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	saved := &savedImage{
+		key:          fmt.Sprintf("%s.%s", key, processed.Display.Extension),
+		thumbnailKey: fmt.Sprintf("%s_thumb.%s", key, processed.Thumbnail.Extension),
+		fingerprint:  processed.Fingerprint,
+	}
+	display, thumbnail := processed.Display, processed.Thumbnail
+	if err := store.Put(ctx, saved.key, bytes.NewReader(display.Data),
+		int64(len(display.Data)), display.ContentType); err != nil {
+		return nil, errors.Wrap(err, "error saving image")
+	}
+	if err := store.Put(ctx, saved.thumbnailKey, bytes.NewReader(thumbnail.Data),
+		int64(len(thumbnail.Data)), thumbnail.ContentType); err != nil {
+		store.Delete(ctx, saved.key)
+		return nil, errors.Wrap(err, "error saving image thumbnail")
 	}
 
-	fileUrl = savePath + "/" + saveAs
+	return saved, nil
+}
 
-	out, err := os.Create(fileUrl)
-	defer out.Close()
-	if err != nil {
+// deleteSavedImages removes every version of images from store
+func deleteSavedImages(store storage.BlobStore, images []savedImage) {
+	keys, thumbnailKeys, _ := splitSavedImages(images)
+	deleteBlobs(store, append(keys, thumbnailKeys...)...)
+}
 
-		return "", errors.Wrap(err, "error creating temp file")
-	}
+// deleteBlobs removes every blob identified by keys from store.
+// Failures are logged rather than returned since deleteBlobs is used for clean up
+func deleteBlobs(store storage.BlobStore, keys ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	_, err = io.Copy(out, buffer)
-	if err != nil {
-		return "", errors.Wrap(err, "error saving file")
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			logger.Logger.LogError(fmt.Sprintf("failed to delete blob %s", key), "delete blobs", err)
+		}
 	}
-	fileUrl = strings.TrimPrefix(fileUrl, ".")
-	return fileUrl, nil
+}
+
+// isImageValidationError reports if err, as returned by saveImage,
+// was caused by an invalid upload rather than a server fault
+func isImageValidationError(err error) bool {
+	return err == errFileTooLarge ||
+		err == imaging.ErrUnsupportedFormat ||
+		err == imaging.ErrImageTooLarge
 }
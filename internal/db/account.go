@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// maxOneTimeCodeAttempts is how many answers a one-time code accepts before it is used up
+const maxOneTimeCodeAttempts = 5
+
+func (m *Mongo) createOneTimeCodesCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"purpose", "email", "uid", "codeHash", "expiresOn"},
+		"properties": bson.M{
+			"purpose": bson.M{
+				"enum": []model.CodePurpose{model.CodeEmailVerification, model.CodeAccountRecovery, model.CodeSessionClaim},
+			},
+			"email": bson.M{
+				"bsonType": "string",
+			},
+			"uid": bson.M{
+				"bsonType": "string",
+			},
+			"codeHash": bson.M{
+				"bsonType": "string",
+			},
+			"expiresOn": bson.M{
+				"bsonType": "date",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, oneTimeCodes, opts); err != nil {
+		logger.Logger.LogError("failed to create one-time codes collection",
+			"create one-time codes collection", err)
+	}
+
+	// an email has at most one outstanding code per purpose, which mongo removes once it expires
+	_, err := m.db.Collection(oneTimeCodes).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"purpose", 1}, {"email", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{"expiresOn", 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create one-time codes indexes",
+			"create one-time codes collection", err)
+	}
+}
+
+func (m *Mongo) createSessionsCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"uid", "tokenHash", "expiresOn"},
+		"properties": bson.M{
+			"uid": bson.M{
+				"bsonType": "string",
+			},
+			"tokenHash": bson.M{
+				"bsonType": "string",
+			},
+			"expiresOn": bson.M{
+				"bsonType": "date",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, sessions, opts); err != nil {
+		logger.Logger.LogError("failed to create sessions collection",
+			"create sessions collection", err)
+	}
+
+	_, err := m.db.Collection(sessions).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"tokenHash", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{"uid", 1}}},
+		{
+			Keys:    bson.D{{"expiresOn", 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create sessions indexes",
+			"create sessions collection", err)
+	}
+}
+
+// InsertOneTimeCode stores code, replacing any code previously issued for the same purpose and email
+// provided it was issued before notBefore.
+// Returns ErrOneTimeCodeTooSoon if the previous code is more recent
+func (m *Mongo) InsertOneTimeCode(code *model.OneTimeCode, notBefore time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// a recent code doesn't match the filter, so the upsert collides with it on the unique index
+	filter := bson.D{
+		{"purpose", code.Purpose},
+		{"email", code.Email},
+		{"createdOn", bson.D{{"$lt", notBefore}}},
+	}
+	opts := options.Replace().SetUpsert(true)
+	if _, err := m.db.Collection(oneTimeCodes).ReplaceOne(ctx, filter, code, opts); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrOneTimeCodeTooSoon
+		}
+		return errors.Wrap(err, "failed to insert one-time code into db")
+	}
+	return nil
+}
+
+// ConsumeOneTimeCode checks codeHash against the unexpired code issued for purpose and email,
+// deleting the code if it matches so that it can't be answered twice.
+// Every answer counts against the code's attempts.
+// Returns ErrOneTimeCodeNotFound if there is no such code, or it ran out of attempts,
+// and ErrOneTimeCodeMismatch if codeHash doesn't match
+func (m *Mongo) ConsumeOneTimeCode(purpose model.CodePurpose, email, codeHash string) (*model.OneTimeCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{"purpose", purpose},
+		{"email", email},
+		{"expiresOn", bson.D{{"$gt", time.Now()}}},
+		{"attempts", bson.D{{"$lt", maxOneTimeCodeAttempts}}},
+	}
+	var code model.OneTimeCode
+	err := m.db.Collection(oneTimeCodes).
+		FindOneAndUpdate(ctx, filter, bson.D{{"$inc", bson.D{{"attempts", 1}}}}).Decode(&code)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrOneTimeCodeNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch one-time code")
+	}
+	if subtle.ConstantTimeCompare([]byte(code.CodeHash), []byte(codeHash)) != 1 {
+		return nil, ErrOneTimeCodeMismatch
+	}
+
+	result, err := m.db.Collection(oneTimeCodes).DeleteOne(ctx, bson.D{{"_id", code.ID}})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to consume one-time code")
+	}
+	if result.DeletedCount == 0 {
+
+		// a concurrent request answered the code first
+		return nil, ErrOneTimeCodeNotFound
+	}
+	return &code, nil
+}
+
+// VerifyUserEmail sets the email of the user identified by uid to the verified email.
+// Returns ErrUserNotFound if uid is unknown and ErrEmailInUse if another user verified email
+func (m *Mongo) VerifyUserEmail(uid, email string, verifiedOn time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.D{
+		{"$set", bson.D{{"email", email}, {"emailVerifiedOn", verifiedOn}}},
+		{"$inc", bson.D{{"version", 1}}},
+	}
+	result, err := m.db.Collection(users).UpdateOne(ctx, bson.D{{"uid", uid}}, update)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrEmailInUse
+		}
+		return errors.Wrap(err, "failed to verify user email")
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// FetchUserByVerifiedEmail fetches the user who verified email.
+// Returns ErrUserNotFound if no user verified email
+func (m *Mongo) FetchUserByVerifiedEmail(email string) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"email", email}, {"emailVerifiedOn", bson.D{{"$exists", true}}}}
+	var user model.User
+	if err := m.db.Collection(users).FindOne(ctx, filter).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch user by email")
+	}
+	return &user, nil
+}
+
+// ClaimFirstSession records that the first session of the user identified by uid is issued.
+// Returns ErrUserNotFound if uid is unknown and ErrSessionAlreadyClaimed if it already was
+func (m *Mongo) ClaimFirstSession(uid string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"uid", uid}, {"firstSessionOn", bson.D{{"$exists", false}}}}
+	result, err := m.db.Collection(users).UpdateOne(ctx, filter, bson.D{{"$set", bson.D{{"firstSessionOn", at}}}})
+	if err != nil {
+		return errors.Wrap(err, "failed to claim first session")
+	}
+	if result.MatchedCount == 0 {
+		if err := m.IsValidUser(uid); err != nil {
+			return err
+		}
+		return ErrSessionAlreadyClaimed
+	}
+	return nil
+}
+
+func (m *Mongo) InsertSession(session *model.Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(sessions).InsertOne(ctx, session)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert session into db")
+	}
+	session.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// FetchSession fetches the unexpired session whose token hashes to tokenHash.
+// Returns ErrSessionNotFound if there is no such session
+func (m *Mongo) FetchSession(tokenHash string) (*model.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"tokenHash", tokenHash}, {"expiresOn", bson.D{{"$gt", time.Now()}}}}
+	var session model.Session
+	if err := m.db.Collection(sessions).FindOne(ctx, filter).Decode(&session); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSessionNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch session")
+	}
+	return &session, nil
+}
+
+// DeleteSession revokes the session identified by id
+func (m *Mongo) DeleteSession(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := m.db.Collection(sessions).DeleteOne(ctx, bson.D{{"_id", id}}); err != nil {
+		return errors.Wrap(err, "failed to delete session")
+	}
+	return nil
+}
+
+// DeleteUserSessions revokes every session of the user identified by uid
+func (m *Mongo) DeleteUserSessions(uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := m.db.Collection(sessions).DeleteMany(ctx, bson.D{{"uid", uid}}); err != nil {
+		return errors.Wrap(err, "failed to delete user sessions")
+	}
+	return nil
+}
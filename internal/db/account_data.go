@@ -0,0 +1,330 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// userDataFilters select the documents of a user in every collection holding user data,
+// given their uid and the ids of their incidence reports
+var userDataFilters = []struct {
+	collection string
+	filter     func(uid string, reportIds []primitive.ObjectID) bson.D
+}{
+	{users, byUID},
+	{airdropSubmissions, byUID},
+	{incidenceReports, byUID},
+	{notifications, byUID},
+	{rewards, byUID},
+	{scans, byUID},
+	{referrals, func(uid string, _ []primitive.ObjectID) bson.D {
+		return bson.D{{"$or", bson.A{bson.D{{"referrerId", uid}}, bson.D{{"inviteeId", uid}}}}}
+	}},
+	{leaderboardScores, byUID},
+	{userChanges, byUID},
+	{pharmacies, func(uid string, _ []primitive.ObjectID) bson.D { return bson.D{{"suggestedBy", uid}} }},
+	{webhookDeliveries, func(_ string, reportIds []primitive.ObjectID) bson.D { return aboutReports(reportIds) }},
+}
+
+func byUID(uid string, _ []primitive.ObjectID) bson.D {
+	return bson.D{{"uid", uid}}
+}
+
+// aboutReports matches the webhook deliveries whose payload is about any of the incidence reports
+// identified by reportIds. Payloads are kept as sent, so they are matched on the reports' hex ids
+func aboutReports(reportIds []primitive.ObjectID) bson.D {
+	patterns := bson.A{}
+	for _, id := range reportIds {
+		patterns = append(patterns, primitive.Regex{Pattern: id.Hex()})
+	}
+	return bson.D{{"payload", bson.D{{"$in", patterns}}}}
+}
+
+// userIncidenceReportIDs returns the ids of the incidence reports submitted by the user identified by uid
+func (m *Mongo) userIncidenceReportIDs(ctx context.Context, uid string) ([]primitive.ObjectID, error) {
+	opts := options.Find().SetProjection(bson.D{{"_id", 1}})
+	curs, err := m.db.Collection(incidenceReports).Find(ctx, bson.D{{"uid", uid}}, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch incidence report ids")
+	}
+	var result []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, errors.Wrap(err, "fetch incidence report ids: failed to decode find result into slice")
+	}
+	ids := make([]primitive.ObjectID, 0, len(result))
+	for _, report := range result {
+		ids = append(ids, report.ID)
+	}
+	return ids, nil
+}
+
+func (m *Mongo) createAccountDeletionsCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"uidHash", "anonymousId", "requestedOn"},
+		"properties": bson.M{
+			"uidHash": bson.M{
+				"bsonType": "string",
+			},
+			"anonymousId": bson.M{
+				"bsonType": "string",
+			},
+			"requestedOn": bson.M{
+				"bsonType": "date",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, accountDeletions, opts); err != nil {
+		logger.Logger.LogError("failed to create account deletions collection",
+			"create account deletions collection", err)
+	}
+
+	_, err := m.db.Collection(accountDeletions).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"uidHash", 1}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create account deletions index",
+			"create account deletions collection", err)
+	}
+}
+
+// ExportUserData calls fn with the documents of the user identified by uid in each collection
+// holding user data, as a relaxed extended JSON array. Streaming stops at the first error returned by fn
+func (m *Mongo) ExportUserData(uid string, fn func(collection string, documents []byte) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	reportIds, err := m.userIncidenceReportIDs(ctx, uid)
+	if err != nil {
+		return err
+	}
+	for _, source := range userDataFilters {
+		curs, err := m.db.Collection(source.collection).Find(ctx, source.filter(uid, reportIds))
+		if err != nil {
+			return errors.Wrapf(err, "failed to export %s", source.collection)
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString("[")
+		for i := 0; curs.Next(ctx); i++ {
+			doc, err := bson.MarshalExtJSONIndent(curs.Current, false, false, "  ", "  ")
+			if err != nil {
+				curs.Close(ctx)
+				return errors.Wrapf(err, "export %s: failed to encode document", source.collection)
+			}
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n  ")
+			buf.Write(doc)
+		}
+		err = curs.Err()
+		curs.Close(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "failed to export %s", source.collection)
+		}
+		buf.WriteString("\n]\n")
+
+		if err := fn(source.collection, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertAccountDeletion records that a user requested to delete their account
+func (m *Mongo) InsertAccountDeletion(deletion *model.AccountDeletion) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(accountDeletions).InsertOne(ctx, deletion)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert account deletion into db")
+	}
+	deletion.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// FetchPendingAccountDeletion returns the account deletion of the user whose uid hashes to uidHash
+// that was requested but not completed, e.g., because it failed
+func (m *Mongo) FetchPendingAccountDeletion(uidHash string) (*model.AccountDeletion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"uidHash", uidHash}, {"completedOn", bson.D{{"$exists", false}}}}
+	opts := options.FindOne().SetSort(bson.D{{"requestedOn", -1}})
+	var deletion model.AccountDeletion
+	if err := m.db.Collection(accountDeletions).FindOne(ctx, filter, opts).Decode(&deletion); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAccountDeletionNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch pending account deletion")
+	}
+	return &deletion, nil
+}
+
+// AddAccountDeletionImageKeys records keys among the image keys of deletion, see model.AccountDeletion.ImageKeys
+func (m *Mongo) AddAccountDeletionImageKeys(deletion *model.AccountDeletion, keys []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.D{{"$addToSet", bson.D{{"imageKeys", bson.D{{"$each", keys}}}}}}
+	if _, err := m.db.Collection(accountDeletions).UpdateOne(ctx, bson.D{{"_id", deletion.ID}}, update); err != nil {
+		return errors.Wrap(err, "failed to record account deletion image keys")
+	}
+	for _, key := range keys {
+		if !containsString(deletion.ImageKeys, key) {
+			deletion.ImageKeys = append(deletion.ImageKeys, key)
+		}
+	}
+	return nil
+}
+
+// CompleteAccountDeletion records the outcome of deletion once the user's data is deleted
+func (m *Mongo) CompleteAccountDeletion(deletion *model.AccountDeletion) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.D{{"$set", bson.D{
+		{"erased", deletion.Erased},
+		{"anonymised", deletion.Anonymised},
+		{"deletedImages", deletion.DeletedImages},
+		{"completedOn", deletion.CompletedOn},
+	}}}
+	if _, err := m.db.Collection(accountDeletions).UpdateOne(ctx, bson.D{{"_id", deletion.ID}}, update); err != nil {
+		return errors.Wrap(err, "failed to complete account deletion")
+	}
+	return nil
+}
+
+// DeleteUserData erases the data of the user identified by uid: the user, their airdrop submissions,
+// notifications, sessions, one-time codes, wallet challenges, leaderboard scores and change history.
+// Their incidence reports, rewards, scans, referrals and the pharmacies they suggested are kept for the
+// investigations and accounting they are part of, with deletion.AnonymousID in place of uid.
+// Incidence reports, and the webhook deliveries about them, lose the reporter's coordinates. Reports
+// also lose their receipt, the receipt images are left for the caller to delete, and referrals lose
+// where the invitee signed up from.
+// deletion.Erased and deletion.Anonymised are set to the number of documents affected per collection.
+// The user's sessions are erased last, so the user can retry the deletion if DeleteUserData fails
+func (m *Mongo) DeleteUserData(uid string, deletion *model.AccountDeletion) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	anonymousId := deletion.AnonymousID
+	deletion.Erased = make(map[string]int64)
+	deletion.Anonymised = make(map[string]int64)
+
+	// deliveries are found through the reports, so they are anonymised while reports still link to uid
+	reportIds, err := m.userIncidenceReportIDs(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if deletion.Anonymised[webhookDeliveries], err = m.anonymiseWebhookDeliveries(ctx, reportIds); err != nil {
+		return err
+	}
+
+	anonymisations := []struct {
+		collection string
+		filter     bson.D
+		update     bson.D
+	}{
+		{incidenceReports, bson.D{{"uid", uid}}, bson.D{
+			{"$set", bson.D{{"uid", anonymousId}, {"receiptImageUrl", ""}, {"receiptThumbnailUrl", ""}}},
+			{"$unset", bson.D{{"coordinates", ""}}},
+		}},
+		{rewards, bson.D{{"uid", uid}}, bson.D{{"$set", bson.D{{"uid", anonymousId}}}}},
+		{scans, bson.D{{"uid", uid}}, bson.D{{"$set", bson.D{{"uid", anonymousId}}}}},
+		{referrals, bson.D{{"referrerId", uid}}, bson.D{{"$set", bson.D{{"referrerId", anonymousId}}}}},
+		{referrals, bson.D{{"inviteeId", uid}}, bson.D{
+			{"$set", bson.D{{"inviteeId", anonymousId}}},
+			{"$unset", bson.D{{"ipAddress", ""}, {"deviceId", ""}}},
+		}},
+		{pharmacies, bson.D{{"suggestedBy", uid}}, bson.D{{"$set", bson.D{{"suggestedBy", anonymousId}}}}},
+	}
+	for _, a := range anonymisations {
+		result, err := m.db.Collection(a.collection).UpdateMany(ctx, a.filter, a.update)
+		if err != nil {
+			return errors.Wrapf(err, "failed to anonymise %s", a.collection)
+		}
+		deletion.Anonymised[a.collection] += result.ModifiedCount
+	}
+
+	for _, collection := range []string{airdropSubmissions, notifications, oneTimeCodes,
+		walletChallenges, leaderboardScores, userChanges, users, sessions} {
+		result, err := m.db.Collection(collection).DeleteMany(ctx, bson.D{{"uid", uid}})
+		if err != nil {
+			return errors.Wrapf(err, "failed to erase %s", collection)
+		}
+		deletion.Erased[collection] = result.DeletedCount
+	}
+	return nil
+}
+
+// anonymiseWebhookDeliveries removes the reporter's coordinates from the payloads of the webhook deliveries
+// about the incidence reports identified by reportIds, and returns the number of deliveries anonymised
+func (m *Mongo) anonymiseWebhookDeliveries(ctx context.Context, reportIds []primitive.ObjectID) (int64, error) {
+	if len(reportIds) == 0 {
+		return 0, nil
+	}
+	opts := options.Find().SetProjection(bson.D{{"payload", 1}})
+	curs, err := m.db.Collection(webhookDeliveries).Find(ctx, aboutReports(reportIds), opts)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch webhook deliveries")
+	}
+	var deliveries []model.WebhookDelivery
+	if err := curs.All(ctx, &deliveries); err != nil {
+		return 0, errors.Wrap(err, "fetch webhook deliveries: failed to decode find result into slice")
+	}
+
+	var anonymised int64
+	for _, delivery := range deliveries {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(delivery.Payload), &event); err != nil {
+			return anonymised, errors.Wrap(err, "failed to decode webhook delivery payload")
+		}
+		data, ok := event["data"].(map[string]interface{})
+		if !ok || data["coordinates"] == nil {
+			continue
+		}
+		delete(data, "coordinates")
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return anonymised, errors.Wrap(err, "failed to encode webhook delivery payload")
+		}
+
+		update := bson.D{{"$set", bson.D{{"payload", string(payload)}}}}
+		if _, err := m.db.Collection(webhookDeliveries).UpdateOne(ctx, bson.D{{"_id", delivery.ID}}, update); err != nil {
+			return anonymised, errors.Wrap(err, "failed to anonymise webhook delivery")
+		}
+		anonymised++
+	}
+	return anonymised, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,404 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// pendingSubmission matches submissions yet to be reviewed, including those made
+// before reviews were introduced, which have no status
+var pendingSubmission = bson.D{{"$in", bson.A{model.SubmissionPending, nil}}}
+
+// airdropSubmissionQuery translates filter into a mongo query
+func airdropSubmissionQuery(filter *model.AirdropSubmissionFilter) bson.D {
+	query := bson.D{}
+	if filter.CampaignID != nil {
+		query = append(query, bson.E{"campaignId", filter.CampaignID})
+	}
+	switch filter.Status {
+	case "":
+	case model.SubmissionPending:
+		query = append(query, bson.E{"status", pendingSubmission})
+	default:
+		query = append(query, bson.E{"status", filter.Status})
+	}
+	if filter.Flagged != nil {
+		if *filter.Flagged {
+			query = append(query, bson.E{"fraud.flagged", true})
+		} else {
+			query = append(query, bson.E{"fraud.flagged", bson.D{{"$ne", true}}})
+		}
+	}
+	if filter.HasWallet != nil {
+		if *filter.HasWallet {
+			query = append(query, bson.E{"wallet", bson.D{{"$gt", ""}}})
+		} else {
+			query = append(query, bson.E{"wallet", bson.D{{"$in", bson.A{"", nil}}}})
+		}
+	}
+
+	submittedOn := bson.D{}
+	if !filter.SubmittedFrom.IsZero() {
+		submittedOn = append(submittedOn, bson.E{"$gte", filter.SubmittedFrom})
+	}
+	if !filter.SubmittedTo.IsZero() {
+		submittedOn = append(submittedOn, bson.E{"$lte", filter.SubmittedTo})
+	}
+	if len(submittedOn) > 0 {
+		query = append(query, bson.E{"submittedOn", submittedOn})
+	}
+	return query
+}
+
+// FetchAirdropSubmissions fetches a page of the airdrop submissions matching filter.
+// Pending submissions are listed oldest first, so that they are reviewed in order,
+// others most recently submitted first
+func (m *Mongo) FetchAirdropSubmissions(filter *model.AirdropSubmissionFilter) (*[]model.AirdropSubmission, model.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := airdropSubmissionQuery(filter)
+	sort := bson.D{{"submittedOn", -1}, {"_id", -1}}
+	if filter.Status == model.SubmissionPending {
+		sort = bson.D{{"submittedOn", 1}, {"_id", 1}}
+	}
+
+	total, err := m.db.Collection(airdropSubmissions).CountDocuments(ctx, query)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to count airdrop submissions")
+	}
+
+	opts := options.Find().
+		SetSort(sort).
+		SetSkip(filter.Skip()).
+		SetLimit(filter.Limit())
+	curs, err := m.db.Collection(airdropSubmissions).Find(ctx, query, opts)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to fetch airdrop submissions")
+	}
+
+	result := make([]model.AirdropSubmission, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "fetch airdrop submissions: failed to decode find result into slice")
+	}
+	return &result, model.NewMetadata(total, filter.Pagination), nil
+}
+
+func (m *Mongo) FetchAirdropSubmissionByID(id primitive.ObjectID) (*model.AirdropSubmission, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var submission model.AirdropSubmission
+	err := m.db.Collection(airdropSubmissions).FindOne(ctx, bson.D{{"_id", id}}).Decode(&submission)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNoSubmissionFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch airdrop submission")
+	}
+	return &submission, nil
+}
+
+// ReviewAirdropSubmission records review as the outcome of the review of the pending submission
+// identified by id, moving it to status. The reviewed submission is returned.
+// Returns ErrNoSubmissionFound if id is unknown and ErrSubmissionAlreadyReviewed
+// if the submission was already approved or rejected
+func (m *Mongo) ReviewAirdropSubmission(id primitive.ObjectID, status model.SubmissionStatus,
+	review *model.SubmissionReview) (*model.AirdropSubmission, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"_id", id}, {"status", pendingSubmission}}
+	update := bson.D{{"$set", bson.D{{"status", status}, {"review", review}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var submission model.AirdropSubmission
+	err := m.db.Collection(airdropSubmissions).FindOneAndUpdate(ctx, filter, update, opts).Decode(&submission)
+	if err == nil {
+		return &submission, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, errors.Wrap(err, "failed to review airdrop submission")
+	}
+
+	// tell a missing submission from a reviewed one
+	count, err := m.db.Collection(airdropSubmissions).CountDocuments(ctx, bson.D{{"_id", id}})
+	if err != nil {
+		return nil, errors.Wrap(err, "review airdrop submission: failed to count submissions")
+	}
+	if count == 0 {
+		return nil, ErrNoSubmissionFound
+	}
+	return nil, ErrSubmissionAlreadyReviewed
+}
+
+// ReserveCampaignReward sets hrtTokens aside from the reward pool of the campaign identified by id.
+// Returns ErrCampaignNotFound if id is unknown and ErrRewardPoolExhausted
+// if what is left of the pool is less than hrtTokens
+func (m *Mongo) ReserveCampaignReward(id primitive.ObjectID, hrtTokens int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	distributed := bson.D{{"$ifNull", bson.A{"$rewardPool.distributedHrtTokens", 0}}}
+	filter := bson.D{
+		{"_id", id},
+		{"$expr", bson.D{{"$lte", bson.A{
+			bson.D{{"$add", bson.A{distributed, hrtTokens}}},
+			"$rewardPool.hrtTokens",
+		}}}},
+	}
+	update := bson.D{{"$inc", bson.D{{"rewardPool.distributedHrtTokens", hrtTokens}}}}
+	result, err := m.db.Collection(campaigns).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.Wrap(err, "failed to reserve campaign reward")
+	}
+	if result.MatchedCount == 1 {
+		return nil
+	}
+
+	if _, err := m.FetchCampaign(id); err != nil {
+		return err
+	}
+	return ErrRewardPoolExhausted
+}
+
+// ReleaseCampaignReward returns hrtTokens set aside by ReserveCampaignReward to the reward pool
+// of the campaign identified by id
+func (m *Mongo) ReleaseCampaignReward(id primitive.ObjectID, hrtTokens int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.D{{"$inc", bson.D{{"rewardPool.distributedHrtTokens", -hrtTokens}}}}
+	if _, err := m.db.Collection(campaigns).UpdateOne(ctx, bson.D{{"_id", id}}, update); err != nil {
+		return errors.Wrap(err, "failed to release campaign reward")
+	}
+	return nil
+}
+
+// submissionIdentifiers are the fields of an airdrop submission that identify a participant
+var submissionIdentifiers = []string{"telegramUsername", "twitterUsername", "tweetLink", "wallet", "email"}
+
+// FetchRelatedAirdropSubmissions fetches the submissions of users other than submission's
+// that share any identifier, IP address or device with submission, across campaigns.
+// At most limit submissions are fetched, most recent first
+func (m *Mongo) FetchRelatedAirdropSubmissions(submission *model.AirdropSubmission, limit int64) (*[]model.AirdropSubmission, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	values := map[string]string{
+		"telegramUsername": submission.TelegramUsername,
+		"twitterUsername":  submission.TwitterUsername,
+		"tweetLink":        submission.TweetLink,
+		"wallet":           submission.WalletAddress,
+		"email":            submission.EmailAddress,
+		"ipAddress":        submission.IPAddress,
+		"deviceId":         submission.DeviceID,
+	}
+	or := bson.A{}
+	for field, value := range values {
+		if value != "" {
+			or = append(or, bson.D{{field, value}})
+		}
+	}
+	result := make([]model.AirdropSubmission, 0)
+	if len(or) == 0 {
+		return &result, nil
+	}
+
+	filter := bson.D{{"uid", bson.D{{"$ne", submission.UserID}}}, {"$or", or}}
+	opts := options.Find().SetSort(bson.D{{"submittedOn", -1}}).SetLimit(limit)
+	curs, err := m.db.Collection(airdropSubmissions).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch related airdrop submissions")
+	}
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, errors.Wrap(err, "fetch related airdrop submissions: failed to decode find result into slice")
+	}
+	return &result, nil
+}
+
+// FlagAirdropSubmissions flags the submissions identified by ids as fraudulent
+// because they share identifiers with the submission identified by relatedId, adding reasons
+// to their fraud reasons and raising their fraud score to at least score
+func (m *Mongo) FlagAirdropSubmissions(ids []primitive.ObjectID, relatedId primitive.ObjectID, score int, reasons []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	update := bson.D{
+		{"$set", bson.D{{"fraud.flagged", true}}},
+		{"$max", bson.D{{"fraud.score", score}}},
+		{"$addToSet", bson.D{
+			{"fraud.reasons", bson.D{{"$each", reasons}}},
+			{"fraud.relatedSubmissions", relatedId},
+		}},
+	}
+	_, err := m.db.Collection(airdropSubmissions).UpdateMany(ctx, bson.D{{"_id", bson.D{{"$in", ids}}}}, update)
+	if err != nil {
+		return errors.Wrap(err, "failed to flag airdrop submissions")
+	}
+	return nil
+}
+
+// FetchAirdropSubmissionsAfter fetches at most limit airdrop submissions matching filter, most recent
+// first, starting right after cursor, or with the most recent submission if cursor is nil.
+// filter's Pagination is ignored
+func (m *Mongo) FetchAirdropSubmissionsAfter(filter *model.AirdropSubmissionFilter, cursor *model.SubmissionCursor,
+	limit int64) (*[]model.AirdropSubmission, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := airdropSubmissionQuery(filter)
+	if cursor != nil {
+		query = append(query, bson.E{"$or", bson.A{
+			bson.D{{"submittedOn", bson.D{{"$lt", cursor.SubmittedOn}}}},
+			bson.D{{"submittedOn", cursor.SubmittedOn}, {"_id", bson.D{{"$lt", cursor.ID}}}},
+		}})
+	}
+
+	opts := options.Find().SetSort(bson.D{{"submittedOn", -1}, {"_id", -1}}).SetLimit(limit)
+	curs, err := m.db.Collection(airdropSubmissions).Find(ctx, query, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch airdrop submissions")
+	}
+
+	result := make([]model.AirdropSubmission, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, errors.Wrap(err, "fetch airdrop submissions: failed to decode find result into slice")
+	}
+	return &result, nil
+}
+
+// StreamAirdropSubmissions calls fn with every airdrop submission matching filter, most recent first,
+// without loading them all in memory. Streaming stops at the first error returned by fn, which is returned.
+// filter's Pagination is ignored
+func (m *Mongo) StreamAirdropSubmissions(filter *model.AirdropSubmissionFilter, fn func(*model.AirdropSubmission) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{"submittedOn", -1}, {"_id", -1}}).SetBatchSize(500)
+	curs, err := m.db.Collection(airdropSubmissions).Find(ctx, airdropSubmissionQuery(filter), opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to stream airdrop submissions")
+	}
+	defer curs.Close(ctx)
+
+	for curs.Next(ctx) {
+		var submission model.AirdropSubmission
+		if err := curs.Decode(&submission); err != nil {
+			return errors.Wrap(err, "stream airdrop submissions: failed to decode submission")
+		}
+		if err := fn(&submission); err != nil {
+			return err
+		}
+	}
+	if err := curs.Err(); err != nil {
+		return errors.Wrap(err, "failed to stream airdrop submissions")
+	}
+	return nil
+}
+
+// FetchAirdropStats aggregates the airdrop submissions matching filter, see model.AirdropStats.
+// filter's Pagination is ignored
+func (m *Mongo) FetchAirdropStats(filter *model.AirdropSubmissionFilter) (*model.AirdropStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// completed counts the submissions where field is set
+	completed := func(field string) bson.D {
+		return bson.D{{"$sum", bson.D{{"$cond", bson.A{bson.D{{"$gt", bson.A{field, ""}}}, 1, 0}}}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", airdropSubmissionQuery(filter)}},
+		{{"$facet", bson.D{
+			{"total", bson.A{bson.D{{"$count", "count"}}}},
+			{"perDay", bson.A{
+				bson.D{{"$group", bson.D{
+					{"_id", bson.D{{"$dateToString", bson.D{{"format", "%Y-%m-%d"}, {"date", "$submittedOn"}}}}},
+					{"count", bson.D{{"$sum", 1}}},
+				}}},
+				bson.D{{"$sort", bson.D{{"_id", 1}}}},
+			}},
+			{"platforms", bson.A{
+				bson.D{{"$group", bson.D{
+					{"_id", nil},
+					{"telegram", completed("$telegramUsername")},
+					{"twitter", completed("$twitterUsername")},
+					{"tweet", completed("$tweetLink")},
+					{"youtube", completed("$youtubeUsername")},
+					{"wallet", completed("$wallet")},
+					{"email", completed("$email")},
+				}}},
+			}},
+			{"statuses", bson.A{
+				bson.D{{"$group", bson.D{
+					{"_id", bson.D{{"$ifNull", bson.A{"$status", model.SubmissionPending}}}},
+					{"count", bson.D{{"$sum", 1}}},
+				}}},
+			}},
+			{"flagged", bson.A{
+				bson.D{{"$match", bson.D{{"fraud.flagged", true}}}},
+				bson.D{{"$count", "count"}},
+			}},
+		}}},
+	}
+
+	curs, err := m.db.Collection(airdropSubmissions).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to aggregate airdrop stats")
+	}
+
+	type count struct {
+		Count int64 `bson:"count"`
+	}
+	var result []struct {
+		Total     []count            `bson:"total"`
+		PerDay    []model.DailyCount `bson:"perDay"`
+		Platforms []map[string]int64 `bson:"platforms"`
+		Statuses  []struct {
+			ID    model.SubmissionStatus `bson:"_id"`
+			Count int64                  `bson:"count"`
+		} `bson:"statuses"`
+		Flagged []count `bson:"flagged"`
+	}
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, errors.Wrap(err, "fetch airdrop stats: failed to decode aggregation result")
+	}
+
+	stats := &model.AirdropStats{
+		PerDay:   make([]model.DailyCount, 0),
+		Statuses: make(map[model.SubmissionStatus]int64),
+	}
+	if len(result) == 0 {
+		return stats, nil
+	}
+	facets := result[0]
+	if len(facets.Total) > 0 {
+		stats.Total = facets.Total[0].Count
+	}
+	if len(facets.Flagged) > 0 {
+		stats.Flagged = facets.Flagged[0].Count
+	}
+	stats.PerDay = append(stats.PerDay, facets.PerDay...)
+	for _, status := range facets.Statuses {
+		stats.Statuses[status.ID] = status.Count
+	}
+
+	var platforms map[string]int64
+	if len(facets.Platforms) > 0 {
+		platforms = facets.Platforms[0]
+	}
+	for _, platform := range []string{"telegram", "twitter", "tweet", "youtube", "wallet", "email"} {
+		platformStats := model.PlatformStats{Platform: platform, Submissions: platforms[platform]}
+		if stats.Total > 0 {
+			platformStats.CompletionRate = float64(platformStats.Submissions) / float64(stats.Total)
+		}
+		stats.Platforms = append(stats.Platforms, platformStats)
+	}
+	return stats, nil
+}
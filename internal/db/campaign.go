@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (m *Mongo) createCampaignsCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"name", "tasks", "startsOn", "endsOn", "rewardPool"},
+		"properties": bson.M{
+			"name": bson.M{
+				"bsonType": "string",
+			},
+			"tasks": bson.M{
+				"bsonType": "array",
+				"minItems": 1,
+			},
+			"startsOn": bson.M{
+				"bsonType": "date",
+			},
+			"endsOn": bson.M{
+				"bsonType": "date",
+			},
+			"rewardPool": bson.M{
+				"bsonType": "object",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, campaigns, opts); err != nil {
+		logger.Logger.LogError("failed to create campaigns collection",
+			"create campaigns collection", err)
+	}
+
+	_, err := m.db.Collection(campaigns).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"endsOn", 1}, {"startsOn", 1}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create campaigns index",
+			"create campaigns collection", err)
+	}
+}
+
+func (m *Mongo) InsertCampaign(campaign *model.Campaign) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(campaigns).InsertOne(ctx, campaign)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert campaign into db")
+	}
+	campaign.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (m *Mongo) FetchCampaign(id primitive.ObjectID) (*model.Campaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var campaign model.Campaign
+	err := m.db.Collection(campaigns).FindOne(ctx, bson.D{{"_id", id}}).Decode(&campaign)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCampaignNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch campaign")
+	}
+	return &campaign, nil
+}
+
+// FetchActiveCampaigns fetches the campaigns running at t, those ending soonest first
+func (m *Mongo) FetchActiveCampaigns(t time.Time) (*[]model.Campaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{"startsOn", bson.D{{"$lte", t}}},
+		{"endsOn", bson.D{{"$gt", t}}},
+	}
+	opts := options.Find().SetSort(bson.D{{"endsOn", 1}, {"_id", 1}})
+	curs, err := m.db.Collection(campaigns).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch active campaigns")
+	}
+
+	result := make([]model.Campaign, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, errors.Wrap(err, "fetch active campaigns: failed to decode find result into slice")
+	}
+	return &result, nil
+}
@@ -0,0 +1,293 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func (m *Mongo) FetchIncidenceReports(filter *model.IncidenceReportFilter) (*[]model.IncidenceReport, model.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	query := incidenceReportQuery(filter)
+	total, err := m.db.Collection(incidenceReports).CountDocuments(ctx, query)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to count incidence reports")
+	}
+
+	// updates and transitions are left out of listings to keep them light,
+	// they are served with the details of a single report
+	opts := options.Find().
+		SetSort(incidenceReportSort(filter.Sort)).
+		SetSkip(filter.Skip()).
+		SetLimit(filter.Limit()).
+		SetProjection(bson.D{{"updates", 0}, {"transitions", 0}})
+	curs, err := m.db.Collection(incidenceReports).Find(ctx, query, opts)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to fetch incidence reports")
+	}
+
+	reports := make([]model.IncidenceReport, 0)
+	if err := curs.All(ctx, &reports); err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "fetch incidence reports: failed to decode find result into slice")
+	}
+
+	return &reports, model.NewMetadata(total, filter.Pagination), nil
+}
+
+// incidenceReportQuery converts filter into a mongo query
+func incidenceReportQuery(filter *model.IncidenceReportFilter) bson.D {
+	query := bson.D{}
+
+	if filter.State == model.ReportNew {
+		// reports submitted before the workflow was introduced have no state and are treated as new
+		query = append(query, bson.E{"state", bson.D{{"$in", bson.A{model.ReportNew, nil}}}})
+	} else if filter.State != "" {
+		query = append(query, bson.E{"state", filter.State})
+	}
+	if filter.PharmacyName != "" {
+		query = append(query, bson.E{"pharmacyName", containsPattern(filter.PharmacyName)})
+	}
+	if filter.Location != "" {
+		query = append(query, bson.E{"pharmacyLocation", containsPattern(filter.Location)})
+	}
+	if filter.Drug != "" {
+		query = append(query, bson.E{"drugName", containsPattern(filter.Drug)})
+	}
+	if filter.Search != "" {
+		query = append(query, bson.E{"$text", bson.D{{"$search", filter.Search}}})
+	}
+	if filter.PharmacyID != nil {
+		query = append(query, bson.E{"pharmacyId", *filter.PharmacyID})
+	}
+	if filter.DrugID != nil {
+		query = append(query, bson.E{"drugId", *filter.DrugID})
+	}
+	if filter.Batch != "" {
+		query = append(query, bson.E{"batchNumber", equalFoldPattern(filter.Batch)})
+	}
+	if filter.Near != nil {
+		query = append(query, bson.E{"coordinates", withinRadius(filter.Near, filter.RadiusKm)})
+	}
+	if filter.Flagged != nil {
+		if *filter.Flagged {
+			query = append(query, bson.E{"moderation.flagged", true})
+		} else {
+			query = append(query, bson.E{"moderation.flagged", bson.D{{"$ne", true}}})
+		}
+	}
+
+	submitted := bson.D{}
+	if !filter.SubmittedFrom.IsZero() {
+		submitted = append(submitted, bson.E{"$gte", filter.SubmittedFrom})
+	}
+	if !filter.SubmittedTo.IsZero() {
+		submitted = append(submitted, bson.E{"$lte", filter.SubmittedTo})
+	}
+	if len(submitted) > 0 {
+		query = append(query, bson.E{"submittedOn", submitted})
+	}
+
+	return query
+}
+
+// incidenceReportSort converts sort, a key of model.IncidenceReportSortFields optionally
+// prefixed with -, into a mongo sort document. _id breaks ties so that pagination is stable
+func incidenceReportSort(sort string) bson.D {
+	direction := 1
+	if strings.HasPrefix(sort, "-") {
+		direction = -1
+		sort = strings.TrimPrefix(sort, "-")
+	}
+
+	field, ok := model.IncidenceReportSortFields[sort]
+	if !ok {
+		return bson.D{{"submittedOn", -1}, {"_id", -1}}
+	}
+	return bson.D{{field, direction}, {"_id", direction}}
+}
+
+// containsPattern matches values containing value, case-insensitively
+func containsPattern(value string) bson.D {
+	return bson.D{{"$regex", regexp.QuoteMeta(value)}, {"$options", "i"}}
+}
+
+// equalFoldPattern matches values equal to value, case-insensitively
+func equalFoldPattern(value string) bson.D {
+	return bson.D{{"$regex", "^" + regexp.QuoteMeta(value) + "$"}, {"$options", "i"}}
+}
+
+// stateCount counts the grouped incidence reports in any of states
+func stateCount(states ...model.IncidenceReportState) bson.D {
+	return bson.D{{"$sum", bson.D{{"$cond", bson.A{
+		bson.D{{"$in", bson.A{bson.D{{"$ifNull", bson.A{"$state", model.ReportNew}}}, states}}},
+		1, 0,
+	}}}}}
+}
+
+func (m *Mongo) FetchIncidenceReportsByUserID(uid string) (*[]model.IncidenceReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{"submittedOn", -1}})
+	curs, err := m.db.Collection(incidenceReports).Find(ctx, bson.D{{"uid", uid}}, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch user incidence reports")
+	}
+
+	reports := make([]model.IncidenceReport, 0)
+	if err := curs.All(ctx, &reports); err != nil {
+		return nil, errors.Wrap(err, "fetch user incidence reports: failed to decode find result into slice")
+	}
+	return &reports, nil
+}
+
+func (m *Mongo) InsertReporterFollowUp(followUp *model.IncidenceReportUpdate, uid string,
+	evidenceKeys, thumbnailKeys []string, fingerprints []model.ImageFingerprint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// reporters can only follow up on their own reports that are still open
+	filter := bson.D{
+		{"_id", followUp.IncidenceReportID},
+		{"uid", uid},
+		{"state", bson.D{{"$in", bson.A{model.ReportNew, model.ReportUnderInvestigation, nil}}}},
+	}
+	push := bson.D{{"updates", followUp}}
+	if len(evidenceKeys) > 0 {
+		push = append(push,
+			bson.E{"evidenceImagesUrl", bson.D{{"$each", evidenceKeys}}},
+			bson.E{"evidenceThumbnailsUrl", bson.D{{"$each", thumbnailKeys}}},
+			bson.E{"imageFingerprints", bson.D{{"$each", fingerprints}}})
+	}
+
+	result, err := m.db.Collection(incidenceReports).UpdateOne(ctx, filter, bson.D{{"$push", push}})
+	if err != nil {
+		return errors.Wrap(err, "failed to insert incidence report follow-up")
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+func (m *Mongo) CountIncidenceReportsSince(uid string, since time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := m.db.Collection(incidenceReports).
+		CountDocuments(ctx, bson.D{{"uid", uid}, {"submittedOn", bson.D{{"$gte", since}}}})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count user incidence reports")
+	}
+	return count, nil
+}
+
+// maxSimilarIncidenceReports caps the reports duplicate detection compares a new report against
+const maxSimilarIncidenceReports = 200
+
+func (m *Mongo) FetchSimilarIncidenceReports(report *model.IncidenceReport, since time.Time) (*[]model.IncidenceReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	similar := bson.A{bson.D{{"uid", report.UserID}}}
+	if report.PharmacyID != nil {
+		similar = append(similar, bson.D{{"pharmacyId", *report.PharmacyID}})
+	}
+	if report.DrugName != "" {
+		similar = append(similar, bson.D{{"drugName", equalFoldPattern(report.DrugName)}})
+	}
+	if len(report.ImageFingerprints) > 0 {
+		hashes := make(bson.A, 0, len(report.ImageFingerprints))
+		for _, fingerprint := range report.ImageFingerprints {
+			hashes = append(hashes, fingerprint.ContentHash)
+		}
+		similar = append(similar, bson.D{{"imageFingerprints.contentHash", bson.D{{"$in", hashes}}}})
+	}
+
+	filter := bson.D{{"submittedOn", bson.D{{"$gte", since}}}, {"$or", similar}}
+	opts := options.Find().
+		SetSort(bson.D{{"submittedOn", -1}}).
+		SetLimit(maxSimilarIncidenceReports).
+		SetProjection(bson.D{
+			{"uid", 1}, {"pharmacyId", 1}, {"drugName", 1}, {"imageFingerprints", 1}, {"submittedOn", 1},
+		})
+	curs, err := m.db.Collection(incidenceReports).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch similar incidence reports")
+	}
+
+	reports := make([]model.IncidenceReport, 0)
+	if err := curs.All(ctx, &reports); err != nil {
+		return nil, errors.Wrap(err, "fetch similar incidence reports: failed to decode find result into slice")
+	}
+	return &reports, nil
+}
+
+// FetchIncidenceReportDrugGroups aggregates the incidence reports matching filter per drug and batch,
+// most reported batches first. Reports that don't name a drug are left out
+func (m *Mongo) FetchIncidenceReportDrugGroups(filter *model.IncidenceReportFilter) (*[]model.DrugReportGroup, model.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	match := append(incidenceReportQuery(filter), bson.E{"drugName", bson.D{{"$nin", bson.A{nil, ""}}}})
+
+	// drug names and batch numbers are typed in by reporters unless the report was filed after a scan,
+	// so they are grouped case-insensitively
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$group", bson.D{
+			{"_id", bson.D{
+				{"drug", bson.D{{"$toLower", "$drugName"}}},
+				{"batch", bson.D{{"$toLower", bson.D{{"$ifNull", bson.A{"$batchNumber", ""}}}}}},
+			}},
+			{"drugName", bson.D{{"$first", "$drugName"}}},
+			{"batchNumber", bson.D{{"$first", bson.D{{"$ifNull", bson.A{"$batchNumber", ""}}}}}},
+			{"drugIds", bson.D{{"$addToSet", "$drugId"}}},
+			{"reportCount", bson.D{{"$sum", 1}}},
+			{"openCount", stateCount(model.ReportNew, model.ReportUnderInvestigation)},
+			{"confirmedCount", stateCount(model.ReportConfirmedCounterfeit)},
+			{"lastReportedOn", bson.D{{"$max", "$submittedOn"}}},
+		}}},
+		{{"$facet", bson.D{
+			{"total", bson.A{bson.D{{"$count", "count"}}}},
+			{"groups", bson.A{
+				bson.D{{"$sort", bson.D{{"reportCount", -1}, {"lastReportedOn", -1}, {"_id", 1}}}},
+				bson.D{{"$skip", filter.Skip()}},
+				bson.D{{"$limit", filter.Limit()}},
+			}},
+		}}},
+	}
+
+	curs, err := m.db.Collection(incidenceReports).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to aggregate incidence reports per drug")
+	}
+
+	var result []struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Groups []model.DrugReportGroup `bson:"groups"`
+	}
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "fetch incidence report drug groups: failed to decode aggregation result")
+	}
+
+	groups := make([]model.DrugReportGroup, 0)
+	var total int64
+	if len(result) > 0 {
+		groups = append(groups, result[0].Groups...)
+		if len(result[0].Total) > 0 {
+			total = result[0].Total[0].Count
+		}
+	}
+	return &groups, model.NewMetadata(total, filter.Pagination), nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (m *Mongo) createLeaderboardScoresCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"uid", "period"},
+		"properties": bson.M{
+			"uid": bson.M{
+				"bsonType": "string",
+			},
+			"period": bson.M{
+				"bsonType": "string",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, leaderboardScores, opts); err != nil {
+		logger.Logger.LogError("failed to create leaderboard scores collection",
+			"create leaderboard scores collection", err)
+	}
+
+	// a user has one score per period, each leaderboard is read off its own index
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"period", 1}, {"uid", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	for _, metric := range model.LeaderboardMetrics {
+		indexes = append(indexes, mongo.IndexModel{Keys: bson.D{{"period", 1}, {metric.Field(), -1}, {"uid", 1}}})
+	}
+	if _, err := m.db.Collection(leaderboardScores).Indexes().CreateMany(ctx, indexes); err != nil {
+		logger.Logger.LogError("failed to create leaderboard scores indexes",
+			"create leaderboard scores collection", err)
+	}
+}
+
+// IncrementLeaderboardScore adds amount to the metric of the user identified by uid,
+// on the all-time leaderboard and on the weekly leaderboard of the week of at
+func (m *Mongo) IncrementLeaderboardScore(uid string, metric model.LeaderboardMetric, amount int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.D{
+		{"$inc", bson.D{{metric.Field(), amount}}},
+		{"$set", bson.D{{"updatedOn", at}}},
+	}
+	var writes []mongo.WriteModel
+	for _, period := range []model.LeaderboardPeriod{model.LeaderboardAllTime, model.LeaderboardWeekly} {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"period", period.Key(at)}, {"uid", uid}}).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+	if _, err := m.db.Collection(leaderboardScores).BulkWrite(ctx, writes); err != nil {
+		return errors.Wrap(err, "failed to increment leaderboard score")
+	}
+	return nil
+}
+
+// FetchLeaderboard fetches the limit best scores of metric for the period identified by periodKey,
+// see model.LeaderboardPeriod.Key. Users who haven't scored are left out
+func (m *Mongo) FetchLeaderboard(periodKey string, metric model.LeaderboardMetric, limit int64) (*[]model.LeaderboardScore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	field := metric.Field()
+	filter := bson.D{{"period", periodKey}, {field, bson.D{{"$gt", 0}}}}
+	opts := options.Find().SetSort(bson.D{{field, -1}, {"uid", 1}}).SetLimit(limit)
+	curs, err := m.db.Collection(leaderboardScores).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch leaderboard")
+	}
+
+	result := make([]model.LeaderboardScore, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, errors.Wrap(err, "fetch leaderboard: failed to decode find result into slice")
+	}
+	return &result, nil
+}
+
+// FetchLeaderboardRank returns the rank and score of the user identified by uid on the leaderboard
+// of metric for the period identified by periodKey. Ranks follow the order of FetchLeaderboard.
+// Returns ErrLeaderboardScoreNotFound if the user hasn't scored
+func (m *Mongo) FetchLeaderboardRank(periodKey string, metric model.LeaderboardMetric, uid string) (rank, score int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var own model.LeaderboardScore
+	err = m.db.Collection(leaderboardScores).
+		FindOne(ctx, bson.D{{"period", periodKey}, {"uid", uid}}).Decode(&own)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, 0, ErrLeaderboardScoreNotFound
+		}
+		return 0, 0, errors.Wrap(err, "failed to fetch leaderboard score")
+	}
+	score = own.Score(metric)
+	if score <= 0 {
+		return 0, 0, ErrLeaderboardScoreNotFound
+	}
+
+	// the users ranked ahead have a higher score, or the same score and a lower uid
+	field := metric.Field()
+	ahead := bson.D{
+		{"period", periodKey},
+		{"$or", bson.A{
+			bson.D{{field, bson.D{{"$gt", score}}}},
+			bson.D{{field, score}, {"uid", bson.D{{"$lt", uid}}}},
+		}},
+	}
+	count, err := m.db.Collection(leaderboardScores).CountDocuments(ctx, ahead)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to rank leaderboard score")
+	}
+	return int(count) + 1, score, nil
+}
@@ -4,12 +4,13 @@ import (
 	"context"
 	"github.com/Hrtnet/social-activities/internal/logger"
 	"github.com/Hrtnet/social-activities/internal/model"
-	"github.com/jakoubek/onetimecode"
+	"github.com/Hrtnet/social-activities/internal/uid"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
 	"time"
 )
 
@@ -25,8 +26,90 @@ var (
 	ErrUserNotFound      = errors.New("user not found")
 	ErrDrugNotFound      = errors.New("drug data not found")
 	ErrNoSubmissionFound = errors.New("no airdrop submission found")
+
+	ErrPartnerNotFound         = errors.New("partner not found")
+	ErrIncidenceReportNotFound = errors.New("incidence report not found")
+	ErrPharmacyNotFound        = errors.New("pharmacy not found")
+	ErrScanNotFound            = errors.New("scan not found")
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrCampaignNotFound        = errors.New("campaign not found")
+	ErrAccountDeletionNotFound = errors.New("account deletion not found")
+
+	// ErrDuplicateAirdropSubmission is returned when a user already made a submission to a campaign
+	ErrDuplicateAirdropSubmission = errors.New("airdrop participation already recorded for this campaign")
+
+	// ErrAirdropIdentifierInUse is returned when an identifier of an airdrop submission, e.g., its Twitter username,
+	// was already used by another participant of the campaign
+	ErrAirdropIdentifierInUse = errors.New("an identifier of this submission is already used by another participant of this campaign")
+
+	// ErrSubmissionAlreadyReviewed is returned when reviewing an airdrop submission that was already approved or rejected
+	ErrSubmissionAlreadyReviewed = errors.New("airdrop submission already reviewed")
+
+	// ErrRewardPoolExhausted is returned when a campaign's reward pool can't pay for another reward
+	ErrRewardPoolExhausted = errors.New("campaign reward pool exhausted")
+
+	// ErrWalletInUse is returned when linking a wallet that is already linked to another user
+	ErrWalletInUse = errors.New("wallet already linked to another account")
+
+	// ErrWalletAlreadyLinked is returned when linking a wallet to a user who has another wallet linked
+	ErrWalletAlreadyLinked = errors.New("another wallet is linked to this account, unlink it first")
+
+	// ErrWalletChallengeNotFound is returned when a wallet challenge is unknown, expired or already answered
+	ErrWalletChallengeNotFound = errors.New("wallet challenge not found or expired, request a new one")
+
+	// ErrReferralCodeNotFound is returned when no user owns a referral code
+	ErrReferralCodeNotFound = errors.New("unknown referral code")
+
+	// ErrAlreadyReferred is returned when recording a referral for a user who was already referred
+	ErrAlreadyReferred = errors.New("user already referred")
+
+	// ErrReferralNotFound is returned when a user has no pending referral
+	ErrReferralNotFound = errors.New("referral not found")
+
+	// ErrReferralRewardCapReached is returned when a user was rewarded for as many referrals as allowed
+	ErrReferralRewardCapReached = errors.New("referral reward cap reached")
+
+	// ErrUIDSpaceExhausted is returned when every uid generated for a new user was already assigned
+	ErrUIDSpaceExhausted = errors.New("failed to generate a unique user id, increase the uid length")
+
+	// ErrUIDIndexMissing is returned when the users collection has no unique index on uid,
+	// without which GenerateNewUserID can't rely on the database to detect collisions
+	ErrUIDIndexMissing = errors.New("users collection has no unique index on uid")
+
+	// ErrLeaderboardScoreNotFound is returned when ranking a user who hasn't scored on a leaderboard
+	ErrLeaderboardScoreNotFound = errors.New("user has no leaderboard score")
+
+	// ErrEmailInUse is returned when verifying an email that another user already verified
+	ErrEmailInUse = errors.New("email already linked to another account")
+
+	// ErrOneTimeCodeNotFound is returned when a one-time code is unknown, expired, used up or out of attempts
+	ErrOneTimeCodeNotFound = errors.New("code not found or expired, request a new one")
+
+	// ErrOneTimeCodeMismatch is returned when a one-time code is answered wrongly
+	ErrOneTimeCodeMismatch = errors.New("incorrect code")
+
+	// ErrOneTimeCodeTooSoon is returned when requesting a one-time code too soon after the previous one
+	ErrOneTimeCodeTooSoon = errors.New("a code was sent recently, please wait before requesting another one")
+
+	// ErrSessionNotFound is returned when a session token is unknown or expired
+	ErrSessionNotFound = errors.New("session not found or expired")
+
+	// ErrSessionAlreadyClaimed is returned when claiming the first session of a user a second time
+	ErrSessionAlreadyClaimed = errors.New("session already issued for this account, recover it by email instead")
+
+	// ErrDuplicatePharmacy is returned when a pharmacy's licence number is already registered
+	ErrDuplicatePharmacy = errors.New("a pharmacy with this licence number already exists")
+
+	// ErrEditConflict is returned when a document was modified by a concurrent
+	// request between the time it was read and the time it was updated
+	ErrEditConflict = errors.New("edit conflict")
 )
 
+// uidAttempts bounds the uids GenerateNewUserID tries before giving up,
+// repeated collisions mean the uid length should be increased
+const uidAttempts = 5
+
 // collection names
 const (
 	drugs              = "drugs"
@@ -37,6 +120,21 @@ const (
 	contactUs          = "contactUs"
 	announcements      = "announcements"
 	rewards            = "rewards"
+	partners           = "partners"
+	pharmacies         = "pharmacies"
+	scans              = "scans"
+	webhooks           = "webhooks"
+	webhookDeliveries  = "webhookDeliveries"
+	recalls            = "recalls"
+	walletChallenges   = "walletChallenges"
+	campaigns          = "campaigns"
+	referrals          = "referrals"
+	leaderboardScores  = "leaderboardScores"
+	oneTimeCodes       = "oneTimeCodes"
+	sessions           = "sessions"
+	accountDeletions   = "accountDeletions"
+	userChanges        = "userChanges"
+	duplicateUsers     = "duplicateUsers"
 )
 
 type Mongo struct {
@@ -45,11 +143,18 @@ type Mongo struct {
 	db *mongo.Database
 
 	client *mongo.Client
+
+	// uids generates the ids of new users
+	uids *uid.Generator
+
+	// uidIndexed is false while the users collection has no unique index on uid, see ResolveDuplicateUIDs
+	uidIndexed bool
 }
 
 // ConnectMongo connects to a single instance of Mongo server, with
-// and embeds the database cursor in Mongo
-func ConnectMongo(dsn string) (*Mongo, error) {
+// and embeds the database cursor in Mongo.
+// New users are assigned ids generated by uids
+func ConnectMongo(dsn string, uids *uid.Generator) (*Mongo, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
 	defer cancel()
 
@@ -72,14 +177,56 @@ func ConnectMongo(dsn string) (*Mongo, error) {
 	database := client.Database("heartNet", &options.DatabaseOptions{})
 
 	mongo := &Mongo{
-		database,
-		client,
+		db:     database,
+		client: client,
+		uids:   uids,
 	}
 
 	mongo.runMigrations(ctx)
+	if err := mongo.checkUIDIndex(ctx); err != nil {
+		logger.Logger.LogError("new uids are checked before they are assigned until duplicate uids are resolved, "+
+			"run the server with -dedupeUIDs=report", "connect mongo", err)
+	}
 	return mongo, nil
 }
 
+// checkUIDIndex returns ErrUIDIndexMissing if the users collection has no unique index on uid,
+// e.g., because runMigrations failed to create it over duplicate uids
+func (m *Mongo) checkUIDIndex(ctx context.Context) error {
+	specs, err := m.db.Collection(users).Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list users indexes")
+	}
+	m.uidIndexed = hasUniqueIndex(specs, "uid")
+	if !m.uidIndexed {
+		return ErrUIDIndexMissing
+	}
+	return nil
+}
+
+// createUIDIndex creates the unique index on the uid of users
+func (m *Mongo) createUIDIndex(ctx context.Context) error {
+	_, err := m.db.Collection(users).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"uid", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// hasUniqueIndex reports if specs hold a unique index on key alone
+func hasUniqueIndex(specs []*mongo.IndexSpecification, key string) bool {
+	for _, spec := range specs {
+		if spec.Unique == nil || !*spec.Unique {
+			continue
+		}
+		elems, err := spec.KeysDocument.Elements()
+		if err == nil && len(elems) == 1 && elems[0].Key() == key {
+			return true
+		}
+	}
+	return false
+}
+
 // runMigrations creates necessary collections
 func (m *Mongo) runMigrations(ctx context.Context) {
 	m.createContactUsCollection()
@@ -90,6 +237,20 @@ func (m *Mongo) runMigrations(ctx context.Context) {
 	m.createNotificationsCollection()
 	m.createAnnouncementsCollection()
 	m.createRewardsCollection()
+	m.createPartnersCollection()
+	m.createPharmaciesCollection()
+	m.createScansCollection()
+	m.createWebhooksCollection()
+	m.createWebhookDeliveriesCollection()
+	m.createRecallsCollection()
+	m.createWalletChallengesCollection()
+	m.createCampaignsCollection()
+	m.createReferralsCollection()
+	m.createLeaderboardScoresCollection()
+	m.createOneTimeCodesCollection()
+	m.createSessionsCollection()
+	m.createAccountDeletionsCollection()
+	m.createUserChangesCollection()
 }
 
 func (m *Mongo) createAnnouncementsCollection() {
@@ -125,6 +286,14 @@ func (m *Mongo) createRewardsCollection() {
 		logger.Logger.LogError("failed to create rewards collection",
 			"create rewards collection", err)
 	}
+
+	_, err := m.db.Collection(rewards).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"uid", 1}, {"recordedOn", -1}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create rewards index",
+			"create rewards collection", err)
+	}
 }
 
 func (m *Mongo) createContactUsCollection() {
@@ -219,6 +388,9 @@ func (m *Mongo) createIncidenceReportCollection() {
 			"receiptImageUrl": bson.M{
 				"bsonType": "string",
 			},
+			"state": bson.M{
+				"enum": model.IncidenceReportStates,
+			},
 		},
 	}
 
@@ -233,6 +405,28 @@ func (m *Mongo) createIncidenceReportCollection() {
 		logger.Logger.LogError("failed to create incidence report collection",
 			"create incidence reports", err)
 	}
+
+	// image keys are indexed to authorize access to private images.
+	// The text index on description backs full-text search of reports,
+	// pharmacyId and coordinates back the pharmacy hotspot aggregation, uid and
+	// imageFingerprints back duplicate detection and submission limits
+	_, err := m.db.Collection(incidenceReports).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"receiptImageUrl", 1}}},
+		{Keys: bson.D{{"receiptThumbnailUrl", 1}}},
+		{Keys: bson.D{{"evidenceImagesUrl", 1}}},
+		{Keys: bson.D{{"evidenceThumbnailsUrl", 1}}},
+		{Keys: bson.D{{"state", 1}, {"submittedOn", -1}}},
+		{Keys: bson.D{{"description", "text"}}},
+		{Keys: bson.D{{"pharmacyId", 1}, {"submittedOn", -1}}},
+		{Keys: bson.D{{"coordinates", "2dsphere"}}},
+		{Keys: bson.D{{"uid", 1}, {"submittedOn", -1}}},
+		{Keys: bson.D{{"imageFingerprints.contentHash", 1}}},
+		{Keys: bson.D{{"drugId", 1}}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create incidence report indexes",
+			"create incidence reports", err)
+	}
 }
 
 func (m *Mongo) createAirdropSubmissionCollection() {
@@ -273,6 +467,36 @@ func (m *Mongo) createAirdropSubmissionCollection() {
 		logger.Logger.LogError("failed to create airdrop submission collection",
 			"create airdrop submission collection", err)
 	}
+
+	// a user makes at most one submission per campaign, and the identifiers of a submission
+	// can't be reused by other users within a campaign. Submissions made before
+	// campaigns were introduced have no campaign and are left out.
+	// The identifier indexes also serve the sybil detection lookups, see FetchRelatedAirdropSubmissions
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{"uid", 1}, {"campaignId", 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{"campaignId", bson.D{{"$exists", true}}}}),
+		},
+		{Keys: bson.D{{"uid", 1}, {"submittedOn", -1}}},
+		{Keys: bson.D{{"status", 1}, {"submittedOn", 1}}},
+		{Keys: bson.D{{"ipAddress", 1}}},
+		{Keys: bson.D{{"deviceId", 1}}},
+	}
+	for _, identifier := range submissionIdentifiers {
+		indexes = append(indexes, mongo.IndexModel{
+			Keys: bson.D{{identifier, 1}, {"campaignId", 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{
+				{identifier, bson.D{{"$type", "string"}}},
+				{"campaignId", bson.D{{"$exists", true}}},
+			}),
+		})
+	}
+	_, err := m.db.Collection(airdropSubmissions).Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		logger.Logger.LogError("failed to create airdrop submission indexes",
+			"create airdrop submission collection", err)
+	}
 }
 
 func (m *Mongo) createUsersCollection() {
@@ -304,6 +528,36 @@ func (m *Mongo) createUsersCollection() {
 		logger.Logger.LogError("failed to create users collection",
 			"create users collection", err)
 	}
+
+	// uids are unique, which GenerateNewUserID relies on to detect collisions.
+	// The index can't be created while users share a uid, see ResolveDuplicateUIDs
+	if err := m.createUIDIndex(ctx); err != nil {
+		logger.Logger.LogError("failed to create users uid index",
+			"create users collection", err)
+	}
+
+	// a wallet, a verified email and a referral code can only belong to one user
+	_, err := m.db.Collection(users).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{"walletAddr", 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{"walletAddr", bson.D{{"$type", "string"}, {"$gt", ""}}}}),
+		},
+		{
+			Keys: bson.D{{"email", 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{"emailVerifiedOn", bson.D{{"$exists", true}}}}),
+		},
+		{
+			Keys: bson.D{{"referralCode", 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{"referralCode", bson.D{{"$type", "string"}}}}),
+		},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create users index",
+			"create users collection", err)
+	}
 }
 
 func (m *Mongo) createDrugsCollection() {
@@ -409,7 +663,7 @@ func (m *Mongo) ValidateQrText(value string) (*model.Drug, error) {
 		}
 		return nil, errors.Wrap(err, "validate qr text: failed to query drug")
 	}
-	return &drug.Drug, nil
+	return drug.WithID(), nil
 }
 
 func (m *Mongo) ValidateShortCode(value string) (*model.Drug, error) {
@@ -425,7 +679,7 @@ func (m *Mongo) ValidateShortCode(value string) (*model.Drug, error) {
 		}
 		return nil, errors.Wrap(err, "validate short code: failed to query drug")
 	}
-	return &drug.Drug, nil
+	return drug.WithID(), nil
 }
 
 func (m *Mongo) ValidateRFIDText(value string) (*model.Drug, error) {
@@ -441,26 +695,7 @@ func (m *Mongo) ValidateRFIDText(value string) (*model.Drug, error) {
 		}
 		return nil, errors.Wrap(err, "validate rfid: failed to query drug")
 	}
-	return &drug.Drug, nil
-}
-
-func (m *Mongo) FetchAllAirdropSubmissions() (*[]model.AirdropSubmission, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
-	defer cancel()
-
-	curs, err := m.db.Collection(airdropSubmissions).Find(ctx, bson.D{})
-	if err != nil {
-		return nil, err
-	}
-
-	var submissions []model.AirdropSubmission
-
-	if err := curs.All(ctx, &submissions); err != nil {
-		return nil, errors.Wrap(err, "fetch all airdrop submissions: failed to decode find result into slice")
-	}
-
-	return &submissions, err
-
+	return drug.WithID(), nil
 }
 
 func (m *Mongo) InsertMultipleDrugs(values *[]model.DBDrug, option model.ValidationOption) error {
@@ -498,26 +733,6 @@ func (m *Mongo) InsertContactUs(message *model.ContactUs) error {
 	return nil
 }
 
-func (m *Mongo) UpdateUser(user *model.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	opts := options.Update().SetUpsert(false)
-	filter := bson.D{{"uid", user.UID}}
-
-	d := new(bson.D)
-	for key, value := range user.ToMap() {
-		*d = append(*d, bson.E{key, value})
-	}
-
-	update := bson.D{{"$set", d}}
-	_, err := m.db.Collection(users).UpdateOne(ctx, filter, update, opts)
-	if err != nil {
-		return errors.Wrap(err, "failed to update user data")
-	}
-	return nil
-}
-
 func (m *Mongo) FetchUser(uid string) (*model.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -560,12 +775,20 @@ func (m *Mongo) FetchRandomQRCode() (string, error) {
 	return dbDrugs[0].ValidationData, nil
 }
 
-func (m *Mongo) FetchAirdropSubmissionByUserID(userId string) (*model.AirdropSubmission, error) {
+// FetchAirdropSubmission fetches the submission made by the user identified by userId to the campaign
+// identified by campaignId. If campaignId is the nil id, the user's latest submission is fetched
+func (m *Mongo) FetchAirdropSubmission(userId string, campaignId primitive.ObjectID) (*model.AirdropSubmission, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
 	defer cancel()
 
+	filter := bson.D{{"uid", userId}}
+	if !campaignId.IsZero() {
+		filter = append(filter, bson.E{"campaignId", campaignId})
+	}
+	opts := options.FindOne().SetSort(bson.D{{"submittedOn", -1}})
+
 	var submission model.AirdropSubmission
-	err := m.db.Collection(airdropSubmissions).FindOne(ctx, bson.D{{"uid", userId}}).Decode(&submission)
+	err := m.db.Collection(airdropSubmissions).FindOne(ctx, filter, opts).Decode(&submission)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, ErrNoSubmissionFound
@@ -576,54 +799,97 @@ func (m *Mongo) FetchAirdropSubmissionByUserID(userId string) (*model.AirdropSub
 	return &submission, nil
 }
 
+// GenerateNewUserID inserts a new user and returns their uid.
+// Collisions are detected by the unique index on uid, in which case another uid is
+// generated, up to uidAttempts times. Until duplicate uids are resolved and the index exists,
+// uids are looked up before they are inserted instead, which concurrent sign ups can race
 func (m *Mongo) GenerateNewUserID() (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	userId := onetimecode.NewAlphanumericalCode(
-		onetimecode.WithAlphaNumericCode(),
-		onetimecode.WithMax(6),
-		onetimecode.WithoutDashes(),
-	).Code()
-
-	var user model.User
-	if err := m.db.Collection(users).
-		FindOne(ctx, bson.D{{"uid", userId}}).Decode(&user); err != nil {
-		if err == mongo.ErrNoDocuments {
-			// that implies the uid is unassigned and can then be assigned
-		} else {
-			return "", errors.Wrap(err, "fetch user id: failed to decode user")
+	return insertNewUID(m.uids.Generate, func(userId string) error {
+		if !m.uidIndexed {
+			count, err := m.db.Collection(users).CountDocuments(ctx, bson.D{{"uid", userId}})
+			if err != nil {
+				return errors.Wrap(err, "failed to look up new uid")
+			}
+			if count > 0 {
+				return errUIDAssigned
+			}
 		}
-	}
+		_, err := m.db.Collection(users).InsertOne(ctx, bson.D{{"uid", userId}})
+		return err
+	})
+}
 
-	// if user is found, regenerate a new user id
-	if len(user.UID) != 0 {
-		logger.Logger.LogWarn("UID collision",
-			"generate new user id", errors.New("already existing user id returned for new user"))
-		m.GenerateNewUserID()
-	}
+// errUIDAssigned is returned by the insert function of insertNewUID when a uid it looked up is already assigned
+var errUIDAssigned = errors.New("uid already assigned")
 
-	// insert new user
-	if _, err := m.db.Collection(users).InsertOne(ctx, bson.D{{"uid", userId}}); err != nil {
-		return "", errors.Wrap(err, "failed to insert new UID to db")
-	}
+// insertNewUID inserts uids made by generate until insert doesn't fail on a duplicate key,
+// or with errUIDAssigned, up to uidAttempts times, and returns the uid inserted
+func insertNewUID(generate func() (string, error), insert func(userId string) error) (string, error) {
+	for i := 0; i < uidAttempts; i++ {
+		userId, err := generate()
+		if err != nil {
+			return "", err
+		}
 
-	return userId, nil
+		err = insert(userId)
+		if err == nil {
+			return userId, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) && err != errUIDAssigned {
+			return "", errors.Wrap(err, "failed to insert new UID to db")
+		}
+		logger.Logger.LogWarn("UID collision", "generate new user id",
+			errors.Errorf("uid %s already assigned, attempt %d of %d", userId, i+1, uidAttempts))
+	}
+	return "", ErrUIDSpaceExhausted
 }
 
 func (m *Mongo) InsertAirdropSubmission(submission *model.AirdropSubmission) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err := m.db.Collection(airdropSubmissions).InsertOne(ctx, submission)
+	result, err := m.db.Collection(airdropSubmissions).InsertOne(ctx, submission)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// the uid index is named after its keys, the others guard identifiers
+			if strings.Contains(err.Error(), "uid_1_campaignId_1") {
+				return ErrDuplicateAirdropSubmission
+			}
+			return ErrAirdropIdentifierInUse
+		}
 		return errors.Wrap(err, "failed to insert airdrop submission into db")
 	}
+	submission.ID = result.InsertedID.(primitive.ObjectID)
 	return nil
 }
 
+// FetchAirdropSubmissionsByUserID fetches every submission made by the user identified by userId,
+// most recent first
+func (m *Mongo) FetchAirdropSubmissionsByUserID(userId string) (*[]model.AirdropSubmission, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{"submittedOn", -1}})
+	curs, err := m.db.Collection(airdropSubmissions).Find(ctx, bson.D{{"uid", userId}}, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch user airdrop submissions")
+	}
+
+	result := make([]model.AirdropSubmission, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, errors.Wrap(err, "fetch user airdrop submissions: failed to decode find result into slice")
+	}
+	return &result, nil
+}
+
 func (m *Mongo) Disconnect() error {
-	return m.db.Client().Disconnect(context.TODO())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return m.db.Client().Disconnect(ctx)
 }
 
 func (m *Mongo) SaveNotification(notification *model.Notification) error {
@@ -703,10 +969,25 @@ func (m *Mongo) SubmitIncidenceReport(report *model.IncidenceReport) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err := m.db.Collection(incidenceReports).InsertOne(ctx, report)
+	result, err := m.db.Collection(incidenceReports).InsertOne(ctx, report)
 	if err != nil {
 		return errors.Wrap(err, "failed to insert incidence report into db")
 	}
+	report.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// LinkIncidenceReportPharmacy links the incidence report identified by id, if it isn't linked yet,
+// to the pharmacy identified by pharmacyId
+func (m *Mongo) LinkIncidenceReportPharmacy(id, pharmacyId primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"_id", id}, {"pharmacyId", bson.D{{"$exists", false}}}}
+	update := bson.D{{"$set", bson.D{{"pharmacyId", pharmacyId}}}}
+	if _, err := m.db.Collection(incidenceReports).UpdateOne(ctx, filter, update); err != nil {
+		return errors.Wrap(err, "failed to link incidence report to pharmacy")
+	}
 	return nil
 }
 
@@ -759,32 +1040,59 @@ func (m *Mongo) FetchAnnouncements() (*[]model.Announcement, error) {
 
 // InsertIncidenceReportUpdate should only be called by
 // partners with HeartNet
-func (m *Mongo) InsertIncidenceReportUpdate(reportUpdate *model.IncidenceReportUpdate) error {
+func (m *Mongo) InsertIncidenceReportUpdate(reportUpdate *model.IncidenceReportUpdate,
+	partnerId primitive.ObjectID, transition *model.IncidenceReportTransition) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	opts := options.Update().SetUpsert(false)
-	filter := bson.D{{"_id", reportUpdate.IncidenceReportID}}
+	filter := bson.D{{"_id", reportUpdate.IncidenceReportID}, {"assignedPartners", partnerId}}
 	update := bson.D{{"$push", bson.D{{"updates", reportUpdate}}}}
-	_, err := m.db.Collection(incidenceReports).UpdateOne(ctx, filter, update, opts)
+
+	if transition != nil {
+
+		// the report must still be in the state the transition was validated against,
+		// else a concurrent update has moved it in the meantime
+		if transition.From == model.ReportNew {
+			filter = append(filter, bson.E{"$or", bson.A{
+				bson.D{{"state", model.ReportNew}},
+				bson.D{{"state", bson.D{{"$exists", false}}}},
+			}})
+		} else {
+			filter = append(filter, bson.E{"state", transition.From})
+		}
+		update = bson.D{
+			{"$push", bson.D{{"updates", reportUpdate}, {"transitions", transition}}},
+			{"$set", bson.D{{"state", transition.To}}},
+		}
+	}
+
+	result, err := m.db.Collection(incidenceReports).UpdateOne(ctx, filter, update, opts)
 	if err != nil {
 		return errors.Wrap(err, "failed to insert incidence report update")
 	}
+	if result.MatchedCount == 0 {
+		if transition != nil {
+			return ErrEditConflict
+		}
+		return ErrIncidenceReportNotFound
+	}
 	return nil
 }
 
-func (m *Mongo) RecordReward(reward model.Reward) error {
+func (m *Mongo) RecordReward(reward *model.Reward) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err := m.db.Collection(rewards).InsertOne(ctx, reward)
+	result, err := m.db.Collection(rewards).InsertOne(ctx, reward)
 	if err != nil {
 		return errors.Wrap(err, "failed to record reward")
 	}
+	reward.ID = result.InsertedID.(primitive.ObjectID)
 	return nil
 }
 
-func (m *Mongo) FetchIncidenceReporterUID(id primitive.ObjectID) (string, error) {
+func (m *Mongo) FetchIncidenceReport(id primitive.ObjectID) (*model.IncidenceReport, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -792,10 +1100,10 @@ func (m *Mongo) FetchIncidenceReporterUID(id primitive.ObjectID) (string, error)
 	err := m.db.Collection(incidenceReports).FindOne(ctx, bson.D{{"_id", id}}).Decode(&report)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return "", err
+			return nil, ErrIncidenceReportNotFound
 		}
-		return "", errors.Wrap(err, "failed to fetch incidence reporter uid")
+		return nil, errors.Wrap(err, "failed to fetch incidence report")
 	}
 
-	return report.UserID, nil
+	return &report, nil
 }
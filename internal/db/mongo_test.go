@@ -0,0 +1,119 @@
+package db
+
+import (
+	"errors"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = logger.NewLogger(false)
+	os.Exit(m.Run())
+}
+
+var errDuplicateUID = mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "duplicate key"}}}
+
+func TestInsertNewUID(t *testing.T) {
+
+	// collisions are reported by the unique index on uid, or by the lookup made while it is missing
+	for _, collision := range []error{errDuplicateUID, errUIDAssigned} {
+		assigned := map[string]bool{"A": true, "B": true}
+		candidates := []string{"A", "B", "C", "D"}
+		var generated, inserted []string
+		generate := func() (string, error) {
+			id := candidates[len(generated)]
+			generated = append(generated, id)
+			return id, nil
+		}
+		insert := func(userId string) error {
+			if assigned[userId] {
+				return collision
+			}
+			inserted = append(inserted, userId)
+			return nil
+		}
+
+		userId, err := insertNewUID(generate, insert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userId != "C" {
+			t.Errorf("insertNewUID() on %v = %s, want C", collision, userId)
+		}
+		if len(generated) != 3 || len(inserted) != 1 {
+			t.Errorf("insertNewUID() on %v generated %v and inserted %v, want a retry per collision",
+				collision, generated, inserted)
+		}
+	}
+}
+
+func TestInsertNewUIDExhausted(t *testing.T) {
+	attempts := 0
+	_, err := insertNewUID(func() (string, error) {
+		attempts++
+		return "A", nil
+	}, func(string) error {
+		return errDuplicateUID
+	})
+	if err != ErrUIDSpaceExhausted {
+		t.Errorf("insertNewUID() error = %v, want %v", err, ErrUIDSpaceExhausted)
+	}
+	if attempts != uidAttempts {
+		t.Errorf("insertNewUID() made %d attempts, want %d", attempts, uidAttempts)
+	}
+}
+
+func TestInsertNewUIDFailure(t *testing.T) {
+	failure := errors.New("connection reset")
+	attempts := 0
+	_, err := insertNewUID(func() (string, error) {
+		attempts++
+		return "A", nil
+	}, func(string) error {
+		return failure
+	})
+	if err == nil || err == ErrUIDSpaceExhausted {
+		t.Errorf("insertNewUID() error = %v, want the insert failure", err)
+	}
+	if attempts != 1 {
+		t.Errorf("insertNewUID() made %d attempts, want no retry", attempts)
+	}
+}
+
+func TestHasUniqueIndex(t *testing.T) {
+	unique, notUnique := true, false
+	keys := func(doc bson.D) bson.Raw {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	idIndex := &mongo.IndexSpecification{Name: "_id_", KeysDocument: keys(bson.D{{"_id", 1}})}
+
+	tests := []struct {
+		name  string
+		specs []*mongo.IndexSpecification
+		want  bool
+	}{
+		{"no index", []*mongo.IndexSpecification{idIndex}, false},
+		{"unique", []*mongo.IndexSpecification{idIndex,
+			{Name: "uid_1", KeysDocument: keys(bson.D{{"uid", 1}}), Unique: &unique}}, true},
+		{"not unique", []*mongo.IndexSpecification{idIndex,
+			{Name: "uid_1", KeysDocument: keys(bson.D{{"uid", 1}}), Unique: &notUnique}}, false},
+		{"unique option unset", []*mongo.IndexSpecification{idIndex,
+			{Name: "uid_1", KeysDocument: keys(bson.D{{"uid", 1}})}}, false},
+		{"compound", []*mongo.IndexSpecification{idIndex,
+			{Name: "uid_1_version_-1", KeysDocument: keys(bson.D{{"uid", 1}, {"version", -1}}), Unique: &unique}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasUniqueIndex(tt.specs, "uid"); got != tt.want {
+				t.Errorf("hasUniqueIndex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
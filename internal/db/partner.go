@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (m *Mongo) createPartnersCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"name", "email", "apiKeyHash"},
+		"properties": bson.M{
+			"name": bson.M{
+				"bsonType": "string",
+			},
+			"email": bson.M{
+				"bsonType": "string",
+			},
+			"apiKeyHash": bson.M{
+				"bsonType": "string",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, partners, opts); err != nil {
+		logger.Logger.LogError("failed to create partners collection",
+			"create partners collection", err)
+	}
+
+	_, err := m.db.Collection(partners).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"apiKeyHash", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create partners api key index",
+			"create partners collection", err)
+	}
+}
+
+func (m *Mongo) InsertPartner(partner *model.Partner) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(partners).InsertOne(ctx, partner)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert partner into db")
+	}
+	partner.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (m *Mongo) FetchPartnerByAPIKey(apiKey string) (*model.Partner, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var partner model.Partner
+	err := m.db.Collection(partners).
+		FindOne(ctx, bson.D{{"apiKeyHash", model.HashAPIKey(apiKey)}}).Decode(&partner)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPartnerNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch partner")
+	}
+	return &partner, nil
+}
+
+func (m *Mongo) AssignIncidenceReportPartners(reportId primitive.ObjectID, partnerIds []primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := m.db.Collection(partners).CountDocuments(ctx, bson.D{{"_id", bson.D{{"$in", partnerIds}}}})
+	if err != nil {
+		return errors.Wrap(err, "failed to count assigned partners")
+	}
+	if int(count) != len(partnerIds) {
+		return ErrPartnerNotFound
+	}
+
+	filter := bson.D{{"_id", reportId}}
+	update := bson.D{{"$addToSet", bson.D{{"assignedPartners", bson.D{{"$each", partnerIds}}}}}}
+	result, err := m.db.Collection(incidenceReports).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.Wrap(err, "failed to assign partners to incidence report")
+	}
+	if result.MatchedCount == 0 {
+		return ErrIncidenceReportNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) FetchIncidenceReportByImageKey(key string) (*model.IncidenceReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"$or", bson.A{
+		bson.D{{"receiptImageUrl", key}},
+		bson.D{{"receiptThumbnailUrl", key}},
+		bson.D{{"evidenceImagesUrl", key}},
+		bson.D{{"evidenceThumbnailsUrl", key}},
+	}}}
+
+	var report model.IncidenceReport
+	err := m.db.Collection(incidenceReports).FindOne(ctx, filter).Decode(&report)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrIncidenceReportNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch incidence report by image key")
+	}
+	return &report, nil
+}
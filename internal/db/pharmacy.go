@@ -0,0 +1,270 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// earthRadiusKm converts distances into the radians $centerSphere expects
+const earthRadiusKm = 6378.1
+
+func (m *Mongo) createPharmaciesCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"name", "address", "status", "searchKey"},
+		"properties": bson.M{
+			"name": bson.M{
+				"bsonType": "string",
+			},
+			"address": bson.M{
+				"bsonType": "string",
+			},
+			"licenceNumber": bson.M{
+				"bsonType": "string",
+			},
+			"status": bson.M{
+				"enum": []model.PharmacyStatus{model.PharmacyRegistered, model.PharmacySuggested},
+			},
+			"searchKey": bson.M{
+				"bsonType": "string",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, pharmacies, opts); err != nil {
+		logger.Logger.LogError("failed to create pharmacies collection",
+			"create pharmacies collection", err)
+	}
+
+	// licence numbers are only unique among pharmacies that have one, suggested pharmacies usually don't
+	_, err := m.db.Collection(pharmacies).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{"licenceNumber", 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{"licenceNumber", bson.D{{"$type", "string"}}}}),
+		},
+		{Keys: bson.D{{"searchKey", 1}}},
+		{Keys: bson.D{{"location", "2dsphere"}}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create pharmacies indexes",
+			"create pharmacies collection", err)
+	}
+}
+
+func (m *Mongo) InsertPharmacy(pharmacy *model.Pharmacy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(pharmacies).InsertOne(ctx, pharmacy)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicatePharmacy
+		}
+		return errors.Wrap(err, "failed to insert pharmacy into db")
+	}
+	pharmacy.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (m *Mongo) FetchPharmacy(id primitive.ObjectID) (*model.Pharmacy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var pharmacy model.Pharmacy
+	err := m.db.Collection(pharmacies).FindOne(ctx, bson.D{{"_id", id}}).Decode(&pharmacy)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPharmacyNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch pharmacy")
+	}
+	return &pharmacy, nil
+}
+
+// FetchPharmacyBySearchKey fetches the pharmacy with searchKey, see model.PharmacySearchKey,
+// registered pharmacies first. Returns ErrPharmacyNotFound if there is none
+func (m *Mongo) FetchPharmacyBySearchKey(searchKey string) (*model.Pharmacy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// "registered" sorts before "suggested"
+	opts := options.FindOne().SetSort(bson.D{{"status", 1}, {"_id", 1}})
+	var pharmacy model.Pharmacy
+	err := m.db.Collection(pharmacies).FindOne(ctx, bson.D{{"searchKey", searchKey}}, opts).Decode(&pharmacy)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPharmacyNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch pharmacy")
+	}
+	return &pharmacy, nil
+}
+
+// SuggestPharmacy links a pharmacy named by a reporter to the registry.
+// A pharmacy with the same search key is reused, registered pharmacies first,
+// otherwise pharmacy is inserted as a new suggested pharmacy.
+// pharmacy is updated in place with the stored pharmacy
+func (m *Mongo) SuggestPharmacy(pharmacy *model.Pharmacy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pharmacy.Status = model.PharmacySuggested
+	pharmacy.SearchKey = model.PharmacySearchKey(pharmacy.Name, pharmacy.Address)
+
+	// "registered" sorts before "suggested"
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetSort(bson.D{{"status", 1}, {"_id", 1}}).
+		SetReturnDocument(options.After)
+	update := bson.D{{"$setOnInsert", pharmacy}}
+	err := m.db.Collection(pharmacies).
+		FindOneAndUpdate(ctx, bson.D{{"searchKey", pharmacy.SearchKey}}, update, opts).Decode(pharmacy)
+	if err != nil {
+		return errors.Wrap(err, "failed to suggest pharmacy")
+	}
+	return nil
+}
+
+// RegisterPharmacy marks the pharmacy identified by id as registered, setting its licence number
+// and, if location isn't nil, its location.
+// Returns ErrPharmacyNotFound if there is no such pharmacy and ErrDuplicatePharmacy
+// if the licence number belongs to another pharmacy
+func (m *Mongo) RegisterPharmacy(id primitive.ObjectID, licenceNumber string, location *model.GeoPoint) (*model.Pharmacy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set := bson.D{{"status", model.PharmacyRegistered}, {"licenceNumber", licenceNumber}}
+	if location != nil {
+		set = append(set, bson.E{"location", location})
+	}
+
+	var pharmacy model.Pharmacy
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := m.db.Collection(pharmacies).
+		FindOneAndUpdate(ctx, bson.D{{"_id", id}}, bson.D{{"$set", set}}, opts).Decode(&pharmacy)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPharmacyNotFound
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrDuplicatePharmacy
+		}
+		return nil, errors.Wrap(err, "failed to register pharmacy")
+	}
+	return &pharmacy, nil
+}
+
+func (m *Mongo) FetchPharmacies(filter *model.PharmacyFilter) (*[]model.Pharmacy, model.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := bson.D{}
+	if filter.Search != "" {
+		query = append(query, bson.E{"searchKey", containsPattern(model.PharmacySearchKey(filter.Search, ""))})
+	}
+	if filter.Status != "" {
+		query = append(query, bson.E{"status", filter.Status})
+	}
+	if filter.Near != nil {
+		query = append(query, bson.E{"location", withinRadius(filter.Near, filter.RadiusKm)})
+	}
+
+	total, err := m.db.Collection(pharmacies).CountDocuments(ctx, query)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to count pharmacies")
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{"status", 1}, {"name", 1}, {"_id", 1}}).
+		SetSkip(filter.Skip()).
+		SetLimit(filter.Limit())
+	curs, err := m.db.Collection(pharmacies).Find(ctx, query, opts)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to fetch pharmacies")
+	}
+
+	result := make([]model.Pharmacy, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "fetch pharmacies: failed to decode find result into slice")
+	}
+	return &result, model.NewMetadata(total, filter.Pagination), nil
+}
+
+// FetchPharmacyHotspots aggregates the incidence reports matching filter per pharmacy,
+// most reported pharmacies first. Reports that aren't linked to the registry are left out
+func (m *Mongo) FetchPharmacyHotspots(filter *model.IncidenceReportFilter) (*[]model.PharmacyHotspot, model.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	match := append(incidenceReportQuery(filter), bson.E{"pharmacyId", bson.D{{"$exists", true}}})
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$group", bson.D{
+			{"_id", "$pharmacyId"},
+			{"reportCount", bson.D{{"$sum", 1}}},
+			{"openCount", stateCount(model.ReportNew, model.ReportUnderInvestigation)},
+			{"confirmedCount", stateCount(model.ReportConfirmedCounterfeit)},
+			{"lastReportedOn", bson.D{{"$max", "$submittedOn"}}},
+		}}},
+		{{"$facet", bson.D{
+			{"total", bson.A{bson.D{{"$count", "count"}}}},
+			{"hotspots", bson.A{
+				bson.D{{"$sort", bson.D{{"reportCount", -1}, {"lastReportedOn", -1}, {"_id", 1}}}},
+				bson.D{{"$skip", filter.Skip()}},
+				bson.D{{"$limit", filter.Limit()}},
+				bson.D{{"$lookup", bson.D{
+					{"from", pharmacies},
+					{"localField", "_id"},
+					{"foreignField", "_id"},
+					{"as", "pharmacy"},
+				}}},
+				bson.D{{"$unwind", "$pharmacy"}},
+			}},
+		}}},
+	}
+
+	curs, err := m.db.Collection(incidenceReports).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to aggregate pharmacy hotspots")
+	}
+
+	var result []struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Hotspots []model.PharmacyHotspot `bson:"hotspots"`
+	}
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "fetch pharmacy hotspots: failed to decode aggregation result")
+	}
+
+	hotspots := make([]model.PharmacyHotspot, 0)
+	var total int64
+	if len(result) > 0 {
+		hotspots = append(hotspots, result[0].Hotspots...)
+		if len(result[0].Total) > 0 {
+			total = result[0].Total[0].Count
+		}
+	}
+	return &hotspots, model.NewMetadata(total, filter.Pagination), nil
+}
+
+// withinRadius matches GeoJSON points within radiusKm kilometres of center
+func withinRadius(center *model.GeoPoint, radiusKm float64) bson.D {
+	return bson.D{{"$geoWithin", bson.D{{"$centerSphere", bson.A{center.Coordinates, radiusKm / earthRadiusKm}}}}}
+}
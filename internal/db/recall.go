@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (m *Mongo) createRecallsCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"drugName", "batchNumber", "reason", "issuedOn"},
+		"properties": bson.M{
+			"drugName": bson.M{
+				"bsonType": "string",
+			},
+			"batchNumber": bson.M{
+				"bsonType": "string",
+			},
+			"reason": bson.M{
+				"bsonType": "string",
+			},
+			"issuedOn": bson.M{
+				"bsonType": "date",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, recalls, opts); err != nil {
+		logger.Logger.LogError("failed to create recalls collection",
+			"create recalls collection", err)
+	}
+
+	_, err := m.db.Collection(recalls).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"issuedOn", -1}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create recalls index",
+			"create recalls collection", err)
+	}
+}
+
+func (m *Mongo) InsertRecall(recall *model.Recall) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(recalls).InsertOne(ctx, recall)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert recall into db")
+	}
+	recall.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (m *Mongo) FetchRecalls(pagination model.Pagination) (*[]model.Recall, model.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	total, err := m.db.Collection(recalls).CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to count recalls")
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{"issuedOn", -1}, {"_id", -1}}).
+		SetSkip(pagination.Skip()).
+		SetLimit(pagination.Limit())
+	curs, err := m.db.Collection(recalls).Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to fetch recalls")
+	}
+
+	result := make([]model.Recall, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "fetch recalls: failed to decode find result into slice")
+	}
+	return &result, model.NewMetadata(total, pagination), nil
+}
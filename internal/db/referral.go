@@ -0,0 +1,327 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	// referralCodeAlphabet leaves out characters that are easily mistaken for one another, e.g., 0 and O
+	referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	referralCodeLength   = 8
+
+	// referralCodeAttempts bounds the codes tried when a generated code is already taken
+	referralCodeAttempts = 5
+)
+
+func (m *Mongo) createReferralsCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"referrerId", "inviteeId", "code", "status", "createdOn"},
+		"properties": bson.M{
+			"referrerId": bson.M{
+				"bsonType": "string",
+			},
+			"inviteeId": bson.M{
+				"bsonType": "string",
+			},
+			"code": bson.M{
+				"bsonType": "string",
+			},
+			"status": bson.M{
+				"enum": []model.ReferralStatus{model.ReferralPending, model.ReferralRewarded, model.ReferralUnrewarded},
+			},
+			"createdOn": bson.M{
+				"bsonType": "date",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, referrals, opts); err != nil {
+		logger.Logger.LogError("failed to create referrals collection",
+			"create referrals collection", err)
+	}
+
+	// a user is referred at most once
+	_, err := m.db.Collection(referrals).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"inviteeId", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{"referrerId", 1}, {"createdOn", -1}}},
+		{Keys: bson.D{{"referrerId", 1}, {"deviceId", 1}}},
+		{Keys: bson.D{{"referrerId", 1}, {"ipAddress", 1}}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create referrals indexes",
+			"create referrals collection", err)
+	}
+}
+
+// newReferralCode returns a random referral code
+func newReferralCode() (string, error) {
+	b := make([]byte, referralCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate referral code")
+	}
+
+	// 256 is a multiple of the alphabet's length, so every character is equally likely
+	for i := range b {
+		b[i] = referralCodeAlphabet[int(b[i])%len(referralCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// AssignReferralCode returns the referral code of the user identified by uid,
+// generating one if the user doesn't have one yet.
+// Returns ErrUserNotFound if uid is unknown
+func (m *Mongo) AssignReferralCode(uid string) (string, error) {
+	user, err := m.FetchUser(uid)
+	if err != nil {
+		return "", err
+	}
+	if user.ReferralCode != "" {
+		return user.ReferralCode, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"uid", uid}, {"referralCode", bson.D{{"$exists", false}}}}
+	for i := 0; i < referralCodeAttempts; i++ {
+		code, err := newReferralCode()
+		if err != nil {
+			return "", err
+		}
+		result, err := m.db.Collection(users).UpdateOne(ctx, filter, bson.D{{"$set", bson.D{{"referralCode", code}}}})
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return "", errors.Wrap(err, "failed to assign referral code")
+		}
+		if result.MatchedCount == 0 {
+
+			// a concurrent request assigned a code first
+			user, err := m.FetchUser(uid)
+			if err != nil {
+				return "", err
+			}
+			return user.ReferralCode, nil
+		}
+		return code, nil
+	}
+	return "", errors.New("failed to assign referral code: every generated code was taken")
+}
+
+// FetchUserByReferralCode fetches the user who owns the referral code.
+// Returns ErrReferralCodeNotFound if no user owns code
+func (m *Mongo) FetchUserByReferralCode(code string) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user model.User
+	err := m.db.Collection(users).FindOne(ctx, bson.D{{"referralCode", code}}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrReferralCodeNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch user by referral code")
+	}
+	return &user, nil
+}
+
+// InsertReferral records referral in the referral graph.
+// Returns ErrAlreadyReferred if the invitee was already referred
+func (m *Mongo) InsertReferral(referral *model.Referral) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(referrals).InsertOne(ctx, referral)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrAlreadyReferred
+		}
+		return errors.Wrap(err, "failed to insert referral into db")
+	}
+	referral.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// CountReferralsSince counts the users the user identified by referrerId referred since since
+func (m *Mongo) CountReferralsSince(referrerId string, since time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"referrerId", referrerId}, {"createdOn", bson.D{{"$gte", since}}}}
+	count, err := m.db.Collection(referrals).CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count referrals")
+	}
+	return count, nil
+}
+
+// CompleteReferral marks the pending referral of the user identified by inviteeId as completed.
+// Only the first call for a referral succeeds, later calls return ErrReferralNotFound,
+// as do calls for users who weren't referred
+func (m *Mongo) CompleteReferral(inviteeId string, completedOn time.Time) (*model.Referral, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{"inviteeId", inviteeId},
+		{"status", model.ReferralPending},
+		{"completedOn", bson.D{{"$exists", false}}},
+	}
+	update := bson.D{{"$set", bson.D{{"completedOn", completedOn}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var referral model.Referral
+	err := m.db.Collection(referrals).FindOneAndUpdate(ctx, filter, update, opts).Decode(&referral)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrReferralNotFound
+		}
+		return nil, errors.Wrap(err, "failed to complete referral")
+	}
+	return &referral, nil
+}
+
+// CountRewardedReferralsSharingSignup counts the other referrals of referral's referrer that were
+// rewarded and whose invitees signed up from the same device, and from the same IP address, as referral's
+func (m *Mongo) CountRewardedReferralsSharingSignup(referral *model.Referral) (sameDevice, sameIP int64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count := func(key, value string) (int64, error) {
+		if value == "" {
+			return 0, nil
+		}
+		filter := bson.D{
+			{"referrerId", referral.ReferrerID},
+			{key, value},
+			{"status", model.ReferralRewarded},
+			{"_id", bson.D{{"$ne", referral.ID}}},
+		}
+		return m.db.Collection(referrals).CountDocuments(ctx, filter)
+	}
+	if sameDevice, err = count("deviceId", referral.DeviceID); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to count referrals sharing a device")
+	}
+	if sameIP, err = count("ipAddress", referral.IPAddress); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to count referrals sharing an IP address")
+	}
+	return sameDevice, sameIP, nil
+}
+
+// ReserveReferralReward counts a referral reward against the user identified by referrerId,
+// who can be rewarded for at most max referrals. Non-positive values of max disable the limit.
+// Returns ErrReferralRewardCapReached if the user was already rewarded for max referrals
+func (m *Mongo) ReserveReferralReward(referrerId string, max int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"uid", referrerId}}
+	if max > 0 {
+		filter = append(filter, bson.E{"referralRewards", bson.D{{"$not", bson.D{{"$gte", max}}}}})
+	}
+	result, err := m.db.Collection(users).UpdateOne(ctx, filter, bson.D{{"$inc", bson.D{{"referralRewards", 1}}}})
+	if err != nil {
+		return errors.Wrap(err, "failed to reserve referral reward")
+	}
+	if result.MatchedCount == 0 {
+		return ErrReferralRewardCapReached
+	}
+	return nil
+}
+
+// ReleaseReferralReward takes back a reward reserved with ReserveReferralReward
+func (m *Mongo) ReleaseReferralReward(referrerId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.db.Collection(users).
+		UpdateOne(ctx, bson.D{{"uid", referrerId}}, bson.D{{"$inc", bson.D{{"referralRewards", -1}}}})
+	if err != nil {
+		return errors.Wrap(err, "failed to release referral reward")
+	}
+	return nil
+}
+
+// SettleReferral records the outcome of the completed referral identified by id.
+// rewardId is the nil id and reason explains why if the referral wasn't rewarded
+func (m *Mongo) SettleReferral(id primitive.ObjectID, status model.ReferralStatus, reason string, rewardId primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set := bson.D{{"status", status}}
+	if reason != "" {
+		set = append(set, bson.E{"reason", reason})
+	}
+	if !rewardId.IsZero() {
+		set = append(set, bson.E{"rewardId", rewardId})
+	}
+	_, err := m.db.Collection(referrals).UpdateOne(ctx, bson.D{{"_id", id}}, bson.D{{"$set", set}})
+	if err != nil {
+		return errors.Wrap(err, "failed to settle referral")
+	}
+	return nil
+}
+
+// FetchReferralSummary counts the referrals of the user identified by referrerId per status
+// and fetches the limit most recent ones into summary
+func (m *Mongo) FetchReferralSummary(referrerId string, limit int64, summary *model.ReferralSummary) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	curs, err := m.db.Collection(referrals).Aggregate(ctx, mongo.Pipeline{
+		{{"$match", bson.D{{"referrerId", referrerId}}}},
+		{{"$group", bson.D{{"_id", "$status"}, {"count", bson.D{{"$sum", 1}}}}}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to count referrals")
+	}
+	var counts []struct {
+		Status model.ReferralStatus `bson:"_id"`
+		Count  int                  `bson:"count"`
+	}
+	if err := curs.All(ctx, &counts); err != nil {
+		return errors.Wrap(err, "fetch referral summary: failed to decode aggregation result")
+	}
+	for _, c := range counts {
+		switch c.Status {
+		case model.ReferralPending:
+			summary.Pending = c.Count
+		case model.ReferralRewarded:
+			summary.Rewarded = c.Count
+		case model.ReferralUnrewarded:
+			summary.Unrewarded = c.Count
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{{"createdOn", -1}}).SetLimit(limit)
+	curs, err = m.db.Collection(referrals).Find(ctx, bson.D{{"referrerId", referrerId}}, opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch referrals")
+	}
+	summary.Referrals = make([]model.Referral, 0)
+	if err := curs.All(ctx, &summary.Referrals); err != nil {
+		return errors.Wrap(err, "fetch referral summary: failed to decode find result into slice")
+	}
+	return nil
+}
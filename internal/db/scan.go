@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (m *Mongo) createScansCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"uid", "validationOption", "validationData", "result", "scannedOn"},
+		"properties": bson.M{
+			"uid": bson.M{
+				"bsonType": "string",
+			},
+			"validationOption": bson.M{
+				"enum": []string{model.RFID, model.QrCode, model.ShortCode},
+			},
+			"validationData": bson.M{
+				"bsonType": "string",
+			},
+			"scannedOn": bson.M{
+				"bsonType": "date",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, scans, opts); err != nil {
+		logger.Logger.LogError("failed to create scans collection",
+			"create scans collection", err)
+	}
+
+	_, err := m.db.Collection(scans).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"uid", 1}, {"scannedOn", -1}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create scans index",
+			"create scans collection", err)
+	}
+
+	_, err = m.db.Collection(scans).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"uid", 1}, {"drugId", 1}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create scans drug index",
+			"create scans collection", err)
+	}
+}
+
+func (m *Mongo) InsertScan(scan *model.Scan) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(scans).InsertOne(ctx, scan)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert scan into db")
+	}
+	scan.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (m *Mongo) IsFirstDrugScan(scan *model.Scan) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{"uid", scan.UserID},
+		{"drugId", scan.DrugID},
+		{"_id", bson.D{{"$lt", scan.ID}}},
+	}
+	count, err := m.db.Collection(scans).CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to count earlier scans of drug")
+	}
+	return count == 0, nil
+}
+
+func (m *Mongo) FetchScan(id primitive.ObjectID) (*model.Scan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var scan model.Scan
+	err := m.db.Collection(scans).FindOne(ctx, bson.D{{"_id", id}}).Decode(&scan)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrScanNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch scan")
+	}
+	return &scan, nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// DuplicateUID lists the users sharing a uid
+type DuplicateUID struct {
+	UID string `bson:"_id"`
+
+	// UserIDs are the ids of the users' documents, oldest first
+	UserIDs []primitive.ObjectID `bson:"userIds"`
+}
+
+// FindDuplicateUIDs lists the uids shared by more than one user
+func (m *Mongo) FindDuplicateUIDs() ([]DuplicateUID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	return m.findDuplicateUIDs(ctx)
+}
+
+func (m *Mongo) findDuplicateUIDs(ctx context.Context) ([]DuplicateUID, error) {
+	pipeline := mongo.Pipeline{
+		{{"$sort", bson.D{{"_id", 1}}}},
+		{{"$group", bson.D{
+			{"_id", "$uid"},
+			{"userIds", bson.D{{"$push", "$_id"}}},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+		{{"$match", bson.D{{"count", bson.D{{"$gt", 1}}}}}},
+		{{"$sort", bson.D{{"_id", 1}}}},
+	}
+	curs, err := m.db.Collection(users).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find duplicate uids")
+	}
+
+	duplicates := make([]DuplicateUID, 0)
+	if err := curs.All(ctx, &duplicates); err != nil {
+		return nil, errors.Wrap(err, "failed to decode duplicate uids")
+	}
+	return duplicates, nil
+}
+
+// ResolveDuplicateUIDs keeps the oldest of the users sharing a uid, the one updates by uid
+// matched in practice, and moves the others to the
+// duplicateUsers collection, where they can be reviewed and restored, then creates the unique index
+// on uid. The uid's other documents, e.g., incidence reports, were never told apart and stay with
+// the user kept. It returns the duplicates resolved.
+// ResolveDuplicateUIDs is meant to be run once while sign ups are stopped, it can be run again
+// if new duplicates were inserted before the index was created
+func (m *Mongo) ResolveDuplicateUIDs() ([]DuplicateUID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	duplicates, err := m.findDuplicateUIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, duplicate := range duplicates {
+		moved := duplicate.UserIDs[1:]
+		curs, err := m.db.Collection(users).Find(ctx, bson.D{{"_id", bson.D{{"$in", moved}}}})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch users sharing uid %s", duplicate.UID)
+		}
+		var docs []interface{}
+		if err := curs.All(ctx, &docs); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode users sharing uid %s", duplicate.UID)
+		}
+
+		// users moved by an interrupted run are already in duplicateUsers
+		_, err = m.db.Collection(duplicateUsers).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return nil, errors.Wrapf(err, "failed to move users sharing uid %s", duplicate.UID)
+		}
+		if _, err := m.db.Collection(users).DeleteMany(ctx, bson.D{{"_id", bson.D{{"$in", moved}}}}); err != nil {
+			return nil, errors.Wrapf(err, "failed to remove users sharing uid %s", duplicate.UID)
+		}
+	}
+
+	if err := m.createUIDIndex(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to create users uid index")
+	}
+	m.uidIndexed = true
+	return duplicates, nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (m *Mongo) createUserChangesCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"uid", "version", "changes", "changedOn"},
+		"properties": bson.M{
+			"uid": bson.M{
+				"bsonType": "string",
+			},
+			"changes": bson.M{
+				"bsonType": "array",
+			},
+			"changedOn": bson.M{
+				"bsonType": "date",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, userChanges, opts); err != nil {
+		logger.Logger.LogError("failed to create user changes collection",
+			"create user changes collection", err)
+	}
+
+	// changes aren't unique per version: concurrent updates of the same version each record
+	// their change, and the ones that lose the race remove theirs, see DeleteUserChange
+	_, err := m.db.Collection(userChanges).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"uid", 1}, {"version", -1}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create user changes index",
+			"create user changes collection", err)
+	}
+}
+
+// PatchUser applies patch to the user identified by uid, provided the user is still at version,
+// and returns the patched user, whose version is incremented.
+// Users created before versions were introduced are at version 0.
+// Returns ErrUserNotFound if uid is unknown and ErrEditConflict if the user is at another version
+func (m *Mongo) PatchUser(uid string, version int, patch *model.UserPatch) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"uid", uid}, {"version", version}}
+	if version == 0 {
+		// $in with null also matches users without a version
+		filter = bson.D{{"uid", uid}, {"version", bson.D{{"$in", bson.A{0, nil}}}}}
+	}
+
+	update := bson.D{{"$inc", bson.D{{"version", 1}}}}
+	if len(patch.Set) > 0 {
+		set := bson.D{}
+		for key, value := range patch.Set {
+			set = append(set, bson.E{key, value})
+		}
+		update = append(update, bson.E{"$set", set})
+	}
+	if len(patch.Unset) > 0 {
+		unset := bson.D{}
+		for _, key := range patch.Unset {
+			unset = append(unset, bson.E{key, ""})
+		}
+		update = append(update, bson.E{"$unset", unset})
+	}
+
+	var user model.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := m.db.Collection(users).FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, errors.Wrap(err, "failed to patch user")
+	}
+	if _, err := m.FetchUser(uid); err != nil {
+		return nil, err
+	}
+	return nil, ErrEditConflict
+}
+
+// InsertUserChange records change ahead of the update it describes
+func (m *Mongo) InsertUserChange(change *model.UserChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(userChanges).InsertOne(ctx, change)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert user change into db")
+	}
+	change.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// DeleteUserChange removes the change identified by id, e.g., when the update it describes failed
+func (m *Mongo) DeleteUserChange(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := m.db.Collection(userChanges).DeleteOne(ctx, bson.D{{"_id", id}}); err != nil {
+		return errors.Wrap(err, "failed to delete user change")
+	}
+	return nil
+}
+
+// FetchUserChanges fetches a page of the change history of the user identified by uid, most recent first
+func (m *Mongo) FetchUserChanges(uid string, pagination model.Pagination) (*[]model.UserChange, model.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"uid", uid}}
+	total, err := m.db.Collection(userChanges).CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to count user changes")
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{"version", -1}}).
+		SetSkip(pagination.Skip()).
+		SetLimit(pagination.Limit())
+	curs, err := m.db.Collection(userChanges).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to fetch user changes")
+	}
+
+	result := make([]model.UserChange, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "fetch user changes: failed to decode find result into slice")
+	}
+	return &result, model.NewMetadata(total, pagination), nil
+}
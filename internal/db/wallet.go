@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (m *Mongo) createWalletChallengesCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"uid", "address", "message", "expiresOn"},
+		"properties": bson.M{
+			"uid": bson.M{
+				"bsonType": "string",
+			},
+			"address": bson.M{
+				"bsonType": "string",
+			},
+			"message": bson.M{
+				"bsonType": "string",
+			},
+			"expiresOn": bson.M{
+				"bsonType": "date",
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, walletChallenges, opts); err != nil {
+		logger.Logger.LogError("failed to create wallet challenges collection",
+			"create wallet challenges collection", err)
+	}
+
+	// a user has at most one outstanding challenge, which mongo removes once it expires
+	_, err := m.db.Collection(walletChallenges).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"uid", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{"expiresOn", 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create wallet challenges indexes",
+			"create wallet challenges collection", err)
+	}
+}
+
+// InsertWalletChallenge stores challenge, replacing any challenge previously issued to the same user
+func (m *Mongo) InsertWalletChallenge(challenge *model.WalletChallenge) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := m.db.Collection(walletChallenges).
+		ReplaceOne(ctx, bson.D{{"uid", challenge.UserID}}, challenge, opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert wallet challenge into db")
+	}
+	return nil
+}
+
+// ConsumeWalletChallenge fetches and deletes the unexpired challenge issued to the user identified
+// by uid for the wallet at address, so that it can't be answered twice.
+// Returns ErrWalletChallengeNotFound if there is no such challenge
+func (m *Mongo) ConsumeWalletChallenge(uid, address string) (*model.WalletChallenge, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{"uid", uid},
+		{"address", address},
+		{"expiresOn", bson.D{{"$gt", time.Now()}}},
+	}
+	var challenge model.WalletChallenge
+	err := m.db.Collection(walletChallenges).FindOneAndDelete(ctx, filter).Decode(&challenge)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrWalletChallengeNotFound
+		}
+		return nil, errors.Wrap(err, "failed to consume wallet challenge")
+	}
+	return &challenge, nil
+}
+
+// LinkWallet sets the wallet of the user identified by uid to the verified address,
+// provided the user has no wallet linked or has address linked already.
+// Returns ErrUserNotFound if uid is unknown, ErrWalletAlreadyLinked if the user has another wallet linked
+// and ErrWalletInUse if another user linked the wallet
+func (m *Mongo) LinkWallet(uid, address string, verifiedOn time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"uid", uid}, {"$or", bson.A{
+		bson.D{{"walletAddr", bson.D{{"$exists", false}}}},
+		bson.D{{"walletAddr", bson.D{{"$in", bson.A{"", address}}}}},
+	}}}
+	update := bson.D{
+		{"$set", bson.D{{"walletAddr", address}, {"walletVerifiedOn", verifiedOn}}},
+		{"$inc", bson.D{{"version", 1}}},
+	}
+	result, err := m.db.Collection(users).UpdateOne(ctx, filter, update)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrWalletInUse
+		}
+		return errors.Wrap(err, "failed to link wallet")
+	}
+	if result.MatchedCount == 0 {
+		if err := m.IsValidUser(uid); err != nil {
+			return err
+		}
+		return ErrWalletAlreadyLinked
+	}
+	return nil
+}
+
+// UnlinkWallet removes the wallet linked to the user identified by uid.
+// Returns ErrUserNotFound if uid is unknown
+func (m *Mongo) UnlinkWallet(uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.D{
+		{"$unset", bson.D{{"walletAddr", ""}, {"walletVerifiedOn", ""}}},
+		{"$inc", bson.D{{"version", 1}}},
+	}
+	result, err := m.db.Collection(users).UpdateOne(ctx, bson.D{{"uid", uid}}, update)
+	if err != nil {
+		return errors.Wrap(err, "failed to unlink wallet")
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
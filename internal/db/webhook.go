@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (m *Mongo) createWebhooksCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	jsonSchema := bson.M{
+		"bsonType": "object",
+		"required": []string{"partnerId", "url", "secret", "events"},
+		"properties": bson.M{
+			"partnerId": bson.M{
+				"bsonType": "objectId",
+			},
+			"url": bson.M{
+				"bsonType": "string",
+			},
+			"secret": bson.M{
+				"bsonType": "string",
+			},
+			"events": bson.M{
+				"bsonType": "array",
+				"items": bson.M{
+					"enum": model.WebhookEventTypes,
+				},
+			},
+		},
+	}
+	validator := bson.M{
+		"$jsonSchema": jsonSchema,
+	}
+	opts := options.CreateCollection().SetValidator(validator)
+
+	if err := m.db.CreateCollection(ctx, webhooks, opts); err != nil {
+		logger.Logger.LogError("failed to create webhooks collection",
+			"create webhooks collection", err)
+	}
+
+	_, err := m.db.Collection(webhooks).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"partnerId", 1}}},
+		{Keys: bson.D{{"events", 1}}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create webhooks indexes",
+			"create webhooks collection", err)
+	}
+}
+
+func (m *Mongo) createWebhookDeliveriesCollection() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	if err := m.db.CreateCollection(ctx, webhookDeliveries); err != nil {
+		logger.Logger.LogError("failed to create webhook deliveries collection",
+			"create webhook deliveries collection", err)
+	}
+
+	// the first index backs the dispatcher's search for due deliveries,
+	// the second one delivery logs
+	_, err := m.db.Collection(webhookDeliveries).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"status", 1}, {"nextAttemptAt", 1}}},
+		{Keys: bson.D{{"webhookId", 1}, {"createdOn", -1}}},
+	})
+	if err != nil {
+		logger.Logger.LogError("failed to create webhook deliveries indexes",
+			"create webhook deliveries collection", err)
+	}
+}
+
+func (m *Mongo) InsertWebhook(webhook *model.Webhook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(webhooks).InsertOne(ctx, webhook)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert webhook into db")
+	}
+	webhook.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (m *Mongo) FetchWebhook(id primitive.ObjectID) (*model.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var webhook model.Webhook
+	err := m.db.Collection(webhooks).FindOne(ctx, bson.D{{"_id", id}}).Decode(&webhook)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch webhook")
+	}
+	return &webhook, nil
+}
+
+func (m *Mongo) FetchWebhooksByPartnerID(partnerId primitive.ObjectID) (*[]model.Webhook, error) {
+	return m.fetchWebhooks(bson.D{{"partnerId", partnerId}})
+}
+
+func (m *Mongo) FetchWebhooksByEvent(eventType model.WebhookEventType) (*[]model.Webhook, error) {
+	return m.fetchWebhooks(bson.D{{"events", eventType}})
+}
+
+func (m *Mongo) fetchWebhooks(filter bson.D) (*[]model.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{"createdOn", 1}})
+	curs, err := m.db.Collection(webhooks).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch webhooks")
+	}
+
+	result := make([]model.Webhook, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, errors.Wrap(err, "fetch webhooks: failed to decode find result into slice")
+	}
+	return &result, nil
+}
+
+// DeleteWebhook deletes the webhook identified by id, provided it belongs to the partner
+// identified by partnerId. Pending deliveries to the webhook are marked as failed.
+// Returns ErrWebhookNotFound if there is no such webhook
+func (m *Mongo) DeleteWebhook(id, partnerId primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.db.Collection(webhooks).DeleteOne(ctx, bson.D{{"_id", id}, {"partnerId", partnerId}})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete webhook")
+	}
+	if result.DeletedCount == 0 {
+		return ErrWebhookNotFound
+	}
+
+	_, err = m.db.Collection(webhookDeliveries).UpdateMany(ctx,
+		bson.D{{"webhookId", id}, {"status", model.DeliveryPending}},
+		bson.D{{"$set", bson.D{{"status", model.DeliveryFailed}}}})
+	if err != nil {
+		return errors.Wrap(err, "failed to cancel pending webhook deliveries")
+	}
+	return nil
+}
+
+// InsertWebhookDeliveries inserts deliveries, setting the id of each of them
+func (m *Mongo) InsertWebhookDeliveries(deliveries []model.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	documents := make([]interface{}, len(deliveries))
+	for i := range deliveries {
+		deliveries[i].ID = primitive.NewObjectID()
+		documents[i] = deliveries[i]
+	}
+	if _, err := m.db.Collection(webhookDeliveries).InsertMany(ctx, documents); err != nil {
+		return errors.Wrap(err, "failed to insert webhook deliveries into db")
+	}
+	return nil
+}
+
+func (m *Mongo) ClaimDueWebhookDelivery(now time.Time, lease time.Duration) (*model.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{"status", model.DeliveryPending}, {"nextAttemptAt", bson.D{{"$lte", now}}}}
+	update := bson.D{{"$set", bson.D{{"nextAttemptAt", now.Add(lease)}}}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{"nextAttemptAt", 1}})
+
+	var delivery model.WebhookDelivery
+	err := m.db.Collection(webhookDeliveries).FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to claim webhook delivery")
+	}
+	return &delivery, nil
+}
+
+func (m *Mongo) RecordWebhookDeliveryAttempt(id primitive.ObjectID, attempt model.DeliveryAttempt,
+	status model.DeliveryStatus, nextAttemptAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set := bson.D{{"status", status}}
+	switch status {
+	case model.DeliveryPending:
+		set = append(set, bson.E{"nextAttemptAt", nextAttemptAt})
+	case model.DeliveryDelivered:
+		set = append(set, bson.E{"deliveredOn", attempt.At})
+	}
+
+	update := bson.D{{"$set", set}, {"$push", bson.D{{"attempts", attempt}}}}
+	if _, err := m.db.Collection(webhookDeliveries).UpdateByID(ctx, id, update); err != nil {
+		return errors.Wrap(err, "failed to record webhook delivery attempt")
+	}
+	return nil
+}
+
+func (m *Mongo) FetchWebhookDeliveries(filter *model.WebhookDeliveryFilter) (*[]model.WebhookDelivery, model.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := bson.D{{"webhookId", filter.WebhookID}}
+	if filter.Status != "" {
+		query = append(query, bson.E{"status", filter.Status})
+	}
+
+	total, err := m.db.Collection(webhookDeliveries).CountDocuments(ctx, query)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to count webhook deliveries")
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{"createdOn", -1}, {"_id", -1}}).
+		SetSkip(filter.Skip()).
+		SetLimit(filter.Limit())
+	curs, err := m.db.Collection(webhookDeliveries).Find(ctx, query, opts)
+	if err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "failed to fetch webhook deliveries")
+	}
+
+	result := make([]model.WebhookDelivery, 0)
+	if err := curs.All(ctx, &result); err != nil {
+		return nil, model.Metadata{}, errors.Wrap(err, "fetch webhook deliveries: failed to decode find result into slice")
+	}
+	return &result, model.NewMetadata(total, filter.Pagination), nil
+}
+
+func (m *Mongo) FetchWebhookDelivery(id primitive.ObjectID) (*model.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var delivery model.WebhookDelivery
+	err := m.db.Collection(webhookDeliveries).FindOne(ctx, bson.D{{"_id", id}}).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch webhook delivery")
+	}
+	return &delivery, nil
+}
@@ -0,0 +1,58 @@
+package imaging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/image/draw"
+	"image"
+	"math/bits"
+)
+
+// SimilarityThreshold is the largest HammingDistance between the perceptual hashes
+// of two images that are considered copies of each other, e.g., the same photo
+// resized, recompressed or lightly cropped
+const SimilarityThreshold = 10
+
+// Fingerprint identifies an uploaded image for duplicate detection
+type Fingerprint struct {
+
+	// ContentHash is the hex encoded sha256 hash of the uploaded file.
+	// Equal hashes mean the exact same file was uploaded
+	ContentHash string
+
+	// PerceptualHash is a difference hash of the image content, see differenceHash.
+	// Close hashes mean visually similar images, even if the files differ
+	PerceptualHash uint64
+}
+
+// HammingDistance counts the bits that differ between perceptual hashes a and b
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func fingerprint(data []byte, img image.Image) Fingerprint {
+	sum := sha256.Sum256(data)
+	return Fingerprint{
+		ContentHash:    hex.EncodeToString(sum[:]),
+		PerceptualHash: differenceHash(img),
+	}
+}
+
+// differenceHash computes the dHash of img: img is shrunk to a 9x8 grayscale
+// image and each bit records whether a pixel is brighter than its right neighbour.
+// The hash survives scaling and recompression, which change pixels but not gradients
+func differenceHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
@@ -0,0 +1,111 @@
+package imaging
+
+import (
+	"bytes"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// photo returns a width x height image with gradients and shapes, standing in for a picture of a drug box.
+// mirrored draws the scene mirrored horizontally
+func photo(width, height int, mirrored bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			if mirrored {
+				fx = 1 - fx
+			}
+			level := 40 + 150*fx*fy
+			switch {
+			case fx > 0.2 && fx < 0.45 && fy > 0.3 && fy < 0.7:
+				level = 230
+			case (fx-0.7)*(fx-0.7)+(fy-0.4)*(fy-0.4) < 0.02:
+				level = 15
+			}
+			img.Set(x, y, color.NRGBA{R: uint8(level), G: uint8(level * 0.9), B: uint8(level * 0.7), A: 255})
+		}
+	}
+	return img
+}
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xF0, 0x0F, 8},
+		{0, ^uint64(0), 64},
+	}
+	for _, tt := range tests {
+		if got := HammingDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("HammingDistance(%x, %x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDifferenceHash(t *testing.T) {
+	original := photo(640, 480, false)
+
+	// the same photo resized and recompressed
+	resized := image.NewNRGBA(image.Rect(0, 0, 320, 240))
+	draw.ApproxBiLinear.Scale(resized, resized.Bounds(), original, original.Bounds(), draw.Src, nil)
+	buffer := &bytes.Buffer{}
+	if err := jpeg.Encode(buffer, resized, &jpeg.Options{Quality: 50}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := jpeg.Decode(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same photo slightly cropped
+	cropped := original.SubImage(image.Rect(8, 6, 632, 474))
+
+	hash := differenceHash(original)
+	for _, duplicate := range []struct {
+		name string
+		img  image.Image
+	}{{"resized and recompressed", recompressed}, {"cropped", cropped}} {
+		if distance := HammingDistance(hash, differenceHash(duplicate.img)); distance > SimilarityThreshold {
+			t.Errorf("distance to the %s photo = %d, want at most %d", duplicate.name, distance, SimilarityThreshold)
+		}
+	}
+
+	checkerboard := image.NewGray(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			if (x/80+y/60)%2 == 0 {
+				checkerboard.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	for _, other := range []struct {
+		name string
+		img  image.Image
+	}{{"mirrored", photo(640, 480, true)}, {"checkerboard", checkerboard}} {
+		if distance := HammingDistance(hash, differenceHash(other.img)); distance <= SimilarityThreshold {
+			t.Errorf("distance to the %s photo = %d, want more than %d", other.name, distance, SimilarityThreshold)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	img := photo(64, 48, false)
+	a := fingerprint([]byte("upload a"), img)
+	b := fingerprint([]byte("upload b"), img)
+	if a.ContentHash == b.ContentHash {
+		t.Error("different uploads have the same content hash")
+	}
+	if a.PerceptualHash != b.PerceptualHash {
+		t.Error("uploads of the same image have different perceptual hashes")
+	}
+	if again := fingerprint([]byte("upload a"), img); again != a {
+		t.Errorf("fingerprint() = %+v, then %+v for the same upload", a, again)
+	}
+}
@@ -0,0 +1,172 @@
+// Package imaging sanitises images uploaded by clients before they are stored.
+//
+// Every upload is sniffed for its actual content type rather than trusting
+// the extension claimed by the client, decoded, and re-encoded from raw pixels.
+// Re-encoding discards all metadata embedded in the original file, including
+// EXIF GPS coordinates of the device that captured the image.
+package imaging
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // registers the webp decoder with image.Decode
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// DisplayMaxDimension is the maximum width or height of
+	// the display version of a processed image
+	DisplayMaxDimension = 1280
+
+	// ThumbnailMaxDimension is the maximum width or height of
+	// the thumbnail version of a processed image
+	ThumbnailMaxDimension = 320
+
+	// maxPixels guards against decompression bombs, i.e., small files
+	// that declare huge dimensions
+	maxPixels = 40_000_000
+
+	jpegQuality = 85
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format; only jpeg, png and webp images are accepted")
+	ErrImageTooLarge     = errors.New("image dimensions too large")
+)
+
+// Format is the actual format of an image as detected from its content
+type Format string
+
+const (
+	JPEG Format = "image/jpeg"
+	PNG  Format = "image/png"
+	WebP Format = "image/webp"
+)
+
+// Image is an encoded image ready to be stored
+type Image struct {
+	Data        []byte
+	ContentType string
+
+	// Extension is the file extension matching ContentType, without the leading dot
+	Extension string
+}
+
+// Processed holds the versions of an uploaded image that are kept in storage.
+// The original upload is never kept.
+type Processed struct {
+	Display     Image
+	Thumbnail   Image
+	Fingerprint Fingerprint
+}
+
+// Sniff detects the format of the image in data.
+// ErrUnsupportedFormat is returned if data is not a JPEG, PNG or WebP image.
+func Sniff(data []byte) (Format, error) {
+	switch Format(http.DetectContentType(data)) {
+	case JPEG:
+		return JPEG, nil
+	case PNG:
+		return PNG, nil
+	case WebP:
+		return WebP, nil
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+// Process sniffs, decodes and re-encodes data into a display version and
+// a thumbnail, and fingerprints it. Images are only ever scaled down, never up.
+// PNG images are kept as PNG to preserve transparency, every other format
+// is re-encoded as JPEG.
+// ErrUnsupportedFormat and ErrImageTooLarge should be treated as validation errors.
+func Process(data []byte) (*Processed, error) {
+	format, err := Sniff(data)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	var orientation = 1
+	if format == JPEG {
+		orientation = exifOrientation(data)
+	}
+
+	display, err := encode(orient(fit(src, DisplayMaxDimension), orientation), format)
+	if err != nil {
+		return nil, err
+	}
+	thumbnailImage := orient(fit(src, ThumbnailMaxDimension), orientation)
+	thumbnail, err := encode(thumbnailImage, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Processed{
+		Display:     *display,
+		Thumbnail:   *thumbnail,
+		Fingerprint: fingerprint(data, thumbnailImage),
+	}, nil
+}
+
+// fit scales src down so that neither of its dimensions exceeds maxDimension.
+// The returned image is always a fresh copy of src.
+func fit(src image.Image, maxDimension int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxDimension || height > maxDimension {
+		if width >= height {
+			height = height * maxDimension / width
+			width = maxDimension
+		} else {
+			width = width * maxDimension / height
+			height = maxDimension
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+func encode(img image.Image, source Format) (*Image, error) {
+	buffer := &bytes.Buffer{}
+
+	if source == PNG {
+		if err := png.Encode(buffer, img); err != nil {
+			return nil, errors.Wrap(err, "error encoding png image")
+		}
+		return &Image{Data: buffer.Bytes(), ContentType: string(PNG), Extension: "png"}, nil
+	}
+
+	// jpeg has no alpha channel, so transparent pixels are flattened onto white
+	flattened := image.NewRGBA(img.Bounds())
+	draw.Draw(flattened, flattened.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flattened, flattened.Bounds(), img, img.Bounds().Min, draw.Over)
+	if err := jpeg.Encode(buffer, flattened, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, errors.Wrap(err, "error encoding jpeg image")
+	}
+	return &Image{Data: buffer.Bytes(), ContentType: string(JPEG), Extension: "jpg"}, nil
+}
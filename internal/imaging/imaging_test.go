@@ -0,0 +1,269 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	red   = color.NRGBA{R: 255, A: 255}
+	green = color.NRGBA{G: 255, A: 255}
+	blue  = color.NRGBA{B: 255, A: 255}
+	white = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+)
+
+// quadrants returns a width x height image whose quadrants are, clockwise from the top left,
+// red, green, white and blue, so that any rotation or mirroring of it can be told apart
+func quadrants(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			switch {
+			case x < width/2 && y < height/2:
+				img.Set(x, y, red)
+			case y < height/2:
+				img.Set(x, y, green)
+			case x < width/2:
+				img.Set(x, y, blue)
+			default:
+				img.Set(x, y, white)
+			}
+		}
+	}
+	return img
+}
+
+// transform returns img with each pixel at (x, y) moved to to(x, y, width, height),
+// in a dstWidth x dstHeight image
+func transform(img image.Image, dstWidth, dstHeight int, to func(x, y, width, height int) (int, int)) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			dx, dy := to(x, y, bounds.Dx(), bounds.Dy())
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// stored returns how a camera stores the pixels of the upright image img along with orientation,
+// i.e., img transformed by the inverse of what the orientation tells viewers to do
+func stored(img image.Image, orientation int) image.Image {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	flipH := func(x, y, w, h int) (int, int) { return w - 1 - x, y }
+	flipV := func(x, y, w, h int) (int, int) { return x, h - 1 - y }
+	rotate180 := func(x, y, w, h int) (int, int) { return w - 1 - x, h - 1 - y }
+	transpose := func(x, y, w, h int) (int, int) { return y, x }
+	transverse := func(x, y, w, h int) (int, int) { return h - 1 - y, w - 1 - x }
+	rotateCW := func(x, y, w, h int) (int, int) { return h - 1 - y, x }
+	rotateCCW := func(x, y, w, h int) (int, int) { return y, w - 1 - x }
+
+	switch orientation {
+	case 2:
+		return transform(img, w, h, flipH)
+	case 3:
+		return transform(img, w, h, rotate180)
+	case 4:
+		return transform(img, w, h, flipV)
+	case 5:
+		return transform(img, h, w, transpose)
+	case 6:
+		// viewers rotate the stored image clockwise
+		return transform(img, h, w, rotateCCW)
+	case 7:
+		return transform(img, h, w, transverse)
+	case 8:
+		// viewers rotate the stored image counterclockwise
+		return transform(img, h, w, rotateCW)
+	default:
+		return img
+	}
+}
+
+// near reports if the color of img at (x, y) is within a lossy compression's reach of want
+func near(img image.Image, x, y int, want color.NRGBA) bool {
+	got := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	diff := func(a, b uint8) int {
+		if a > b {
+			return int(a - b)
+		}
+		return int(b - a)
+	}
+	return diff(got.R, want.R) < 40 && diff(got.G, want.G) < 40 && diff(got.B, want.B) < 40
+}
+
+func checkQuadrants(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	for _, quadrant := range []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{w / 4, h / 4, red},
+		{3 * w / 4, h / 4, green},
+		{w / 4, 3 * h / 4, blue},
+		{3 * w / 4, 3 * h / 4, white},
+	} {
+		if !near(img, bounds.Min.X+quadrant.x, bounds.Min.Y+quadrant.y, quadrant.want) {
+			t.Errorf("%s at (%d, %d) = %v, want %v", name, quadrant.x, quadrant.y,
+				img.At(bounds.Min.X+quadrant.x, bounds.Min.Y+quadrant.y), quadrant.want)
+		}
+	}
+}
+
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestProcessOrientation(t *testing.T) {
+	upright := quadrants(64, 32)
+	for orientation := 1; orientation <= 8; orientation++ {
+		data := withSegment(encodeJPEG(t, stored(upright, orientation)),
+			exifSegment(binary.BigEndian, uint16(orientation)))
+
+		processed, err := Process(data)
+		if err != nil {
+			t.Fatalf("Process() with orientation %d: %v", orientation, err)
+		}
+		for _, version := range []struct {
+			name string
+			data []byte
+		}{{"display", processed.Display.Data}, {"thumbnail", processed.Thumbnail.Data}} {
+			img := decode(t, version.data)
+			if img.Bounds().Dx() != 64 || img.Bounds().Dy() != 32 {
+				t.Errorf("%s with orientation %d is %dx%d, want 64x32", version.name, orientation,
+					img.Bounds().Dx(), img.Bounds().Dy())
+				continue
+			}
+			checkQuadrants(t, version.name, img)
+		}
+		if bytes.Contains(processed.Display.Data, []byte("Exif")) {
+			t.Errorf("display with orientation %d kept its Exif segment", orientation)
+		}
+	}
+}
+
+// withDimensions returns the PNG image in data with the dimensions declared in its header replaced
+func withDimensions(data []byte, width, height uint32) []byte {
+	out := append([]byte{}, data...)
+
+	// the signature is followed by the IHDR chunk: length, type, width, height, ..., crc
+	ihdr := out[8:]
+	binary.BigEndian.PutUint32(ihdr[8:], width)
+	binary.BigEndian.PutUint32(ihdr[12:], height)
+	binary.BigEndian.PutUint32(ihdr[21:], crc32.ChecksumIEEE(ihdr[4:21]))
+	return out
+}
+
+func TestProcess(t *testing.T) {
+	encodePNG := func(img image.Image) []byte {
+		buffer := &bytes.Buffer{}
+		if err := png.Encode(buffer, img); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.Bytes()
+	}
+	gifData := &bytes.Buffer{}
+	if err := gif.Encode(gifData, quadrants(8, 8), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		data          []byte
+		err           error
+		contentType   string
+		display       image.Point
+		thumbnail     image.Point
+		keepsAlphaPNG bool
+	}{
+		{
+			name:        "large jpeg",
+			data:        encodeJPEG(t, quadrants(2000, 1000)),
+			contentType: "image/jpeg",
+			display:     image.Pt(DisplayMaxDimension, 640),
+			thumbnail:   image.Pt(ThumbnailMaxDimension, 160),
+		},
+		{
+			name:        "small portrait jpeg",
+			data:        encodeJPEG(t, quadrants(100, 200)),
+			contentType: "image/jpeg",
+			display:     image.Pt(100, 200),
+			thumbnail:   image.Pt(100, 200),
+		},
+		{
+			name:        "png",
+			data:        encodePNG(quadrants(400, 800)),
+			contentType: "image/png",
+			display:     image.Pt(400, 800),
+			thumbnail:   image.Pt(160, ThumbnailMaxDimension),
+		},
+		{name: "gif", data: gifData.Bytes(), err: ErrUnsupportedFormat},
+		{name: "text", data: []byte("not an image"), err: ErrUnsupportedFormat},
+		{name: "truncated jpeg", data: encodeJPEG(t, quadrants(64, 64))[:100], err: ErrUnsupportedFormat},
+		{name: "decompression bomb", data: withDimensions(encodePNG(quadrants(8, 8)), 10000, 5000), err: ErrImageTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processed, err := Process(tt.data)
+			if err != tt.err {
+				t.Fatalf("Process() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			for _, version := range []struct {
+				name  string
+				image Image
+				size  image.Point
+			}{{"display", processed.Display, tt.display}, {"thumbnail", processed.Thumbnail, tt.thumbnail}} {
+				if version.image.ContentType != tt.contentType {
+					t.Errorf("%s content type = %s, want %s", version.name, version.image.ContentType, tt.contentType)
+				}
+				img := decode(t, version.image.Data)
+				if got := img.Bounds().Size(); got != version.size {
+					t.Errorf("%s size = %v, want %v", version.name, got, version.size)
+				}
+				checkQuadrants(t, version.name, img)
+			}
+			if processed.Fingerprint.ContentHash == "" {
+				t.Error("Process() didn't fingerprint the image")
+			}
+		})
+	}
+}
+
+func TestProcessFlattensTransparency(t *testing.T) {
+	// webp is re-encoded as jpeg like any other format but png, so a transparent image
+	// is checked through encode directly
+	transparent := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	encoded, err := encode(transparent, WebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded.ContentType != "image/jpeg" || encoded.Extension != "jpg" {
+		t.Errorf("encode() = %s .%s, want image/jpeg .jpg", encoded.ContentType, encoded.Extension)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(encoded.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(img, 2, 2, white) {
+		t.Errorf("transparent pixel encoded as %v, want white", img.At(2, 2))
+	}
+}
@@ -0,0 +1,128 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+const (
+	exifOrientationTag = 0x0112
+	jpegAPP1Marker     = 0xE1
+	jpegSOSMarker      = 0xDA
+)
+
+// exifOrientation reads the EXIF orientation tag from the JPEG image in data.
+// Since re-encoding strips EXIF, the orientation must be applied to the pixels
+// themselves or photos taken in portrait mode would be displayed sideways.
+// 1 (i.e., no transformation) is returned if the tag isn't found or data is malformed.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	// walk JPEG segments until APP1 (Exif) or the start of scan
+	offset := 2
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			return 1
+		}
+		marker := data[offset+1]
+		if marker == jpegSOSMarker {
+			return 1
+		}
+		segmentLength := int(binary.BigEndian.Uint16(data[offset+2:]))
+		segmentStart := offset + 4
+		segmentEnd := offset + 2 + segmentLength
+		if segmentLength < 2 || segmentEnd > len(data) {
+			return 1
+		}
+		if marker == jpegAPP1Marker {
+			if orientation, ok := tiffOrientation(data[segmentStart:segmentEnd]); ok {
+				return orientation
+			}
+		}
+		offset = segmentEnd
+	}
+	return 1
+}
+
+// tiffOrientation parses the orientation tag out of an APP1 segment payload
+func tiffOrientation(segment []byte) (int, bool) {
+	const exifHeader = "Exif\x00\x00"
+	if len(segment) < len(exifHeader)+8 || string(segment[:len(exifHeader)]) != exifHeader {
+		return 0, false
+	}
+	tiff := segment[len(exifHeader):]
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:]))
+	if ifdOffset+2 > len(tiff) {
+		return 0, false
+	}
+	entries := int(order.Uint16(tiff[ifdOffset:]))
+	for i := 0; i < entries; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0, false
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 0, false
+			}
+			return orientation, true
+		}
+	}
+	return 0, false
+}
+
+// orient applies the transformation described by the EXIF orientation value to img.
+// See https://magnushoff.com/articles/jpeg-orientation/ for the meaning of each value.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// orientations 5 through 8 swap width and height
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = width-1-x, y
+			case 3: // rotated 180°
+				dx, dy = width-1-x, height-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, height-1-y
+			case 5: // mirrored horizontally and rotated 270° clockwise
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = height-1-y, x
+			case 7: // mirrored horizontally and rotated 90° clockwise
+				dx, dy = height-1-y, width-1-x
+			case 8: // rotated 270° clockwise
+				dx, dy = y, width-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,121 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifSegment returns an APP1 segment holding an Exif IFD with a single orientation entry, in byte order order
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3) // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, jpegAPP1Marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// withSegment inserts segment right after the start of image marker of the JPEG image in data
+func withSegment(data, segment []byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	if err := jpeg.Encode(buffer, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestExifOrientation(t *testing.T) {
+	plain := encodeJPEG(t, image.NewGray(image.Rect(0, 0, 8, 8)))
+
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			data := withSegment(plain, exifSegment(order, orientation))
+			if got := exifOrientation(data); got != int(orientation) {
+				t.Errorf("exifOrientation() with %s orientation %d = %d", order, orientation, got)
+			}
+		}
+	}
+
+	truncated := withSegment(plain, exifSegment(binary.BigEndian, 6))[:30]
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no exif", plain},
+		{"orientation out of range", withSegment(plain, exifSegment(binary.BigEndian, 9))},
+		{"truncated", truncated},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n")},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		if got := exifOrientation(tt.data); got != 1 {
+			t.Errorf("exifOrientation() of %s = %d, want 1", tt.name, got)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a 3x2 image whose pixels are told apart by their gray level:
+	//	a b c
+	//	d e f
+	a, b, c := color.Gray{Y: 10}, color.Gray{Y: 20}, color.Gray{Y: 30}
+	d, e, f := color.Gray{Y: 40}, color.Gray{Y: 50}, color.Gray{Y: 60}
+	src := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i, gray := range []color.Gray{a, b, c, d, e, f} {
+		src.SetGray(i%3, i/3, gray)
+	}
+
+	// the upright image for each orientation the pixels are stored in
+	tests := []struct {
+		orientation int
+		want        [][]color.Gray
+	}{
+		{1, [][]color.Gray{{a, b, c}, {d, e, f}}},
+		{2, [][]color.Gray{{c, b, a}, {f, e, d}}},
+		{3, [][]color.Gray{{f, e, d}, {c, b, a}}},
+		{4, [][]color.Gray{{d, e, f}, {a, b, c}}},
+		{5, [][]color.Gray{{a, d}, {b, e}, {c, f}}},
+		{6, [][]color.Gray{{d, a}, {e, b}, {f, c}}},
+		{7, [][]color.Gray{{f, c}, {e, b}, {d, a}}},
+		{8, [][]color.Gray{{c, f}, {b, e}, {a, d}}},
+	}
+	for _, tt := range tests {
+		got := orient(src, tt.orientation)
+		width, height := len(tt.want[0]), len(tt.want)
+		if got.Bounds().Dx() != width || got.Bounds().Dy() != height {
+			t.Errorf("orient(%d) is %dx%d, want %dx%d", tt.orientation,
+				got.Bounds().Dx(), got.Bounds().Dy(), width, height)
+			continue
+		}
+		for y, row := range tt.want {
+			for x, want := range row {
+				if gray := color.GrayModel.Convert(got.At(x, y)).(color.Gray); gray != want {
+					t.Errorf("orient(%d) at (%d, %d) = %d, want %d", tt.orientation, x, y, gray.Y, want.Y)
+				}
+			}
+		}
+	}
+}
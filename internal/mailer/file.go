@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// unsafeFileChars are replaced in the recipient part of email file names
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]`)
+
+// FileMailer writes every email to a .eml file in a directory instead of sending it,
+// it stands in for an SMTP server in development
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer returns a FileMailer writing to dir, which is created if needed
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create mail directory")
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%d_%s.eml", now.UnixNano(), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	if err := os.WriteFile(filepath.Join(m.dir, name), msg.format(m.from, now), 0600); err != nil {
+		return errors.Wrap(err, "failed to write email")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Package mailer sends the emails HeartNet sends users, e.g., one-time codes.
+//
+// Mailers are pluggable: SMTPMailer delivers through any SMTP server, e.g., a
+// transactional email provider in production or a local catcher such as MailHog
+// in development, while FileMailer writes emails to a directory instead of sending them.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMessage = errors.New("invalid email message")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email messages
+type Mailer interface {
+
+	// Send delivers msg, or fails, before ctx is done
+	Send(ctx context.Context, msg *Message) error
+}
+
+// validate rejects messages whose headers would break the email format, e.g., header injection
+func (msg *Message) validate() error {
+	if msg.To == "" || strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return ErrInvalidMessage
+	}
+	return nil
+}
+
+// format renders msg, sent by from, in the Internet Message Format
+func (msg *Message) format(from string, date time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
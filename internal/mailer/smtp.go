@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig configures an SMTPMailer
+type SMTPConfig struct {
+	Host string
+	Port int
+
+	// Username and Password authenticate with the server, authentication is skipped if Username is empty
+	Username string
+	Password string
+
+	// From is the sender address, e.g., HeartNet <no-reply@hrtnet.io>
+	From string
+}
+
+// SMTPMailer sends emails through an SMTP server.
+// Connections are upgraded with STARTTLS whenever the server supports it
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	if config.Host == "" || config.Port == 0 || config.From == "" {
+		return nil, errors.New("smtp host, port and sender address must be set")
+	}
+	return &SMTPMailer{config: config}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to smtp server")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "failed to start smtp session")
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+			return errors.Wrap(err, "failed to start tls")
+		}
+	}
+	if m.config.Username != "" {
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return errors.Wrap(err, "failed to authenticate with smtp server")
+		}
+	}
+
+	if err := client.Mail(envelopeAddress(m.config.From)); err != nil {
+		return errors.Wrap(err, "smtp MAIL command failed")
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return errors.Wrap(err, "smtp RCPT command failed")
+	}
+	w, err := client.Data()
+	if err != nil {
+		return errors.Wrap(err, "smtp DATA command failed")
+	}
+	if _, err := w.Write(msg.format(m.config.From, time.Now())); err != nil {
+		return errors.Wrap(err, "failed to write email")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "failed to send email")
+	}
+	return client.Quit()
+}
+
+// envelopeAddress returns the bare address of from, e.g., no-reply@hrtnet.io for HeartNet <no-reply@hrtnet.io>
+func envelopeAddress(from string) string {
+	if start, end := strings.LastIndex(from, "<"), strings.LastIndex(from, ">"); start >= 0 && end > start {
+		return from[start+1 : end]
+	}
+	return from
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+// CodePurpose is what a OneTimeCode proves
+type CodePurpose string
+
+const (
+	// CodeEmailVerification codes prove a user owns the email address they want to link to their account
+	CodeEmailVerification CodePurpose = "email_verification"
+
+	// CodeAccountRecovery codes prove the owner of a verified email address wants to recover their account
+	CodeAccountRecovery CodePurpose = "account_recovery"
+
+	// CodeSessionClaim codes prove a user who signed up before sessions were introduced
+	// owns the email on their account, so that they can claim their first session
+	CodeSessionClaim CodePurpose = "session_claim"
+)
+
+// OneTimeCode is a short code emailed to a user. A code is used up once it is answered
+// correctly, or after too many wrong answers
+type OneTimeCode struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	Purpose CodePurpose        `bson:"purpose"`
+	Email   string             `bson:"email"`
+
+	// UserID is the user the code was issued to
+	UserID string `bson:"uid"`
+
+	// CodeHash is the hash of the code, see HashToken. The code itself is never stored
+	CodeHash  string    `bson:"codeHash"`
+	Attempts  int       `bson:"attempts"`
+	CreatedOn time.Time `bson:"createdOn"`
+	ExpiresOn time.Time `bson:"expiresOn"`
+}
+
+// Session authenticates the requests of a user's app install,
+// the app sends the session token as a bearer token
+type Session struct {
+	ID     primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID string             `json:"user_id" bson:"uid"`
+
+	// TokenHash is the hash of the session token, see HashToken. The token itself is never stored
+	TokenHash string    `json:"-" bson:"tokenHash"`
+	CreatedOn time.Time `json:"created_on" bson:"createdOn"`
+	ExpiresOn time.Time `json:"expires_on" bson:"expiresOn"`
+}
+
+// HashToken returns the hash under which a secret token, e.g., a session token, is stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NormaliseEmail returns email as it is stored, email addresses are compared case-insensitively
+func NormaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// AccountDeletion is the audit record of a user's request to delete their account.
+// It doesn't hold the user's uid, only its hash, so that a deletion can be confirmed
+// for a given uid without keeping the uid itself
+type AccountDeletion struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserIDHash  string             `json:"-" bson:"uidHash"`
+	RequestedOn time.Time          `json:"requested_on" bson:"requestedOn"`
+
+	// AnonymousID replaces the uid on the records that are kept, see AccountDeletion.Anonymised
+	AnonymousID string `json:"-" bson:"anonymousId"`
+
+	// ImageKeys are the blob keys of the images attached to the user's incidence reports.
+	// They are recorded before the reports are anonymised, so that a retried deletion still deletes them
+	ImageKeys []string `json:"-" bson:"imageKeys,omitempty"`
+
+	// Erased and Anonymised count the deleted and anonymised documents per collection
+	Erased        map[string]int64 `json:"erased" bson:"erased,omitempty"`
+	Anonymised    map[string]int64 `json:"anonymised" bson:"anonymised,omitempty"`
+	DeletedImages int              `json:"deleted_images" bson:"deletedImages"`
+	CompletedOn   *time.Time       `json:"completed_on,omitempty" bson:"completedOn,omitempty"`
+}
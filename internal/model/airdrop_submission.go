@@ -1,22 +1,203 @@
 package model
 
 import (
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type AirdropSubmission struct {
-	ID               primitive.ObjectID `json:"id" bson:"_id"`
+	ID primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+
+	// CampaignID is the campaign the submission was made to.
+	// It is the nil id on submissions made before campaigns were introduced
+	CampaignID       primitive.ObjectID `json:"campaign_id" bson:"campaignId,omitempty"`
 	TelegramUsername string             `json:"telegram_username" bson:"telegramUsername" validate:"required"`
 	TwitterUsername  string             `json:"twitter_username" bson:"twitterUsername" validate:"required"`
-	TweetLink        string             `json:"tweet_link" bson:"tweetLink" validate:"required"`
+	TweetLink        string             `json:"tweet_link" bson:"tweetLink" validate:"required,url"`
 	YoutubeUsername  string             `json:"youtube_username" bson:"youtubeUsername"`
 
-	// WalletAddress can be empty if EmailAddress is empty
+	// WalletAddress can be empty if EmailAddress is provided
 	WalletAddress string `json:"wallet_address" bson:"wallet,omitempty"`
 
 	// EmailAddress can be empty if WalletAddress is provided
-	EmailAddress string    `json:"email_address,omitempty" bson:"email,omitempty"`
+	EmailAddress string    `json:"email_address,omitempty" bson:"email,omitempty" validate:"omitempty,email"`
 	UserID       string    `json:"user_id" bson:"uid" validate:"required"`
 	SubmittedOn  time.Time `json:"submitted_on" bson:"submittedOn" validate:"required"`
+
+	// Status is empty on submissions made before reviews were introduced, which are pending
+	Status SubmissionStatus  `json:"status" bson:"status,omitempty"`
+	Review *SubmissionReview `json:"review,omitempty" bson:"review,omitempty"`
+
+	// IPAddress and DeviceID identify where the submission was made from, for sybil detection.
+	// They and Fraud are only shown to admins, see AirdropSubmissionAdminView
+	IPAddress string `json:"-" bson:"ipAddress,omitempty"`
+	DeviceID  string `json:"-" bson:"deviceId,omitempty"`
+
+	// Fraud is set on submissions that share identifiers with submissions by other users
+	Fraud *SubmissionFraud `json:"-" bson:"fraud,omitempty"`
+}
+
+// AirdropSubmissionAdminView is an airdrop submission as shown to admins reviewing it,
+// with where it was made from and the outcome of sybil detection
+type AirdropSubmissionAdminView struct {
+	AirdropSubmission
+	IPAddress string           `json:"ip_address,omitempty"`
+	DeviceID  string           `json:"device_id,omitempty"`
+	Fraud     *SubmissionFraud `json:"fraud,omitempty"`
+}
+
+// AdminView returns the admin view of s
+func (s *AirdropSubmission) AdminView() *AirdropSubmissionAdminView {
+	return &AirdropSubmissionAdminView{
+		AirdropSubmission: *s,
+		IPAddress:         s.IPAddress,
+		DeviceID:          s.DeviceID,
+		Fraud:             s.Fraud,
+	}
+}
+
+// AirdropSubmissionAdminViews returns the admin views of submissions
+func AirdropSubmissionAdminViews(submissions []AirdropSubmission) []*AirdropSubmissionAdminView {
+	views := make([]*AirdropSubmissionAdminView, 0, len(submissions))
+	for i := range submissions {
+		views = append(views, submissions[i].AdminView())
+	}
+	return views
+}
+
+// SubmissionFraud is the outcome of the sybil detection pass on an airdrop submission
+type SubmissionFraud struct {
+	Flagged bool `json:"flagged" bson:"flagged"`
+
+	// Score grows with the identifiers shared with other users' submissions
+	Score int `json:"score" bson:"score"`
+
+	// Reasons describes, in admin friendly terms, what the submission shares with others
+	Reasons []string `json:"reasons" bson:"reasons"`
+
+	// RelatedSubmissions holds the ids of the other users' submissions this submission shares identifiers with
+	RelatedSubmissions []primitive.ObjectID `json:"related_submissions" bson:"relatedSubmissions"`
+}
+
+// IsReviewed reports if s was approved or rejected
+func (s *AirdropSubmission) IsReviewed() bool {
+	return s.Status == SubmissionApproved || s.Status == SubmissionRejected
+}
+
+// SubmissionStatus is the review state of an airdrop submission
+type SubmissionStatus string
+
+const (
+	SubmissionPending  SubmissionStatus = "pending"
+	SubmissionApproved SubmissionStatus = "approved"
+	SubmissionRejected SubmissionStatus = "rejected"
+)
+
+// IsValid reports if s is a known submission status
+func (s SubmissionStatus) IsValid() bool {
+	return s == SubmissionPending || s == SubmissionApproved || s == SubmissionRejected
+}
+
+// SubmissionReview records the outcome of an admin's review of an airdrop submission
+type SubmissionReview struct {
+
+	// Reason explains why a submission was rejected
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
+
+	// RewardID identifies the Reward recorded for an approved submission.
+	// It is nil if the submission wasn't made to a campaign
+	RewardID   *primitive.ObjectID `json:"reward_id,omitempty" bson:"rewardId,omitempty"`
+	ReviewedOn time.Time           `json:"reviewed_on" bson:"reviewedOn"`
+}
+
+// AirdropSubmissionFilter narrows down a listing of airdrop submissions.
+// Zero valued fields are ignored.
+type AirdropSubmissionFilter struct {
+	CampaignID    *primitive.ObjectID
+	Status        SubmissionStatus
+	SubmittedFrom time.Time
+	SubmittedTo   time.Time
+
+	// Flagged, if set, restricts the listing to submissions that are, or aren't, flagged as fraudulent
+	Flagged *bool
+
+	// HasWallet, if set, restricts the listing to submissions that have, or don't have, a wallet address
+	HasWallet *bool
+	Pagination
+}
+
+// ErrInvalidCursor is returned when parsing a cursor that wasn't returned by SubmissionCursor.String
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SubmissionCursor marks a position in a listing of airdrop submissions sorted
+// by submission time, most recent first.
+// Unlike page numbers, cursors stay valid while new submissions come in
+type SubmissionCursor struct {
+	SubmittedOn time.Time
+	ID          primitive.ObjectID
+}
+
+// NewSubmissionCursor returns the cursor positioned right after s
+func NewSubmissionCursor(s *AirdropSubmission) *SubmissionCursor {
+	return &SubmissionCursor{SubmittedOn: s.SubmittedOn, ID: s.ID}
+}
+
+// String encodes c into an opaque token, see ParseSubmissionCursor
+func (c *SubmissionCursor) String() string {
+	raw := fmt.Sprintf("%d.%s", c.SubmittedOn.UnixMilli(), c.ID.Hex())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseSubmissionCursor decodes a token returned by SubmissionCursor.String
+func ParseSubmissionCursor(token string) (*SubmissionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	millis, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &SubmissionCursor{SubmittedOn: time.UnixMilli(millis), ID: id}, nil
+}
+
+// AirdropStats summarises the airdrop submissions matching an AirdropSubmissionFilter
+type AirdropStats struct {
+	Total int64 `json:"total" bson:"total"`
+
+	// PerDay counts submissions per day (UTC), oldest first
+	PerDay []DailyCount `json:"per_day" bson:"perDay"`
+
+	// Platforms tells how many submissions completed the task of each platform
+	Platforms []PlatformStats `json:"platforms" bson:"platforms"`
+
+	// Statuses counts submissions per review status
+	Statuses map[SubmissionStatus]int64 `json:"statuses" bson:"statuses"`
+	Flagged  int64                      `json:"flagged" bson:"flagged"`
+}
+
+type DailyCount struct {
+	Date  string `json:"date" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+type PlatformStats struct {
+	Platform    string `json:"platform"`
+	Submissions int64  `json:"submissions"`
+
+	// CompletionRate is the share of all submissions that completed the platform's task, from 0 to 1
+	CompletionRate float64 `json:"completion_rate"`
 }
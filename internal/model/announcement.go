@@ -18,6 +18,9 @@ type Announcement struct {
 	// the announcement
 	ImageUrl string `json:"image_url" bson:"imageUrl" validate:"required"`
 
+	// ThumbnailUrl links to a scaled down version of the image at ImageUrl
+	ThumbnailUrl string `json:"thumbnail_url" bson:"thumbnailUrl"`
+
 	// Body to be used as push notification body, not more than 150 character
 	Body string `json:"text" bson:"text" validate:"max=150"`
 
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Campaign is an airdrop campaign users take part in by completing its tasks
+// and submitting an AirdropSubmission while it is running
+type Campaign struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name" validate:"required"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Tasks       []CampaignTask     `json:"tasks" bson:"tasks" validate:"required,min=1,dive"`
+	StartsOn    time.Time          `json:"starts_on" bson:"startsOn" validate:"required"`
+	EndsOn      time.Time          `json:"ends_on" bson:"endsOn" validate:"required,gtfield=StartsOn"`
+	RewardPool  RewardPool         `json:"reward_pool" bson:"rewardPool"`
+	CreatedOn   time.Time          `json:"created_on" bson:"createdOn"`
+}
+
+// IsRunning reports if submissions to c are accepted at t
+func (c *Campaign) IsRunning(t time.Time) bool {
+	return !t.Before(c.StartsOn) && t.Before(c.EndsOn)
+}
+
+// CampaignTask is something participants must do, e.g., follow HeartNet on Twitter
+type CampaignTask struct {
+	Title       string `json:"title" bson:"title" validate:"required"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+}
+
+// RewardPool is what a campaign pays out to the participants whose submissions are approved
+type RewardPool struct {
+
+	// HrtTokens is the total budget of the campaign
+	HrtTokens int `json:"hrt_tokens" bson:"hrtTokens" validate:"min=0"`
+
+	// HrtTokensPerSubmission and PointsPerSubmission are rewarded per approved submission
+	HrtTokensPerSubmission int `json:"hrt_tokens_per_submission" bson:"hrtTokensPerSubmission" validate:"min=0,ltefield=HrtTokens"`
+	PointsPerSubmission    int `json:"points_per_submission" bson:"pointsPerSubmission" validate:"min=0"`
+
+	// DistributedHrtTokens is the part of HrtTokens already rewarded
+	DistributedHrtTokens int `json:"distributed_hrt_tokens" bson:"distributedHrtTokens"`
+}
@@ -15,6 +15,15 @@ type DBDrug struct {
 	Drug             Drug               `bson:"drug"`
 }
 
+// WithID returns the drug embedded in d, identified by d's ID
+// if the embedded drug has no ID of its own
+func (d *DBDrug) WithID() *Drug {
+	if d.Drug.ID.IsZero() {
+		d.Drug.ID = d.ID
+	}
+	return &d.Drug
+}
+
 type Drug struct {
 	ID             primitive.ObjectID `json:"id" bson:"_id"`
 	Manufacturer   string             `json:"manufacturer" bson:"manufacturer" validate:"required"`
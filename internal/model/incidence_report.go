@@ -6,21 +6,110 @@ import (
 )
 
 type IncidenceReport struct {
-	ID                primitive.ObjectID       `json:"id" bson:"_id,omitempty"`
-	UserID            string                   `json:"user_id" bson:"uid" validate:"required"`
-	PharmacyName      string                   `json:"pharmacy_name" bson:"pharmacyName" validate:"required"`
-	Description       string                   `json:"description" bson:"description" validate:"required"`
-	PharmacyLocation  string                   `json:"pharmacy_location" bson:"pharmacyLocation" validate:"required"`
+	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID           string             `json:"user_id" bson:"uid" validate:"required"`
+	PharmacyName     string             `json:"pharmacy_name" bson:"pharmacyName" validate:"required"`
+	Description      string             `json:"description" bson:"description" validate:"required"`
+	PharmacyLocation string             `json:"pharmacy_location" bson:"pharmacyLocation" validate:"required"`
+
+	// PharmacyID links the report to the pharmacy registry. Reports submitted before
+	// the registry was introduced only have PharmacyName and PharmacyLocation
+	PharmacyID *primitive.ObjectID `json:"pharmacy_id,omitempty" bson:"pharmacyId,omitempty"`
+
+	// Coordinates is where the incidence happened, as reported by the reporter's device,
+	// or else the location of the linked pharmacy if it is known
+	Coordinates *GeoPoint `json:"coordinates,omitempty" bson:"coordinates,omitempty"`
+
+	// DrugName optionally names the drug the report is about
+	DrugName string `json:"drug_name,omitempty" bson:"drugName,omitempty"`
+
+	// BatchNumber optionally identifies the batch of the drug the report is about
+	BatchNumber string `json:"batch_number,omitempty" bson:"batchNumber,omitempty"`
+
+	// ScanID links the report to the scan that prompted it, see Scan.
+	// DrugID, ValidationOption and ValidationData are copied from the scan
+	ScanID            *primitive.ObjectID      `json:"scan_id,omitempty" bson:"scanId,omitempty"`
+	DrugID            *primitive.ObjectID      `json:"drug_id,omitempty" bson:"drugId,omitempty"`
+	ValidationOption  string                   `json:"validation_option,omitempty" bson:"validationOption,omitempty"`
+	ValidationData    string                   `json:"validation_data,omitempty" bson:"validationData,omitempty"`
 	EvidenceImagesUrl []string                 `json:"evidence_images_url" bson:"evidenceImagesUrl" validate:"required"`
 	ReceiptImageUrl   string                   `json:"receipt_image_url" bson:"receiptImageUrl" validate:"required"`
 	SubmittedOn       time.Time                `json:"submitted_on" bson:"submittedOn"`
 	Updates           *[]IncidenceReportUpdate `json:"updates" bson:"updates,omitempty"`
 
+	// EvidenceThumbnailsUrl holds the thumbnail of each image in EvidenceImagesUrl, in the same order
+	EvidenceThumbnailsUrl []string `json:"evidence_thumbnails_url" bson:"evidenceThumbnailsUrl"`
+	ReceiptThumbnailUrl   string   `json:"receipt_thumbnail_url" bson:"receiptThumbnailUrl"`
+
+	// AssignedPartners holds the ids of the partners investigating this report
+	AssignedPartners []primitive.ObjectID `json:"assigned_partners" bson:"assignedPartners,omitempty"`
+
+	// State is the current stage of the report in the investigation workflow.
+	// Reports submitted before the workflow was introduced have no State, see CurrentState
+	State IncidenceReportState `json:"state" bson:"state,omitempty"`
+
+	// Transitions records every change of State, oldest first
+	Transitions []IncidenceReportTransition `json:"transitions" bson:"transitions,omitempty"`
+
+	// ImageFingerprints identify the receipt and evidence images as uploaded, for duplicate detection
+	ImageFingerprints []ImageFingerprint `json:"-" bson:"imageFingerprints,omitempty"`
+
+	// Moderation is set on reports that look like duplicates or spam
+	Moderation *ReportModeration `json:"moderation,omitempty" bson:"moderation,omitempty"`
+
 	// update this field with something similar to
 	// primitive.Timestamp{T:uint32(time.Now().Unix())}
 	UpdatedAt primitive.Timestamp `json:"updated_at" bson:"updatedAt"`
 }
 
+// CurrentState returns the report's State,
+// treating reports submitted before the workflow was introduced as new
+func (report *IncidenceReport) CurrentState() IncidenceReportState {
+	if report.State == "" {
+		return ReportNew
+	}
+	return report.State
+}
+
+// IsAssignedTo reports if the partner identified by partnerId investigates this report
+func (report *IncidenceReport) IsAssignedTo(partnerId primitive.ObjectID) bool {
+	for _, id := range report.AssignedPartners {
+		if id == partnerId {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageKeys returns the blob keys of every image attached to the report
+func (report *IncidenceReport) ImageKeys() []string {
+	keys := []string{report.ReceiptImageUrl, report.ReceiptThumbnailUrl}
+	keys = append(keys, report.EvidenceImagesUrl...)
+	return append(keys, report.EvidenceThumbnailsUrl...)
+}
+
+// ImageFingerprint identifies an image attached to an incidence report
+type ImageFingerprint struct {
+
+	// ContentHash is the sha256 hash of the uploaded file
+	ContentHash string `bson:"contentHash"`
+
+	// PerceptualHash is the hex encoded perceptual hash of the image content.
+	// It is kept as a string because mongo has no unsigned 64 bit integer type
+	PerceptualHash string `bson:"perceptualHash"`
+}
+
+// ReportModeration flags an incidence report for partners to treat with caution
+type ReportModeration struct {
+	Flagged bool `json:"flagged" bson:"flagged"`
+
+	// Reasons describes, in user friendly terms, why the report was flagged
+	Reasons []string `json:"reasons" bson:"reasons"`
+
+	// SimilarReports holds the ids of the reports this report looks like a copy of
+	SimilarReports []primitive.ObjectID `json:"similar_reports" bson:"similarReports"`
+}
+
 type IncidenceReportUpdate struct {
 	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	IncidenceReportID primitive.ObjectID `json:"parent_id" bson:"parent_id" validate:"required"`
@@ -29,4 +118,132 @@ type IncidenceReportUpdate struct {
 
 	// SentBy any official HeartNet partner e.g., NAFDAC
 	SentBy string `json:"sent_by" validate:"required"`
+
+	// FromReporter specifies that the update is a follow-up sent by the reporter
+	// rather than by a partner
+	FromReporter bool `json:"from_reporter" bson:"fromReporter"`
+
+	// State optionally moves the report to a new IncidenceReportState along with this update
+	State  IncidenceReportState `json:"state,omitempty" bson:"state,omitempty"`
+	SentOn time.Time            `json:"sent_on" bson:"sentOn"`
+}
+
+// IncidenceReportFilter narrows down a listing of incidence reports.
+// Zero valued fields are ignored.
+type IncidenceReportFilter struct {
+	State IncidenceReportState
+
+	// PharmacyName, Location and Drug match case-insensitively anywhere in
+	// the report's pharmacy name, pharmacy location and drug name respectively
+	PharmacyName string
+	Location     string
+	Drug         string
+
+	// Search runs a full-text search on the report description
+	Search string
+
+	PharmacyID *primitive.ObjectID
+	DrugID     *primitive.ObjectID
+
+	// Batch matches the report's batch number exactly, ignoring case
+	Batch string
+
+	// Near and RadiusKm restrict the listing to reports within RadiusKm kilometres of Near
+	Near     *GeoPoint
+	RadiusKm float64
+
+	// Flagged, if set, restricts the listing to reports that are, or aren't, flagged for moderation
+	Flagged *bool
+
+	// SubmittedFrom and SubmittedTo bound the submission time, both inclusive
+	SubmittedFrom time.Time
+	SubmittedTo   time.Time
+
+	// Sort is one of IncidenceReportSortFields keys, optionally prefixed with - for descending order.
+	// Defaults to -submitted_on
+	Sort string
+	Pagination
+}
+
+// IncidenceReportSortFields maps the fields incidence report listings can be sorted by to their bson keys
+var IncidenceReportSortFields = map[string]string{
+	"submitted_on":  "submittedOn",
+	"state":         "state",
+	"pharmacy_name": "pharmacyName",
+}
+
+// IncidenceReportState is a stage of the incidence report investigation workflow:
+//
+//	new -> under_investigation -> confirmed_counterfeit
+//	 |              |
+//	 +----> dismissed <---------+
+//
+// A dismissed report can be reopened, i.e., moved back to under_investigation,
+// if new evidence surfaces. confirmed_counterfeit is final.
+type IncidenceReportState string
+
+const (
+	ReportNew                  IncidenceReportState = "new"
+	ReportUnderInvestigation   IncidenceReportState = "under_investigation"
+	ReportConfirmedCounterfeit IncidenceReportState = "confirmed_counterfeit"
+	ReportDismissed            IncidenceReportState = "dismissed"
+)
+
+// IncidenceReportStates lists every valid IncidenceReportState
+var IncidenceReportStates = []IncidenceReportState{
+	ReportNew, ReportUnderInvestigation, ReportConfirmedCounterfeit, ReportDismissed,
+}
+
+var incidenceReportTransitions = map[IncidenceReportState][]IncidenceReportState{
+	ReportNew:                  {ReportUnderInvestigation, ReportDismissed},
+	ReportUnderInvestigation:   {ReportConfirmedCounterfeit, ReportDismissed},
+	ReportDismissed:            {ReportUnderInvestigation},
+	ReportConfirmedCounterfeit: {},
+}
+
+func (s IncidenceReportState) IsValid() bool {
+	_, ok := incidenceReportTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports if the workflow allows a report to move from s to next
+func (s IncidenceReportState) CanTransitionTo(next IncidenceReportState) bool {
+	for _, allowed := range incidenceReportTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOpen reports if a report in state s is still awaiting a verdict
+func (s IncidenceReportState) IsOpen() bool {
+	return s == ReportNew || s == ReportUnderInvestigation
+}
+
+// Description returns a user friendly description of s
+func (s IncidenceReportState) Description() string {
+	switch s {
+	case ReportNew:
+		return "received and awaiting review"
+	case ReportUnderInvestigation:
+		return "under investigation"
+	case ReportConfirmedCounterfeit:
+		return "confirmed to involve a counterfeit drug"
+	case ReportDismissed:
+		return "dismissed"
+	default:
+		return string(s)
+	}
+}
+
+// IncidenceReportTransition records a change of an incidence report's state
+type IncidenceReportTransition struct {
+	From IncidenceReportState `json:"from,omitempty" bson:"from,omitempty"`
+	To   IncidenceReportState `json:"to" bson:"to"`
+
+	// Actor is the name of whoever triggered the transition, e.g., a partner's name
+	Actor   string             `json:"actor" bson:"actor"`
+	ActorID primitive.ObjectID `json:"actor_id,omitempty" bson:"actorId,omitempty"`
+	At      time.Time          `json:"at" bson:"at"`
 }
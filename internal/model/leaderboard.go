@@ -0,0 +1,120 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LeaderboardPeriod is the span of time a leaderboard ranks users over
+type LeaderboardPeriod string
+
+const (
+	LeaderboardWeekly  LeaderboardPeriod = "weekly"
+	LeaderboardAllTime LeaderboardPeriod = "all_time"
+)
+
+func (p LeaderboardPeriod) IsValid() bool {
+	return p == LeaderboardWeekly || p == LeaderboardAllTime
+}
+
+// Key returns the key the scores of period p that include t are stored under,
+// weekly scores are kept per ISO week, e.g., 2026-W42
+func (p LeaderboardPeriod) Key(t time.Time) string {
+	if p == LeaderboardAllTime {
+		return string(LeaderboardAllTime)
+	}
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// LeaderboardMetric is what a leaderboard ranks users by
+type LeaderboardMetric string
+
+const (
+	// MetricScans counts the distinct unexpired drugs a user validated with their session
+	MetricScans LeaderboardMetric = "scans"
+
+	// MetricConfirmedReports counts the incidence reports confirmed to involve a counterfeit drug
+	MetricConfirmedReports LeaderboardMetric = "confirmed_reports"
+
+	// MetricPoints sums the points of the user's rewards
+	MetricPoints LeaderboardMetric = "points"
+)
+
+// LeaderboardMetrics lists every valid LeaderboardMetric
+var LeaderboardMetrics = []LeaderboardMetric{MetricScans, MetricConfirmedReports, MetricPoints}
+
+func (m LeaderboardMetric) IsValid() bool {
+	for _, metric := range LeaderboardMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// Field returns the bson key of LeaderboardScore holding metric m
+func (m LeaderboardMetric) Field() string {
+	switch m {
+	case MetricConfirmedReports:
+		return "confirmedReports"
+	default:
+		return string(m)
+	}
+}
+
+// LeaderboardScore is a user's tally for every metric over one period,
+// it is updated as users validate drugs, have reports confirmed and earn rewards
+type LeaderboardScore struct {
+	UserID string `bson:"uid"`
+
+	// Period is the key of the period, see LeaderboardPeriod.Key
+	Period           string    `bson:"period"`
+	Scans            int       `bson:"scans"`
+	ConfirmedReports int       `bson:"confirmedReports"`
+	Points           int       `bson:"points"`
+	UpdatedOn        time.Time `bson:"updatedOn"`
+}
+
+// Score returns the tally of metric
+func (s *LeaderboardScore) Score(metric LeaderboardMetric) int {
+	switch metric {
+	case MetricScans:
+		return s.Scans
+	case MetricConfirmedReports:
+		return s.ConfirmedReports
+	case MetricPoints:
+		return s.Points
+	default:
+		return 0
+	}
+}
+
+// LeaderboardEntry is a user's position on a leaderboard. UserID is masked, see MaskUID
+type LeaderboardEntry struct {
+	Rank   int    `json:"rank"`
+	UserID string `json:"user_id"`
+	Score  int    `json:"score"`
+}
+
+// Leaderboard ranks users by Metric over Period, highest scores first.
+// Ties are broken by uid so that ranks are stable
+type Leaderboard struct {
+	Period  LeaderboardPeriod  `json:"period"`
+	Metric  LeaderboardMetric  `json:"metric"`
+	Week    string             `json:"week,omitempty"`
+	Entries []LeaderboardEntry `json:"entries"`
+
+	// You is the requesting user's own position, nil if they haven't scored yet
+	You *LeaderboardEntry `json:"you,omitempty"`
+}
+
+// MaskUID hides most of uid so that leaderboards can't be used to harvest user ids,
+// e.g., A7K2Q9 becomes A7***9
+func MaskUID(uid string) string {
+	if len(uid) <= 3 {
+		return strings.Repeat("*", len(uid))
+	}
+	return uid[:2] + strings.Repeat("*", len(uid)-3) + uid[len(uid)-1:]
+}
@@ -81,11 +81,44 @@ func NewIncidenceReportNotification(userId string) *Notification {
 	return notification
 }
 
+// NewIncidenceReportStateNotification notifies the reporter that their
+// incidence report was moved to state
+func NewIncidenceReportStateNotification(userId string, state IncidenceReportState) *Notification {
+	notification := &Notification{
+		UserID:  userId,
+		Title:   "Incidence Report Update",
+		Message: fmt.Sprintf("Your incidence report is now %s.", state.Description()),
+		IsRead:  false,
+		Sent:    time.Now(),
+	}
+	notification.InsertID()
+	return notification
+}
+
 func NewTaskReportNotification(userId string) *Notification {
 	notification := &Notification{
 		UserID:  userId,
 		Title:   "Participation Recorded",
-		Message: "Thank you for participating in our airdrop program. Your submission has been recorded and the rewards will be distributed as at when due",
+		Message: "Thank you for participating in our airdrop program. Your submission has been recorded and will be reviewed shortly",
+		IsRead:  false,
+		Sent:    time.Now(),
+	}
+	notification.InsertID()
+	return notification
+}
+
+// NewSubmissionApprovedNotification notifies the user that their airdrop submission was approved.
+// reward is nil if the submission earned no reward
+func NewSubmissionApprovedNotification(userId string, reward *Reward) *Notification {
+	message := "Your airdrop submission has been approved."
+	if reward != nil {
+		message = fmt.Sprintf("Your airdrop submission has been approved. You earned %d HRT tokens and %d points.",
+			reward.HrtTokens, reward.Points)
+	}
+	notification := &Notification{
+		UserID:  userId,
+		Title:   "Airdrop Submission Approved",
+		Message: message,
 		IsRead:  false,
 		Sent:    time.Now(),
 	}
@@ -93,6 +126,33 @@ func NewTaskReportNotification(userId string) *Notification {
 	return notification
 }
 
+// NewSubmissionRejectedNotification notifies the user that their airdrop submission was rejected for reason
+func NewSubmissionRejectedNotification(userId, reason string) *Notification {
+	notification := &Notification{
+		UserID:  userId,
+		Title:   "Airdrop Submission Rejected",
+		Message: fmt.Sprintf("Your airdrop submission has been rejected: %s", reason),
+		IsRead:  false,
+		Sent:    time.Now(),
+	}
+	notification.InsertID()
+	return notification
+}
+
+// NewReferralRewardNotification notifies the user that someone they invited completed a first drug validation
+func NewReferralRewardNotification(userId string, reward *Reward) *Notification {
+	notification := &Notification{
+		UserID: userId,
+		Title:  "Referral Reward",
+		Message: fmt.Sprintf("Someone you invited to HeartNet validated their first drug. "+
+			"You earned %d HRT tokens and %d points.", reward.HrtTokens, reward.Points),
+		IsRead: false,
+		Sent:   time.Now(),
+	}
+	notification.InsertID()
+	return notification
+}
+
 // NewValidationNotification generates a validation notification.
 func NewValidationNotification(userId, validationResult string) *Notification {
 	notification := &Notification{
@@ -0,0 +1,65 @@
+package model
+
+import "math"
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Pagination specifies the page of a listing to fetch.
+// Page is 1-based.
+type Pagination struct {
+	Page     int
+	PageSize int
+}
+
+// Validate fills in defaults for unset values and returns a map of
+// field-level errors, which is empty if p is valid
+func (p *Pagination) Validate() map[string]string {
+	errs := make(map[string]string)
+	if p.Page == 0 {
+		p.Page = 1
+	}
+	if p.PageSize == 0 {
+		p.PageSize = DefaultPageSize
+	}
+	if p.Page < 1 || p.Page > 10_000_000 {
+		errs["page"] = "must be between 1 and 10 million"
+	}
+	if p.PageSize < 1 || p.PageSize > MaxPageSize {
+		errs["page_size"] = "must be between 1 and 100"
+	}
+	return errs
+}
+
+func (p Pagination) Skip() int64 {
+	return int64((p.Page - 1) * p.PageSize)
+}
+
+func (p Pagination) Limit() int64 {
+	return int64(p.PageSize)
+}
+
+// Metadata describes a page of a listing
+type Metadata struct {
+	CurrentPage  int   `json:"current_page,omitempty"`
+	PageSize     int   `json:"page_size,omitempty"`
+	FirstPage    int   `json:"first_page,omitempty"`
+	LastPage     int   `json:"last_page,omitempty"`
+	TotalRecords int64 `json:"total_records"`
+}
+
+// NewMetadata describes the page p of a listing containing totalRecords
+func NewMetadata(totalRecords int64, p Pagination) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+	return Metadata{
+		CurrentPage:  p.Page,
+		PageSize:     p.PageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(p.PageSize))),
+		TotalRecords: totalRecords,
+	}
+}
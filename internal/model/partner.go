@@ -0,0 +1,26 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Partner is an official HeartNet partner, e.g., NAFDAC, that investigates incidence reports.
+// Partners authenticate with an api key issued once at registration.
+type Partner struct {
+	ID    primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name  string             `json:"name" bson:"name" validate:"required,max=100"`
+	Email string             `json:"email" bson:"email" validate:"required,email"`
+
+	// APIKeyHash is the sha256 hash of the partner's api key. The api key itself is never stored
+	APIKeyHash string    `json:"-" bson:"apiKeyHash"`
+	CreatedOn  time.Time `json:"created_on" bson:"createdOn"`
+}
+
+// HashAPIKey returns the hash under which key is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	DefaultSearchRadiusKm = 10.0
+	MaxSearchRadiusKm     = 500.0
+)
+
+// GeoPoint is a GeoJSON point, the format mongo geospatial queries work with.
+// Coordinates hold the longitude first, then the latitude
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+// NewGeoPoint returns the GeoPoint at latitude lat and longitude lng
+func NewGeoPoint(lat, lng float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// ValidCoordinates reports if lat and lng are a valid latitude and longitude
+func ValidCoordinates(lat, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// PharmacyStatus tells whether a pharmacy is known to HeartNet
+type PharmacyStatus string
+
+const (
+	// PharmacyRegistered pharmacies were added, or vetted, by an admin
+	PharmacyRegistered PharmacyStatus = "registered"
+
+	// PharmacySuggested pharmacies were named by reporters when submitting an incidence report
+	// and are yet to be vetted by an admin
+	PharmacySuggested PharmacyStatus = "suggested"
+)
+
+// Pharmacy is an entry of the pharmacy registry. Incidence reports link to the
+// pharmacy they are about so that reports on the same pharmacy can be aggregated
+type Pharmacy struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name          string             `json:"name" bson:"name"`
+	Address       string             `json:"address" bson:"address"`
+	Location      *GeoPoint          `json:"location,omitempty" bson:"location,omitempty"`
+	LicenceNumber string             `json:"licence_number,omitempty" bson:"licenceNumber,omitempty"`
+	Status        PharmacyStatus     `json:"status" bson:"status"`
+
+	// SuggestedBy is the uid of the reporter who first named a suggested pharmacy
+	SuggestedBy string `json:"-" bson:"suggestedBy,omitempty"`
+
+	// SearchKey is the normalised name and address of the pharmacy, see PharmacySearchKey
+	SearchKey string    `json:"-" bson:"searchKey"`
+	CreatedOn time.Time `json:"created_on" bson:"createdOn"`
+}
+
+// PharmacySearchKey normalises a pharmacy's name and address so that differently punctuated spellings,
+// e.g., "Health-Plus Pharmacy, Wuse II" and "health plus pharmacy  wuse ii", share the same key.
+// Letters are lowercased and runs of anything other than letters and digits collapse into a space
+func PharmacySearchKey(name, address string) string {
+	fields := strings.FieldsFunc(strings.ToLower(name+" "+address), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+// PharmacyFilter narrows down a listing of pharmacies.
+// Zero valued fields are ignored.
+type PharmacyFilter struct {
+
+	// Search matches anywhere in the pharmacy's name or address
+	Search string
+	Status PharmacyStatus
+
+	// Near and RadiusKm restrict the listing to pharmacies within RadiusKm kilometres of Near
+	Near     *GeoPoint
+	RadiusKm float64
+	Pagination
+}
+
+// PharmacyHotspot aggregates the incidence reports submitted about a pharmacy
+type PharmacyHotspot struct {
+	Pharmacy       Pharmacy  `json:"pharmacy" bson:"pharmacy"`
+	ReportCount    int       `json:"report_count" bson:"reportCount"`
+	OpenCount      int       `json:"open_count" bson:"openCount"`
+	ConfirmedCount int       `json:"confirmed_count" bson:"confirmedCount"`
+	LastReportedOn time.Time `json:"last_reported_on" bson:"lastReportedOn"`
+}
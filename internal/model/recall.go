@@ -0,0 +1,22 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Recall withdraws a batch of a drug from circulation.
+// Recalls are issued by partners and broadcast to partner webhooks
+type Recall struct {
+	ID           primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	DrugName     string              `json:"drug_name" bson:"drugName" validate:"required,max=200"`
+	Manufacturer string              `json:"manufacturer,omitempty" bson:"manufacturer,omitempty" validate:"max=200"`
+	BatchNumber  string              `json:"batch_number" bson:"batchNumber" validate:"required,max=100"`
+	DrugID       *primitive.ObjectID `json:"drug_id,omitempty" bson:"drugId,omitempty"`
+	Reason       string              `json:"reason" bson:"reason" validate:"required,max=2000"`
+
+	// IssuedBy is the name of the partner that issued the recall
+	IssuedBy   string             `json:"issued_by" bson:"issuedBy"`
+	IssuedByID primitive.ObjectID `json:"issued_by_id" bson:"issuedById"`
+	IssuedOn   time.Time          `json:"issued_on" bson:"issuedOn"`
+}
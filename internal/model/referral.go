@@ -0,0 +1,61 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+// ReferralStatus tells whether the referrer of a referral was rewarded
+type ReferralStatus string
+
+const (
+	// ReferralPending referrals wait for the invitee to complete a first drug validation
+	ReferralPending ReferralStatus = "pending"
+
+	// ReferralRewarded referrals earned the referrer a reward
+	ReferralRewarded ReferralStatus = "rewarded"
+
+	// ReferralUnrewarded referrals were completed by the invitee but earned nothing,
+	// see Referral.Reason
+	ReferralUnrewarded ReferralStatus = "unrewarded"
+)
+
+// Referral is an edge of the referral graph: the user identified by ReferrerID
+// brought in the user identified by InviteeID. A user is referred at most once
+type Referral struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ReferrerID string             `json:"referrer_id" bson:"referrerId"`
+	InviteeID  string             `json:"invitee_id" bson:"inviteeId"`
+
+	// Code is the referral code the invitee signed up with
+	Code   string         `json:"code" bson:"code"`
+	Status ReferralStatus `json:"status" bson:"status"`
+
+	// Reason explains why an unrewarded referral earned nothing
+	Reason   string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	RewardID primitive.ObjectID `json:"reward_id,omitempty" bson:"rewardId,omitempty"`
+
+	// IPAddress and DeviceID are where the invitee signed up from, see Referral.Reason
+	IPAddress string `json:"-" bson:"ipAddress,omitempty"`
+	DeviceID  string `json:"-" bson:"deviceId,omitempty"`
+
+	CreatedOn   time.Time  `json:"created_on" bson:"createdOn"`
+	CompletedOn *time.Time `json:"completed_on,omitempty" bson:"completedOn,omitempty"`
+}
+
+// ReferralSummary is a user's referral code along with how their referrals fared
+type ReferralSummary struct {
+	Code       string `json:"referral_code"`
+	Pending    int    `json:"pending"`
+	Rewarded   int    `json:"rewarded"`
+	Unrewarded int    `json:"unrewarded"`
+
+	// Referrals are the user's most recent referrals
+	Referrals []Referral `json:"referrals"`
+}
+
+// NormaliseReferralCode returns code as it is stored, codes are case-insensitive
+func NormaliseReferralCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -1,9 +1,18 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
 
+// Reward is what a user earned, e.g., for an approved airdrop submission or a referral
 type Reward struct {
-	ID        primitive.ObjectID `json:"id" bson:"id"`
-	Points    int                `json:"points" bson:"points"`
-	HrtTokens int                `json:"hrt_tokens" bson:"hrt_tokens"`
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID       string             `json:"user_id" bson:"uid"`
+	CampaignID   primitive.ObjectID `json:"campaign_id,omitempty" bson:"campaignId,omitempty"`
+	SubmissionID primitive.ObjectID `json:"submission_id,omitempty" bson:"submissionId,omitempty"`
+	ReferralID   primitive.ObjectID `json:"referral_id,omitempty" bson:"referralId,omitempty"`
+	Points       int                `json:"points" bson:"points"`
+	HrtTokens    int                `json:"hrt_tokens" bson:"hrt_tokens"`
+	RecordedOn   time.Time          `json:"recorded_on" bson:"recordedOn"`
 }
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// ScanResult is the outcome of validating a drug, as reported to the user
+type ScanResult string
+
+const (
+	ScanSafe    ScanResult = "safe"
+	ScanExpired ScanResult = "expired"
+	ScanUnsafe  ScanResult = "Unsafe"
+)
+
+// Scan records a drug validation request, i.e., a user scanning a drug's
+// QR code, RFID tag or short code. Incidence reports filed after a scan link to it
+type Scan struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID string             `json:"user_id" bson:"uid"`
+
+	// ValidationOption is one of RFID, QrCode or ShortCode
+	ValidationOption string `json:"validation_option" bson:"validationOption"`
+
+	// ValidationData is the text read from the drug
+	ValidationData string `json:"validation_data" bson:"validationData"`
+
+	// DrugID, DrugName and BatchNumber describe the drug the scan matched, if any
+	DrugID      *primitive.ObjectID `json:"drug_id,omitempty" bson:"drugId,omitempty"`
+	DrugName    string              `json:"drug_name,omitempty" bson:"drugName,omitempty"`
+	BatchNumber string              `json:"batch_number,omitempty" bson:"batchNumber,omitempty"`
+	Result      ScanResult          `json:"result" bson:"result"`
+	ScannedOn   time.Time           `json:"scanned_on" bson:"scannedOn"`
+}
+
+// DrugReportGroup aggregates the incidence reports about a batch of a drug
+type DrugReportGroup struct {
+	DrugName    string `json:"drug_name" bson:"drugName"`
+	BatchNumber string `json:"batch_number" bson:"batchNumber"`
+
+	// DrugIDs holds the ids of the matched drugs, for reports filed after a scan
+	DrugIDs        []primitive.ObjectID `json:"drug_ids" bson:"drugIds"`
+	ReportCount    int                  `json:"report_count" bson:"reportCount"`
+	OpenCount      int                  `json:"open_count" bson:"openCount"`
+	ConfirmedCount int                  `json:"confirmed_count" bson:"confirmedCount"`
+	LastReportedOn time.Time            `json:"last_reported_on" bson:"lastReportedOn"`
+}
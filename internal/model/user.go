@@ -16,23 +16,59 @@ type User struct {
 	Email                 string    `json:"email" bson:"email"`
 	DateOfBirth           time.Time `json:"dob" bson:"dob"`
 	PushNotificationToken string    `json:"push_notification_token" bson:"pushNotificationToken"`
+
+	// WalletVerifiedOn is when the user proved they own WalletAddress by signing a WalletChallenge.
+	// It is nil for wallets linked before ownership proofs were required
+	WalletVerifiedOn *time.Time `json:"wallet_verified_on,omitempty" bson:"walletVerifiedOn,omitempty"`
+
+	// EmailVerifiedOn is when the user proved they own Email with a one-time code.
+	// Only verified emails can be used to recover an account
+	EmailVerifiedOn *time.Time `json:"email_verified_on,omitempty" bson:"emailVerifiedOn,omitempty"`
+
+	// FirstSessionOn is when the user's first session was issued, see Session
+	FirstSessionOn *time.Time `json:"-" bson:"firstSessionOn,omitempty"`
+
+	// ReferralCode is the code the user shares to invite others, see Referral
+	ReferralCode string `json:"referral_code,omitempty" bson:"referralCode,omitempty"`
+
+	// ReferralRewards counts the referrals the user was rewarded for
+	ReferralRewards int `json:"-" bson:"referralRewards,omitempty"`
+
+	// Version is incremented whenever the user's profile changes. Clients send back the version
+	// they read when patching a user, so that concurrent updates aren't silently overwritten
+	Version int `json:"version" bson:"version"`
+}
+
+// MinUserAge is the age, in years, a user must have reached to set their date of birth
+const MinUserAge = 13
+
+// UserPatch is a partial update of a user's profile.
+// Set and Unset are keyed by the bson keys of the fields to set and to remove
+type UserPatch struct {
+	Set   map[string]interface{}
+	Unset []string
+}
+
+// IsEmpty reports if p changes nothing
+func (p *UserPatch) IsEmpty() bool {
+	return len(p.Set) == 0 && len(p.Unset) == 0
 }
 
-// ToMap with bson tag equivalent keys, excluding entry for UID.
-// and any other field that has nil/zero value at conversion time
-func (u *User) ToMap() map[string]interface{} {
-	m := make(map[string]interface{})
-	if u.WalletAddress != "" {
-		m["walletAddr"] = u.WalletAddress
-	}
-	if u.Email != "" {
-		m["email"] = u.Email
-	}
-	if !u.DateOfBirth.IsZero() {
-		m["dob"] = u.DateOfBirth
-	}
-	if u.PushNotificationToken != "" {
-		m["pushNotificationToken"] = u.PushNotificationToken
-	}
-	return m
+// UserFieldChange is the change of a field of a user's profile.
+// From and To are omitted when the field was unset, or for fields whose values aren't kept
+type UserFieldChange struct {
+	Field string      `json:"field" bson:"field"`
+	From  interface{} `json:"from,omitempty" bson:"from,omitempty"`
+	To    interface{} `json:"to,omitempty" bson:"to,omitempty"`
+}
+
+// UserChange is an entry of the change history of a user's profile
+type UserChange struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID string             `json:"-" bson:"uid"`
+
+	// Version is the version of the user the change produced
+	Version   int               `json:"version" bson:"version"`
+	Changes   []UserFieldChange `json:"changes" bson:"changes"`
+	ChangedOn time.Time         `json:"changed_on" bson:"changedOn"`
 }
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// WalletChallenge is the message a user signs with a wallet to prove they own it
+// before it is linked to their account. A challenge can only be answered once
+type WalletChallenge struct {
+	ID      primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID  string             `json:"-" bson:"uid"`
+	Address string             `json:"address" bson:"address"`
+
+	// Message is the exact text the wallet must sign, it embeds a random nonce
+	Message   string    `json:"message" bson:"message"`
+	CreatedOn time.Time `json:"created_on" bson:"createdOn"`
+	ExpiresOn time.Time `json:"expires_on" bson:"expiresOn"`
+}
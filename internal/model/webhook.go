@@ -0,0 +1,112 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// WebhookEventType names an event partners can subscribe to
+type WebhookEventType string
+
+const (
+	EventIncidenceReportCreated      WebhookEventType = "incidence_report.created"
+	EventIncidenceReportStateChanged WebhookEventType = "incidence_report.state_changed"
+	EventRecallIssued                WebhookEventType = "recall.issued"
+)
+
+// WebhookEventTypes lists every valid WebhookEventType
+var WebhookEventTypes = []WebhookEventType{
+	EventIncidenceReportCreated, EventIncidenceReportStateChanged, EventRecallIssued,
+}
+
+func (t WebhookEventType) IsValid() bool {
+	for _, eventType := range WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook is a url a partner registered to receive events on.
+// Deliveries are signed with Secret, which is shared with the partner once at registration
+type Webhook struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	PartnerID primitive.ObjectID `json:"partner_id" bson:"partnerId"`
+	URL       string             `json:"url" bson:"url"`
+	Secret    string             `json:"-" bson:"secret"`
+
+	// Events holds the event types the webhook is subscribed to
+	Events    []WebhookEventType `json:"events" bson:"events"`
+	CreatedOn time.Time          `json:"created_on" bson:"createdOn"`
+}
+
+// Subscribes reports if webhook receives events of type eventType
+func (webhook *Webhook) Subscribes(eventType WebhookEventType) bool {
+	for _, event := range webhook.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is the JSON body of a webhook delivery
+type WebhookEvent struct {
+	ID        primitive.ObjectID `json:"id"`
+	Type      WebhookEventType   `json:"type"`
+	CreatedOn time.Time          `json:"created_on"`
+	Data      interface{}        `json:"data"`
+}
+
+// DeliveryStatus is the stage of a webhook delivery
+type DeliveryStatus string
+
+const (
+	// DeliveryPending deliveries are awaiting their first attempt or a retry
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+
+	// DeliveryFailed deliveries ran out of attempts. They can be replayed
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is the delivery of an event to a webhook, along with the log of its attempts
+type WebhookDelivery struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	WebhookID primitive.ObjectID `json:"webhook_id" bson:"webhookId"`
+	PartnerID primitive.ObjectID `json:"partner_id" bson:"partnerId"`
+	EventID   primitive.ObjectID `json:"event_id" bson:"eventId"`
+	EventType WebhookEventType   `json:"event_type" bson:"eventType"`
+
+	// Payload is the JSON encoded WebhookEvent, kept as sent so that replays are identical
+	Payload string         `json:"payload" bson:"payload"`
+	Status  DeliveryStatus `json:"status" bson:"status"`
+
+	// NextAttemptAt is when the delivery is next due, while it is pending
+	NextAttemptAt time.Time         `json:"next_attempt_at" bson:"nextAttemptAt"`
+	Attempts      []DeliveryAttempt `json:"attempts" bson:"attempts"`
+
+	// ReplayOf is the id of the delivery this delivery replays, if any
+	ReplayOf    *primitive.ObjectID `json:"replay_of,omitempty" bson:"replayOf,omitempty"`
+	CreatedOn   time.Time           `json:"created_on" bson:"createdOn"`
+	DeliveredOn *time.Time          `json:"delivered_on,omitempty" bson:"deliveredOn,omitempty"`
+}
+
+// DeliveryAttempt logs an attempt to deliver a webhook event
+type DeliveryAttempt struct {
+	At time.Time `json:"at" bson:"at"`
+
+	// StatusCode is the status code the webhook responded with, 0 if the request failed
+	StatusCode int    `json:"status_code" bson:"statusCode"`
+	Error      string `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms" bson:"durationMs"`
+}
+
+// WebhookDeliveryFilter narrows down a listing of a webhook's deliveries.
+// Zero valued fields are ignored.
+type WebhookDeliveryFilter struct {
+	WebhookID primitive.ObjectID
+	Status    DeliveryStatus
+	Pagination
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStore keeps blobs on the local file system.
+// Note that files stored on Heroku dynos are lost on every restart,
+// so LocalStore should only be used for local development.
+type LocalStore struct {
+
+	// root is the directory under which all blobs are stored
+	root string
+
+	// baseUrl is the url through which the api serves files under root
+	baseUrl string
+
+	signer *Signer
+}
+
+// NewLocalStore creates root if it doesn't exist yet.
+// baseUrl is the url of the endpoint serving files under root, e.g., http://127.0.0.1:4041/api/res/images
+func NewLocalStore(root, baseUrl string, signer *Signer) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, errors.Wrap(err, "error creating local store root directory")
+	}
+	return &LocalStore{
+		root:    root,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		signer:  signer,
+	}, nil
+}
+
+// Path resolves key into a file path confined to the store's root directory.
+// Returns ErrInvalidKey if key is empty or escapes root, e.g., ../../etc/passwd
+func (s *LocalStore) Path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "\x00") {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, data io.Reader, size int64, contentType string) error {
+	filePath, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return errors.Wrap(err, "error creating blob directory")
+	}
+
+	out, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "error creating blob file")
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, data); err != nil {
+		os.Remove(filePath)
+		return errors.Wrap(err, "error writing blob file")
+	}
+	return nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (Object, *ObjectInfo, error) {
+	filePath, err := s.Path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, errors.Wrap(err, "error opening blob file")
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, errors.Wrap(err, "error reading blob file info")
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, nil, ErrNotFound
+	}
+
+	return file, &ObjectInfo{
+		Key:         key,
+		Size:        stat.Size(),
+		ContentType: mime.TypeByExtension(filepath.Ext(filePath)),
+		ModTime:     stat.ModTime(),
+		ETag:        localETag(filePath, stat),
+	}, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	filePath, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "error deleting blob file")
+	}
+	return nil
+}
+
+func (s *LocalStore) URL(key string) string {
+	return fmt.Sprintf("%s/%s", s.baseUrl, key)
+}
+
+func (s *LocalStore) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	query := s.signer.Sign(key, time.Now().Add(expiry))
+	return fmt.Sprintf("%s/%s?%s", s.baseUrl, key, query.Encode()), nil
+}
+
+// localETag derives an ETag from file's path, size and modification time,
+// which is cheap and changes whenever the file is replaced.
+func localETag(filePath string, stat os.FileInfo) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s:%d:%d", filePath, stat.Size(), stat.ModTime().UnixNano())))
+	return hex.EncodeToString(sum[:])
+}
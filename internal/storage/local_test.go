@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocalStore(t *testing.T) (*LocalStore, *Signer) {
+	signer := NewSigner("secret")
+	store, err := NewLocalStore(filepath.Join(t.TempDir(), "images"), "http://127.0.0.1:4041/api/res/images/", signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, signer
+}
+
+func TestLocalStore(t *testing.T) {
+	store, _ := newTestLocalStore(t)
+	testBlobStore(t, store)
+}
+
+func TestLocalStorePath(t *testing.T) {
+	store, _ := newTestLocalStore(t)
+	tests := []struct {
+		key  string
+		want string
+		err  error
+	}{
+		{"announcements/1652_promo.jpg", "announcements/1652_promo.jpg", nil},
+		{"/announcements/1652_promo.jpg", "announcements/1652_promo.jpg", nil},
+		{"../../etc/passwd", "etc/passwd", nil},
+		{"announcements/../../../etc/passwd", "etc/passwd", nil},
+		{"", "", ErrInvalidKey},
+		{"/", "", ErrInvalidKey},
+		{"..", "", ErrInvalidKey},
+		{"announcements/\x00.jpg", "", ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		got, err := store.Path(tt.key)
+		if err != tt.err {
+			t.Errorf("Path(%q) error = %v, want %v", tt.key, err, tt.err)
+			continue
+		}
+		if tt.err == nil && got != filepath.Join(store.root, filepath.FromSlash(tt.want)) {
+			t.Errorf("Path(%q) = %s, want %s under the root", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestLocalStorePermissions(t *testing.T) {
+	store, _ := newTestLocalStore(t)
+	key := "incidence_reports/receipts/uid_1652000000.jpg"
+	if err := store.Put(context.Background(), key, strings.NewReader("receipt"), 7, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	filePath, _ := store.Path(key)
+	for path, want := range map[string]os.FileMode{
+		store.root:             0700,
+		filepath.Dir(filePath): 0700,
+		filePath:               0600,
+	} {
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stat.Mode().Perm(); got&^want != 0 {
+			t.Errorf("%s has mode %o, want at most %o", path, got, want)
+		}
+	}
+}
+
+func TestLocalStoreURLs(t *testing.T) {
+	store, signer := newTestLocalStore(t)
+	key := "incidence_reports/evidence/uid_1652000000/1.jpg"
+
+	if got, want := store.URL(key), "http://127.0.0.1:4041/api/res/images/"+key; got != want {
+		t.Errorf("URL() = %s, want %s", got, want)
+	}
+
+	signed, err := store.SignedURL(context.Background(), key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "http://127.0.0.1:4041/api/res/images/" + key + "?"
+	if !strings.HasPrefix(signed, prefix) {
+		t.Fatalf("SignedURL() = %s, want a url starting with %s", signed, prefix)
+	}
+	query, err := url.ParseQuery(strings.TrimPrefix(signed, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Verify(key, query); err != nil {
+		t.Errorf("Verify() of SignedURL() error = %v", err)
+	}
+}
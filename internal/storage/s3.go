@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3Store.
+// Any S3 compatible object store, e.g., AWS S3, MinIO, DigitalOcean Spaces, can be used.
+type S3Config struct {
+
+	// Endpoint is the host (and optionally port) of the object store, e.g., s3.amazonaws.com or 127.0.0.1:9000
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	Bucket          string
+	Region          string
+	UseSSL          bool
+
+	// PublicBaseUrl optionally overrides the base url of public blobs,
+	// e.g., a CDN in front of the bucket.
+	// Defaults to a path-style url on Endpoint.
+	PublicBaseUrl string
+
+	// PublicPrefixes are the key prefixes of public blobs, e.g., announcements/.
+	// Without PublicBaseUrl, the bucket's policy is replaced with one letting anyone read the blobs
+	// under these prefixes, so that their path-style urls can be fetched. Every other blob stays private
+	PublicPrefixes []string
+}
+
+// ErrNoPublicAccess is returned when an S3Store is configured with no way to read public blobs,
+// since the bucket is private
+var ErrNoPublicAccess = errors.New("either a public base url or public prefixes must be set")
+
+// S3Store keeps blobs in a bucket of an S3 compatible object store.
+// Path-style addressing is used so that local stand-ins like MinIO work without DNS setup.
+type S3Store struct {
+	client        *minio.Client
+	bucket        string
+	publicBaseUrl string
+}
+
+// NewS3Store connects to the object store and creates the bucket if it doesn't exist yet.
+// Without cfg.PublicBaseUrl, the bucket's policy is set to let anyone read public blobs, see S3Config.
+// Returns ErrNoPublicAccess if cfg sets neither PublicBaseUrl nor PublicPrefixes
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.PublicBaseUrl == "" && len(cfg.PublicPrefixes) == 0 {
+		return nil, ErrNoPublicAccess
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating object store client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, errors.Wrap(err, "error checking object store bucket")
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, errors.Wrap(err, "error creating object store bucket")
+		}
+	}
+
+	publicBaseUrl := cfg.PublicBaseUrl
+	if publicBaseUrl == "" {
+		if err := client.SetBucketPolicy(ctx, cfg.Bucket, publicReadPolicy(cfg.Bucket, cfg.PublicPrefixes)); err != nil {
+			return nil, errors.Wrap(err, "error setting the public read policy of the object store bucket, "+
+				"allow public bucket policies or set a public base url")
+		}
+
+		scheme := "http"
+		if cfg.UseSSL {
+			scheme = "https"
+		}
+		publicBaseUrl = fmt.Sprintf("%s://%s/%s", scheme, cfg.Endpoint, cfg.Bucket)
+	}
+
+	return &S3Store{
+		client:        client,
+		bucket:        cfg.Bucket,
+		publicBaseUrl: strings.TrimSuffix(publicBaseUrl, "/"),
+	}, nil
+}
+
+// publicReadPolicy returns a bucket policy letting anyone read the blobs of bucket under prefixes
+func publicReadPolicy(bucket string, prefixes []string) string {
+	resources := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		resources = append(resources, fmt.Sprintf("arn:aws:s3:::%s/%s*", bucket, prefix))
+	}
+	policy, _ := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect":    "Allow",
+			"Principal": map[string][]string{"AWS": {"*"}},
+			"Action":    []string{"s3:GetObject"},
+			"Resource":  resources,
+		}},
+	})
+	return string(policy)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, data, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error uploading blob to object store")
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (Object, *ObjectInfo, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error fetching blob from object store")
+	}
+
+	// GetObject is lazy, errors such as a missing key only surface on the first read or stat
+	stat, err := object.Stat()
+	if err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, errors.Wrap(err, "error reading blob info from object store")
+	}
+
+	return object, &ObjectInfo{
+		Key:         key,
+		Size:        stat.Size,
+		ContentType: stat.ContentType,
+		ModTime:     stat.LastModified,
+		ETag:        strings.Trim(stat.ETag, `"`),
+	}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return errors.Wrap(err, "error deleting blob from object store")
+	}
+	return nil
+}
+
+func (s *S3Store) URL(key string) string {
+	return fmt.Sprintf("%s/%s", s.publicBaseUrl, key)
+}
+
+func (s *S3Store) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	signed, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, url.Values{})
+	if err != nil {
+		return "", errors.Wrap(err, "error presigning blob url")
+	}
+	return signed.String(), nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestS3Store runs against the S3 compatible object store at TEST_S3_ENDPOINT, e.g., a local MinIO
+// started with `docker run -p 9000:9000 minio/minio server /data`. It is skipped if TEST_S3_ENDPOINT is unset.
+// TEST_S3_ACCESS_KEY_ID and TEST_S3_SECRET_ACCESS_KEY default to MinIO's default credentials
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT not set")
+	}
+	cfg := S3Config{
+		Endpoint:        endpoint,
+		AccessKeyID:     envOr("TEST_S3_ACCESS_KEY_ID", "minioadmin"),
+		SecretAccessKey: envOr("TEST_S3_SECRET_ACCESS_KEY", "minioadmin"),
+		Bucket:          fmt.Sprintf("hrtnet-test-%d", time.Now().UnixNano()),
+		Region:          "us-east-1",
+		UseSSL:          os.Getenv("TEST_S3_USE_SSL") == "true",
+		PublicPrefixes:  []string{"announcements/"},
+	}
+	store, err := NewS3Store(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := store.client.RemoveBucket(context.Background(), cfg.Bucket); err != nil {
+			t.Logf("failed to remove test bucket %s: %v", cfg.Bucket, err)
+		}
+	})
+
+	// the bucket is reused when it already exists
+	if _, err := NewS3Store(cfg); err != nil {
+		t.Errorf("NewS3Store() with an existing bucket error = %v", err)
+	}
+
+	testBlobStore(t, store)
+
+	// presigned urls grant read access without credentials, until they expire
+	ctx := context.Background()
+	key := "incidence_reports/evidence/uid_1652000000/1.jpg"
+	if err := store.Put(ctx, key, strings.NewReader("evidence"), 8, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Delete(ctx, key)
+
+	signed, err := store.SignedURL(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := httpStatus(t, signed); status != http.StatusOK {
+		t.Errorf("GET of SignedURL() responded %d, want 200", status)
+	}
+	if status := httpStatus(t, store.URL(key)); status != http.StatusForbidden {
+		t.Errorf("GET of URL() of a private blob responded %d, want 403", status)
+	}
+
+	// blobs under the public prefixes are read without credentials
+	public := "announcements/1652000000.jpg"
+	if err := store.Put(ctx, public, strings.NewReader("announcement"), 12, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Delete(ctx, public)
+	if status := httpStatus(t, store.URL(public)); status != http.StatusOK {
+		t.Errorf("GET of URL() of a public blob responded %d, want 200", status)
+	}
+}
+
+func TestPublicReadPolicy(t *testing.T) {
+	var policy struct {
+		Statement []struct {
+			Effect    string
+			Principal map[string][]string
+			Action    []string
+			Resource  []string
+		}
+	}
+	if err := json.Unmarshal([]byte(publicReadPolicy("uploads", []string{"announcements/", "avatars/"})), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Statement) != 1 {
+		t.Fatalf("policy has %d statements, want 1", len(policy.Statement))
+	}
+	statement := policy.Statement[0]
+	want := []string{"arn:aws:s3:::uploads/announcements/*", "arn:aws:s3:::uploads/avatars/*"}
+	if statement.Effect != "Allow" || fmt.Sprint(statement.Action) != "[s3:GetObject]" ||
+		fmt.Sprint(statement.Principal["AWS"]) != "[*]" || fmt.Sprint(statement.Resource) != fmt.Sprint(want) {
+		t.Errorf("policy statement = %+v, want anyone allowed to get objects of %v", statement, want)
+	}
+}
+
+func TestNewS3StoreRequiresPublicAccess(t *testing.T) {
+	_, err := NewS3Store(S3Config{Endpoint: "127.0.0.1:1", Bucket: "uploads"})
+	if err != ErrNoPublicAccess {
+		t.Errorf("NewS3Store() without a public base url nor public prefixes error = %v, want %v", err, ErrNoPublicAccess)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func httpStatus(t *testing.T, url string) int {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
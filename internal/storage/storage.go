@@ -0,0 +1,104 @@
+// Package storage abstracts where uploaded files are kept.
+//
+// Files are addressed by keys, e.g., announcements/1652_promo.jpg, rather than by
+// paths or urls. Only keys are persisted in the database, urls are derived
+// from keys whenever a resource is served so that the storage backend or
+// the api domain can change without rewriting stored documents.
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("blob not found")
+	ErrInvalidKey       = errors.New("invalid blob key")
+	ErrInvalidSignature = errors.New("invalid or expired url signature")
+)
+
+// ObjectInfo describes a stored blob
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+
+	// ETag is an opaque identifier of the blob's content, without surrounding quotes
+	ETag string
+}
+
+// Object is an open blob. Object must be closed after use.
+type Object interface {
+	io.ReadSeekCloser
+}
+
+type BlobStore interface {
+
+	// Put stores size bytes read from data under key,
+	// replacing any blob previously stored under key
+	Put(ctx context.Context, key string, data io.Reader, size int64, contentType string) error
+
+	// Get opens the blob stored under key.
+	// Returns ErrNotFound if there's no such blob
+	Get(ctx context.Context, key string) (Object, *ObjectInfo, error)
+
+	// Delete removes the blob stored under key.
+	// Delete safely returns if there's no such blob
+	Delete(ctx context.Context, key string) error
+
+	// URL returns a permanent url for publicly readable blobs, e.g., announcement images
+	URL(key string) string
+
+	// SignedURL returns a url granting read access to key until expiry elapses.
+	// Private blobs, e.g., incidence report evidence, must only be exposed through SignedURL
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// Signer signs and verifies urls for blobs served by this api
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret string) *Signer {
+	return &Signer{secret: []byte(secret)}
+}
+
+// Sign returns the query parameters granting access to key until expiresAt
+func (s *Signer) Sign(key string, expiresAt time.Time) url.Values {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", s.signature(key, expires))
+	return query
+}
+
+// Verify checks that query carries a valid, unexpired signature for key.
+// Returns ErrInvalidSignature otherwise
+func (s *Signer) Verify(key string, query url.Values) error {
+	expires := query.Get("expires")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return ErrInvalidSignature
+	}
+
+	expected := s.signature(key, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *Signer) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(fmt.Sprintf("%s\n%s", key, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBlobStore checks the BlobStore contract every store must honour
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+	key := "incidence_reports/receipts/uid_1652000000.jpg"
+	content := []byte("not really a jpeg")
+
+	if _, _, err := store.Get(ctx, key); err != ErrNotFound {
+		t.Fatalf("Get() of a missing blob error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := store.Put(ctx, key, bytes.NewReader(content), int64(len(content)), "image/jpeg"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	object, info, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got, err := io.ReadAll(object)
+	object.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Get() read %q, %v, want %q", got, err, content)
+	}
+	if info.Key != key || info.Size != int64(len(content)) || info.ContentType != "image/jpeg" {
+		t.Errorf("Get() info = %+v, want key %s, size %d and type image/jpeg", info, key, len(content))
+	}
+	if info.ETag == "" || strings.Contains(info.ETag, `"`) || info.ModTime.IsZero() {
+		t.Errorf("Get() info = %+v, want an unquoted ETag and a modification time", info)
+	}
+
+	// blobs are seekable, which range requests rely on
+	object, _, err = store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if _, err := object.Seek(4, io.SeekStart); err != nil {
+		t.Errorf("Seek() error = %v", err)
+	}
+	got, _ = io.ReadAll(object)
+	object.Close()
+	if !bytes.Equal(got, content[4:]) {
+		t.Errorf("read %q after seeking, want %q", got, content[4:])
+	}
+
+	replaced := []byte("replaced")
+	if err := store.Put(ctx, key, bytes.NewReader(replaced), int64(len(replaced)), "image/jpeg"); err != nil {
+		t.Fatalf("Put() replacing a blob error = %v", err)
+	}
+	object, info, err = store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got, _ = io.ReadAll(object)
+	object.Close()
+	if !bytes.Equal(got, replaced) || info.Size != int64(len(replaced)) {
+		t.Errorf("Get() of a replaced blob read %q, want %q", got, replaced)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, _, err := store.Get(ctx, key); err != ErrNotFound {
+		t.Errorf("Get() of a deleted blob error = %v, want %v", err, ErrNotFound)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete() of a missing blob error = %v, want nil", err)
+	}
+
+	if got := store.URL(key); !strings.HasSuffix(got, "/"+key) {
+		t.Errorf("URL() = %s, want a url ending with the key", got)
+	}
+	signed, err := store.SignedURL(ctx, key, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL() error = %v", err)
+	}
+	parsed, err := url.Parse(signed)
+	if err != nil || !strings.HasSuffix(parsed.Path, "/"+key) || parsed.RawQuery == "" {
+		t.Errorf("SignedURL() = %s, want a signed url of the key", signed)
+	}
+}
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner("secret")
+	key := "incidence_reports/evidence/uid_1652000000/1.jpg"
+	query := signer.Sign(key, time.Now().Add(time.Minute))
+
+	if err := signer.Verify(key, query); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+
+	tampered := func(edit func(query url.Values)) url.Values {
+		copied := url.Values{}
+		for name, values := range query {
+			copied[name] = append([]string(nil), values...)
+		}
+		edit(copied)
+		return copied
+	}
+	tests := []struct {
+		name  string
+		key   string
+		query url.Values
+	}{
+		{"other key", "incidence_reports/evidence/uid_1652000000/2.jpg", query},
+		{"other secret", key, NewSigner("another secret").Sign(key, time.Now().Add(time.Minute))},
+		{"expired", key, signer.Sign(key, time.Now().Add(-time.Minute))},
+		{"extended expiry", key, tampered(func(q url.Values) { q.Set("expires", "99999999999") })},
+		{"no signature", key, tampered(func(q url.Values) { q.Del("signature") })},
+		{"no expiry", key, tampered(func(q url.Values) { q.Del("expires") })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := signer.Verify(tt.key, tt.query); err != ErrInvalidSignature {
+				t.Errorf("Verify() error = %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
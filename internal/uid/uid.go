@@ -0,0 +1,123 @@
+// Package uid generates the user ids (UIDs) HeartNet assigns to new users.
+//
+// Users type their UID back in, e.g., to restore their account on another device,
+// so the last character of a UID is a check digit computed with the Luhn mod N
+// algorithm over the generator's alphabet. It catches every mistyped character and
+// most swaps of adjacent characters before a lookup is made.
+package uid
+
+import (
+	"crypto/rand"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultAlphabet leaves out I, L, O and U, which are easily mistaken for 1, 0 and V
+	DefaultAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+	// DefaultLength is the number of random characters of a UID, the check digit excluded
+	DefaultLength = 7
+
+	minLength = 4
+)
+
+var (
+	ErrInvalidAlphabet = errors.New("uid alphabet must hold between 2 and 256 distinct ASCII characters")
+	ErrInvalidLength   = errors.Errorf("uid length must be at least %d", minLength)
+)
+
+// Generator generates UIDs of a fixed length over an alphabet
+type Generator struct {
+	alphabet string
+	length   int
+
+	// index maps the characters of alphabet to their position, -1 for other bytes
+	index [256]int
+}
+
+// New returns a Generator of UIDs made of length random characters of alphabet followed by a check digit
+func New(alphabet string, length int) (*Generator, error) {
+	if len(alphabet) < 2 || len(alphabet) > 256 {
+		return nil, ErrInvalidAlphabet
+	}
+	if length < minLength {
+		return nil, ErrInvalidLength
+	}
+
+	g := &Generator{alphabet: alphabet, length: length}
+	for i := range g.index {
+		g.index[i] = -1
+	}
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if c >= 0x80 || g.index[c] != -1 {
+			return nil, ErrInvalidAlphabet
+		}
+		g.index[c] = i
+	}
+	return g, nil
+}
+
+// Length returns the length of the UIDs g generates, check digit included
+func (g *Generator) Length() int {
+	return g.length + 1
+}
+
+// Generate returns a new random UID. Uniqueness is up to the caller, e.g., a unique index
+func (g *Generator) Generate() (string, error) {
+	n := len(g.alphabet)
+
+	// bytes from limit up are dropped so that every character is equally likely
+	limit := 256 - 256%n
+	id := make([]byte, 0, g.length+1)
+	buf := make([]byte, g.length*2)
+	for len(id) < g.length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", errors.Wrap(err, "failed to generate uid")
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(id) < g.length {
+				id = append(id, g.alphabet[int(b)%n])
+			}
+		}
+	}
+	return string(append(id, g.checkDigit(id))), nil
+}
+
+// Valid reports if id could have been generated by g, i.e., it has the right length,
+// is made of characters of the alphabet and its check digit matches
+func (g *Generator) Valid(id string) bool {
+	if len(id) != g.length+1 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if g.index[id[i]] == -1 {
+			return false
+		}
+	}
+	return g.luhnSum([]byte(id), false) == 0
+}
+
+// checkDigit returns the character that makes the Luhn mod N sum of id followed by it zero
+func (g *Generator) checkDigit(id []byte) byte {
+	n := len(g.alphabet)
+	return g.alphabet[(n-g.luhnSum(id, true))%n]
+}
+
+// luhnSum returns the Luhn mod N sum of id modulo the alphabet's length. Every other character,
+// starting from the last one if doubleLast is set, counts twice with its digits summed in base N
+func (g *Generator) luhnSum(id []byte, doubleLast bool) int {
+	n := len(g.alphabet)
+	sum := 0
+	double := doubleLast
+	for i := len(id) - 1; i >= 0; i-- {
+		addend := g.index[id[i]]
+		if double {
+			addend *= 2
+			addend = addend/n + addend%n
+		}
+		sum += addend
+		double = !double
+	}
+	return sum % n
+}
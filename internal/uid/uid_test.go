@@ -0,0 +1,106 @@
+package uid
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		alphabet string
+		length   int
+		err      error
+	}{
+		{"default", DefaultAlphabet, DefaultLength, nil},
+		{"single character alphabet", "A", DefaultLength, ErrInvalidAlphabet},
+		{"duplicate characters", "0123456789A1", DefaultLength, ErrInvalidAlphabet},
+		{"non ASCII alphabet", "0123456789é", DefaultLength, ErrInvalidAlphabet},
+		{"too short", DefaultAlphabet, minLength - 1, ErrInvalidLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.alphabet, tt.length); err != tt.err {
+				t.Errorf("New() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// Over the decimal alphabet, Luhn mod N is the Luhn algorithm of payment card numbers
+func TestCheckDigitDecimal(t *testing.T) {
+	g, err := New("0123456789", minLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id   string
+		want byte
+	}{
+		{"7992739871", '3'},
+		{"37828224631000", '5'},
+		{"401288888888188", '1'},
+	}
+	for _, tt := range tests {
+		if got := g.checkDigit([]byte(tt.id)); got != tt.want {
+			t.Errorf("checkDigit(%s) = %c, want %c", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	g, err := New(DefaultAlphabet, DefaultLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != g.Length() {
+			t.Fatalf("Generate() = %s, want %d characters", id, g.Length())
+		}
+		if strings.Trim(id, DefaultAlphabet) != "" {
+			t.Fatalf("Generate() = %s, want characters of %s", id, DefaultAlphabet)
+		}
+		if !g.Valid(id) {
+			t.Fatalf("Generate() = %s, which isn't Valid", id)
+		}
+		if seen[id] {
+			t.Fatalf("Generate() = %s twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestValidCatchesMistypes(t *testing.T) {
+	g, err := New(DefaultAlphabet, DefaultLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every substitution of a single character
+	for i := 0; i < len(id); i++ {
+		for j := 0; j < len(DefaultAlphabet); j++ {
+			if DefaultAlphabet[j] == id[i] {
+				continue
+			}
+			mistyped := id[:i] + string(DefaultAlphabet[j]) + id[i+1:]
+			if g.Valid(mistyped) {
+				t.Errorf("Valid(%s) = true for %s mistyped", mistyped, id)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"", id[1:], id + "0", strings.ToLower(id[:len(id)-1]) + "!", "IIIIIIII"} {
+		if g.Valid(invalid) {
+			t.Errorf("Valid(%q) = true", invalid)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/sha3"
+	"strings"
+)
+
+// evmAddressLength is the length in bytes of an EVM address
+const evmAddressLength = 20
+
+// evm is the format of Ethereum and EVM compatible chain addresses:
+// "0x" followed by 40 hex digits.
+// Mixed case addresses carry an EIP-55 checksum, which must match. All lowercase
+// or all uppercase addresses carry none and are accepted as is.
+// Addresses are normalised to their EIP-55 checksummed form
+type evm struct{}
+
+func (evm) Normalise(address string) (string, error) {
+	if !strings.HasPrefix(address, "0x") && !strings.HasPrefix(address, "0X") {
+		return "", fmt.Errorf("%w: must start with 0x", ErrInvalidAddress)
+	}
+	digits := address[2:]
+	if len(digits) != 2*evmAddressLength {
+		return "", fmt.Errorf("%w: must have %d hex digits after 0x", ErrInvalidAddress, 2*evmAddressLength)
+	}
+	if _, err := hex.DecodeString(digits); err != nil {
+		return "", fmt.Errorf("%w: must only contain hex digits after 0x", ErrInvalidAddress)
+	}
+
+	checksummed := ChecksumAddress(digits)
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && "0x"+digits != checksummed {
+		return "", ErrInvalidChecksum
+	}
+	return checksummed, nil
+}
+
+// ChecksumAddress returns the EIP-55 checksummed form of the EVM address whose hex digits,
+// without the 0x prefix, are digits. See https://eips.ethereum.org/EIPS/eip-55
+func ChecksumAddress(digits string) string {
+	lower := strings.ToLower(digits)
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte(lower))
+	sum := hash.Sum(nil)
+
+	result := []byte(lower)
+	for i, c := range result {
+		// letters are uppercased when the matching nibble of the hash is 8 or more
+		nibble := sum[i/2] >> 4
+		if i%2 == 1 {
+			nibble = sum[i/2] & 0x0f
+		}
+		if c >= 'a' && c <= 'f' && nibble >= 8 {
+			result[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(result)
+}
@@ -0,0 +1,37 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+)
+
+// checksummed addresses are the test cases of EIP-55
+func TestNormaliseEVM(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+		err     error
+	}{
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil},
+		{"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", nil},
+		{"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB", "0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB", nil},
+		{"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb", "0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb", nil},
+		{"0x52908400098527886E0F7030069857D2E4169EE7", "0x52908400098527886E0F7030069857D2E4169EE7", nil},
+		{"0x8617E340B3D01FA5F11F306F4090FD50E238070D", "0x8617E340B3D01FA5F11F306F4090FD50E238070D", nil},
+		{"0xde709f2102306220921060314715629080e2fb77", "0xde709f2102306220921060314715629080e2fb77", nil},
+		{"0x27b1fdb04752bbc536007a920d24acb045561c26", "0x27b1fdb04752bbc536007a920d24acb045561c26", nil},
+		{"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil},
+		{"0X5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil},
+		{" 0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed\n", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil},
+		{"0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "", ErrInvalidChecksum},
+		{"5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "", ErrInvalidAddress},
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA", "", ErrInvalidAddress},
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg", "", ErrInvalidAddress},
+	}
+	for _, tt := range tests {
+		got, err := Normalise(tt.address)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Normalise(%q) = %s, %v, want %s, %v", tt.address, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package wallet
+
+import "math/big"
+
+// curve is a short Weierstrass curve y² = x³ + b over the prime field p, i.e., with a = 0,
+// such as secp256k1, the curve EVM wallets sign with.
+// Only public key recovery is implemented, nothing here handles private keys
+// so constant time arithmetic isn't needed
+type curve struct {
+	p, n, b *big.Int
+	g       *point
+
+	// halfN is n / 2, the largest s of a low s signature, see RecoverPersonalSign
+	halfN *big.Int
+}
+
+// point is an affine point of a curve. The point at infinity is nil
+type point struct {
+	x, y *big.Int
+}
+
+var secp256k1 = &curve{
+	p: hexInt("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f"),
+	n: hexInt("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141"),
+	b: big.NewInt(7),
+	g: &point{
+		x: hexInt("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+		y: hexInt("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"),
+	},
+	halfN: hexInt("7fffffffffffffffffffffffffffffff5d576e7357a4501ddfe92f46681b20a0"),
+}
+
+func hexInt(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("wallet: invalid hex constant " + s)
+	}
+	return i
+}
+
+// recover returns the public key whose private key produced the ECDSA signature (r, s) of hash.
+// recoveryId selects the parity of the y coordinate of the signature's ephemeral point.
+// ok is false if the signature is invalid
+func (c *curve) recover(hash []byte, r, s *big.Int, recoveryId uint) (pub *point, ok bool) {
+	if r.Sign() <= 0 || r.Cmp(c.n) >= 0 || s.Sign() <= 0 || s.Cmp(c.n) >= 0 {
+		return nil, false
+	}
+
+	// the ephemeral point R has x coordinate r, r + n is left out since
+	// wallets only produce recovery ids 0 and 1
+	R, ok := c.decompress(r, recoveryId&1 == 1)
+	if !ok {
+		return nil, false
+	}
+
+	// pub = r⁻¹(sR - eG)
+	e := new(big.Int).SetBytes(hash)
+	e.Mod(e, c.n)
+	rInv := new(big.Int).ModInverse(r, c.n)
+	u1 := new(big.Int).Neg(e)
+	u1.Mul(u1, rInv).Mod(u1, c.n)
+	u2 := new(big.Int).Mul(s, rInv)
+	u2.Mod(u2, c.n)
+
+	pub = c.add(c.multiply(c.g, u1), c.multiply(R, u2))
+	return pub, pub != nil
+}
+
+// decompress returns the point with x coordinate x and a y coordinate of the given parity
+func (c *curve) decompress(x *big.Int, odd bool) (*point, bool) {
+	if x.Cmp(c.p) >= 0 {
+		return nil, false
+	}
+	ySquared := new(big.Int).Exp(x, big.NewInt(3), c.p)
+	ySquared.Add(ySquared, c.b).Mod(ySquared, c.p)
+	y := new(big.Int).ModSqrt(ySquared, c.p)
+	if y == nil {
+		return nil, false
+	}
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(c.p, y)
+	}
+	return &point{x: new(big.Int).Set(x), y: y}, true
+}
+
+// add returns a + b
+func (c *curve) add(a, b *point) *point {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.x.Cmp(b.x) == 0 {
+		if a.y.Cmp(b.y) != 0 || a.y.Sign() == 0 {
+			return nil
+		}
+		return c.double(a)
+	}
+
+	// λ = (y2 - y1) / (x2 - x1)
+	lambda := new(big.Int).Sub(b.y, a.y)
+	denominator := new(big.Int).Sub(b.x, a.x)
+	denominator.Mod(denominator, c.p).ModInverse(denominator, c.p)
+	lambda.Mul(lambda, denominator).Mod(lambda, c.p)
+	return c.pointFrom(lambda, a, b.x)
+}
+
+// double returns a + a
+func (c *curve) double(a *point) *point {
+	if a == nil || a.y.Sign() == 0 {
+		return nil
+	}
+
+	// λ = 3x² / 2y
+	lambda := new(big.Int).Mul(a.x, a.x)
+	lambda.Mul(lambda, big.NewInt(3))
+	denominator := new(big.Int).Lsh(a.y, 1)
+	denominator.ModInverse(denominator, c.p)
+	lambda.Mul(lambda, denominator).Mod(lambda, c.p)
+	return c.pointFrom(lambda, a, a.x)
+}
+
+// pointFrom completes an addition of a and a point with x coordinate x2 whose slope is lambda
+func (c *curve) pointFrom(lambda *big.Int, a *point, x2 *big.Int) *point {
+	x := new(big.Int).Mul(lambda, lambda)
+	x.Sub(x, a.x).Sub(x, x2).Mod(x, c.p)
+	y := new(big.Int).Sub(a.x, x)
+	y.Mul(y, lambda).Sub(y, a.y).Mod(y, c.p)
+	return &point{x: x, y: y}
+}
+
+// multiply returns k·a, by double-and-add
+func (c *curve) multiply(a *point, k *big.Int) *point {
+	var result *point
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		result = c.double(result)
+		if k.Bit(i) == 1 {
+			result = c.add(result, a)
+		}
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/sha3"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrSignerMismatch is returned when a valid signature was made by another wallet than expected
+	ErrSignerMismatch = errors.New("signature was not made by the wallet")
+)
+
+// signatureLength is the length in bytes of an EVM signature: r, s and the recovery id v
+const signatureLength = 65
+
+// VerifyPersonalSign checks that signature is an EIP-191 personal_sign signature of message,
+// as produced by wallets for eth_sign and personal_sign requests, made by the EVM wallet at address
+func VerifyPersonalSign(address, message, signature string) error {
+	address, err := NormaliseFor(Ethereum, address)
+	if err != nil {
+		return err
+	}
+	signer, err := RecoverPersonalSign(message, signature)
+	if err != nil {
+		return err
+	}
+	if signer != address {
+		return ErrSignerMismatch
+	}
+	return nil
+}
+
+// RecoverPersonalSign returns the checksummed address of the EVM wallet that made signature,
+// the 0x prefixed hex encoding of an EIP-191 personal_sign signature of message
+func RecoverPersonalSign(message, signature string) (string, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil || len(sig) != signatureLength {
+		return "", fmt.Errorf("%w: must be %d hex encoded bytes", ErrInvalidSignature, signatureLength)
+	}
+
+	// wallets set v to 27 or 28, some hardware wallets to 0 or 1
+	v := sig[64]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 {
+		return "", fmt.Errorf("%w: unknown recovery id", ErrInvalidSignature)
+	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:64])
+
+	// (r, n - s) is as valid as (r, s), wallets only produce the low s one (EIP-2)
+	// so that a signature can't be altered into another valid signature
+	if s.Cmp(secp256k1.halfN) > 0 {
+		return "", fmt.Errorf("%w: s must not exceed half the curve order", ErrInvalidSignature)
+	}
+	pub, ok := secp256k1.recover(personalSignHash(message), r, s, uint(v))
+	if !ok {
+		return "", ErrInvalidSignature
+	}
+	return publicKeyAddress(pub), nil
+}
+
+// personalSignHash is the hash EIP-191 personal_sign signatures of message sign
+func personalSignHash(message string) []byte {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte("\x19Ethereum Signed Message:\n" + strconv.Itoa(len(message)) + message))
+	return hash.Sum(nil)
+}
+
+// publicKeyAddress returns the checksummed EVM address of the wallet owning pub:
+// the last 20 bytes of the keccak-256 hash of the public key's coordinates
+func publicKeyAddress(pub *point) string {
+	key := make([]byte, 64)
+	pub.x.FillBytes(key[:32])
+	pub.y.FillBytes(key[32:])
+
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(key)
+	return ChecksumAddress(hex.EncodeToString(hash.Sum(nil)[32-evmAddressLength:]))
+}
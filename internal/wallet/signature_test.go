@@ -0,0 +1,223 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// sign returns the personal_sign signature of message by the private key d, with the ephemeral key k,
+// as a wallet would encode it: r, low s and v set to 27 or 28
+func sign(t *testing.T, d, k *big.Int, message string) []byte {
+	t.Helper()
+	c := secp256k1
+	R := c.multiply(c.g, k)
+	r := new(big.Int).Mod(R.x, c.n)
+	if r.Sign() == 0 || r.Cmp(R.x) != 0 {
+		t.Fatalf("ephemeral key %s can't be used", k)
+	}
+
+	// s = k⁻¹(e + rd)
+	e := new(big.Int).SetBytes(personalSignHash(message))
+	s := new(big.Int).Mul(r, d)
+	s.Add(s, e)
+	s.Mul(s, new(big.Int).ModInverse(k, c.n))
+	s.Mod(s, c.n)
+
+	v := byte(R.y.Bit(0))
+	if s.Cmp(c.halfN) > 0 {
+		s.Sub(c.n, s)
+		v ^= 1
+	}
+
+	sig := make([]byte, signatureLength)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:64])
+	sig[64] = 27 + v
+	return sig
+}
+
+func encode(sig []byte) string {
+	return "0x" + hex.EncodeToString(sig)
+}
+
+func TestHalfN(t *testing.T) {
+	if want := new(big.Int).Rsh(secp256k1.n, 1); secp256k1.halfN.Cmp(want) != 0 {
+		t.Errorf("halfN = %x, want %x", secp256k1.halfN, want)
+	}
+}
+
+func TestPublicKeyAddress(t *testing.T) {
+	tests := []struct {
+		privateKey string
+		want       string
+	}{
+		{"1", "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf"},
+		{"2", "0x2B5AD5c4795c026514f8317c7a215E218DcCD6cF"},
+		{"3", "0x6813Eb9362372EEF6200f3b1dbC3f819671cBA69"},
+
+		// the keys of the ethers.js, web3.js and eth-account documentation
+		{"0123456789012345678901234567890123456789012345678901234567890123", "0x14791697260E4c9A71f18484C9f997B308e59325"},
+		{"4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318", "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"},
+		{"b25c7db31feed9122727bf0939dc769a96564b2de4c4726d035b36ecf1e5b364", "0x5ce9454909639D2D17A3F753ce7d93fa0b9aB12E"},
+	}
+	for _, tt := range tests {
+		pub := secp256k1.multiply(secp256k1.g, hexInt(tt.privateKey))
+		if got := publicKeyAddress(pub); got != tt.want {
+			t.Errorf("publicKeyAddress(%s·G) = %s, want %s", tt.privateKey, got, tt.want)
+		}
+	}
+}
+
+func TestRecoverPersonalSign(t *testing.T) {
+	d := big.NewInt(1)
+	address := "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf"
+	message := "HeartNet wants you to link this wallet.\n\nNonce: 4f1c2b"
+
+	// ephemeral keys giving both recovery ids, and high s values before normalisation
+	for _, k := range []int64{2, 3, 5, 7, 11, 13} {
+		sig := sign(t, d, big.NewInt(k), message)
+		signer, err := RecoverPersonalSign(message, encode(sig))
+		if err != nil {
+			t.Fatalf("RecoverPersonalSign() with k = %d: %v", k, err)
+		}
+		if signer != address {
+			t.Errorf("RecoverPersonalSign() with k = %d = %s, want %s", k, signer, address)
+		}
+
+		// hardware wallets set v to 0 or 1
+		sig[64] -= 27
+		if signer, err := RecoverPersonalSign(message, encode(sig)); err != nil || signer != address {
+			t.Errorf("RecoverPersonalSign() with k = %d and v = %d = %s, %v, want %s", k, sig[64], signer, err, address)
+		}
+	}
+}
+
+// TestRecoverPersonalSignKnownAnswers checks signatures produced by wallet libraries rather than by sign,
+// so that the implementation isn't only checked against itself
+func TestRecoverPersonalSignKnownAnswers(t *testing.T) {
+	tests := []struct {
+		source    string
+		message   string
+		signature string
+		hash      string
+		signer    string
+	}{
+		{
+			// web3.js, web3.eth.accounts.sign, v = 28
+			source:    "web3.js",
+			message:   "Some data",
+			signature: "0xb91467e570a6466aa9e9876cbcd013baba02900b8979d43fe208a4a4f339f5fd6007e74cd82e037b800186422fc2da167c747ef045e5d18a5f5d4300f8e1a0291c",
+			hash:      "1da44b586eb0729ff70a73c326926f6ed5a25f5b056e7f47fbc6e58d86871655",
+			signer:    "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23",
+		},
+		{
+			// eth-account, Account.sign_message, v = 28
+			source:    "eth-account",
+			message:   "I♥SF",
+			signature: "0xe6ca9bba58c88611fad66a6ce8f996908195593807c4b38bd528d2cff09d4eb33e5bfbbf4d3e39b1a2fd816a7680c19ebebaf3a141b239934ad43cb33fcec8ce1c",
+			hash:      "1476abb745d423bf09273f1afd887d951181d25adc66c4834a70491911b7f750",
+			signer:    "0x5ce9454909639D2D17A3F753ce7d93fa0b9aB12E",
+		},
+		{
+			// MetaMask's eth-sig-util, personalSign, v = 27
+			source:    "eth-sig-util",
+			message:   "hello world",
+			signature: "0xce909e8ea6851bc36c007a0072d0524b07a3ff8d4e623aca4c71ca8e57250c4d0a3fc38fa8fbaaa81ead4b9f6bd03356b6f8bf18bccad167d78891636e1d69561b",
+			signer:    "0xbE93f9BacBcFFC8ee6663f2647917ed7A20a57BB",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			if tt.hash != "" {
+				if got := hex.EncodeToString(personalSignHash(tt.message)); got != tt.hash {
+					t.Errorf("personalSignHash(%q) = %s, want %s", tt.message, got, tt.hash)
+				}
+			}
+
+			signer, err := RecoverPersonalSign(tt.message, tt.signature)
+			if err != nil || signer != tt.signer {
+				t.Errorf("RecoverPersonalSign() = %s, %v, want %s", signer, err, tt.signer)
+			}
+
+			// hardware wallets set v to 0 or 1
+			sig, err := hex.DecodeString(tt.signature[2:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig[64] -= 27
+			if signer, err := RecoverPersonalSign(tt.message, encode(sig)); err != nil || signer != tt.signer {
+				t.Errorf("RecoverPersonalSign() with v = %d = %s, %v, want %s", sig[64], signer, err, tt.signer)
+			}
+		})
+	}
+}
+
+func TestVerifyPersonalSign(t *testing.T) {
+	message := "HeartNet wants you to link this wallet.\n\nNonce: 4f1c2b"
+	signature := encode(sign(t, big.NewInt(1), big.NewInt(7), message))
+
+	tests := []struct {
+		name      string
+		address   string
+		message   string
+		signature string
+		err       error
+	}{
+		{"signer", "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf", message, signature, nil},
+		{"lowercase signer", "0x7e5f4552091a69125d5dfcb7b8c2659029395bdf", message, signature, nil},
+		{"other wallet", "0x2B5AD5c4795c026514f8317c7a215E218DcCD6cF", message, signature, ErrSignerMismatch},
+		{"other message", "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf", message + ".", signature, ErrSignerMismatch},
+		{"invalid address", "0x7E5F", message, signature, ErrInvalidAddress},
+		{"truncated signature", "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf", message, signature[:len(signature)-2], ErrInvalidSignature},
+		{"not hex", "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf", message, "0x" + string(make([]byte, 130)), ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyPersonalSign(tt.address, tt.message, tt.signature)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("VerifyPersonalSign() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRecoverPersonalSignRejects(t *testing.T) {
+	message := "HeartNet wants you to link this wallet.\n\nNonce: 4f1c2b"
+	valid := sign(t, big.NewInt(1), big.NewInt(7), message)
+	with := func(edit func(sig []byte)) string {
+		sig := append([]byte(nil), valid...)
+		edit(sig)
+		return encode(sig)
+	}
+
+	tests := []struct {
+		name      string
+		signature string
+	}{
+		{"high s", with(func(sig []byte) {
+			// (r, n - s) with the other recovery id recovers the same key, but isn't what wallets produce
+			s := new(big.Int).SetBytes(sig[32:64])
+			new(big.Int).Sub(secp256k1.n, s).FillBytes(sig[32:64])
+			sig[64] ^= 1
+		})},
+		{"s = n", with(func(sig []byte) { secp256k1.n.FillBytes(sig[32:64]) })},
+		{"zero s", with(func(sig []byte) { copy(sig[32:64], make([]byte, 32)) })},
+		{"zero r", with(func(sig []byte) { copy(sig[:32], make([]byte, 32)) })},
+		{"r = n", with(func(sig []byte) { secp256k1.n.FillBytes(sig[:32]) })},
+		{"v = 2", with(func(sig []byte) { sig[64] = 2 })},
+		{"v = 26", with(func(sig []byte) { sig[64] = 26 })},
+		{"v = 29", with(func(sig []byte) { sig[64] = 29 })},
+		{"v = 37", with(func(sig []byte) { sig[64] = 37 })},
+		{"empty", "0x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := RecoverPersonalSign(message, tt.signature)
+			if !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("RecoverPersonalSign() = %s, %v, want %v", signer, err, ErrInvalidSignature)
+			}
+		})
+	}
+}
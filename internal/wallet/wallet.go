@@ -0,0 +1,68 @@
+// Package wallet validates and normalises the blockchain wallet addresses users
+// receive rewards on.
+//
+// Addresses are validated per Chain. EVM addresses, which are shared by Ethereum and
+// the EVM compatible chains HeartNet supports, are the default. Support for another
+// chain is added by registering its Format in formats.
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Chain identifies the blockchain an address belongs to
+type Chain string
+
+const (
+	Ethereum Chain = "ethereum"
+	BSC      Chain = "bsc"
+	Polygon  Chain = "polygon"
+
+	// DefaultChain is the chain addresses are validated against when none is specified
+	DefaultChain = Ethereum
+)
+
+var (
+	ErrInvalidAddress   = errors.New("invalid wallet address")
+	ErrInvalidChecksum  = errors.New("invalid wallet address: checksum mismatch, check the address for typos")
+	ErrUnsupportedChain = errors.New("unsupported chain")
+)
+
+// Format validates the addresses of a chain
+type Format interface {
+
+	// Normalise returns the canonical form of address, which is what gets stored,
+	// or an error wrapping ErrInvalidAddress or ErrInvalidChecksum if address is invalid.
+	// Errors are tested with errors.Is
+	Normalise(address string) (string, error)
+}
+
+// formats maps supported chains to the format of their addresses
+var formats = map[Chain]Format{
+	Ethereum: evm{},
+	BSC:      evm{},
+	Polygon:  evm{},
+}
+
+// Normalise validates address as an address of the default chain and returns its canonical form
+func Normalise(address string) (string, error) {
+	return NormaliseFor(DefaultChain, address)
+}
+
+// NormaliseFor validates address as an address of chain and returns its canonical form.
+// Surrounding whitespace is ignored
+func NormaliseFor(chain Chain, address string) (string, error) {
+	format, ok := formats[chain]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedChain, chain)
+	}
+	return format.Normalise(strings.TrimSpace(address))
+}
+
+// IsSupported reports if addresses of chain can be validated
+func IsSupported(chain Chain) bool {
+	_, ok := formats[chain]
+	return ok
+}
@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateDestination is returned when a webhook url resolves to an address that isn't
+// reachable from the internet, e.g., a loopback, private or link-local address.
+// Delivering to such addresses would let partners probe the network HeartNet runs in
+var ErrPrivateDestination = errors.New("webhook destination is not a public address")
+
+// nonPublicNetworks lists the ranges not covered by net.IP's predicates that aren't
+// reachable from the internet
+var nonPublicNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "this" network
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"), // benchmarking
+	mustParseCIDR("240.0.0.0/4"),   // reserved, including broadcast
+	mustParseCIDR("fec0::/10"),     // deprecated site-local
+
+	// IPv6 ranges that embed IPv4 addresses, which could be private
+	mustParseCIDR("64:ff9b::/96"), // IPv4/IPv6 translation
+	mustParseCIDR("2001::/32"),    // Teredo
+	mustParseCIDR("2002::/16"),    // 6to4
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// IsPublicIP reports if ip is reachable from the internet, i.e., isn't a loopback, private,
+// link-local, multicast or otherwise reserved address
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckDestination resolves host, the host of a webhook url without its port, and returns
+// ErrPrivateDestination if any of its addresses isn't public, see IsPublicIP.
+// Deliveries are checked again when they are made, since DNS records can change after a webhook is registered
+func CheckDestination(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !IsPublicIP(ip) {
+			return ErrPrivateDestination
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve webhook host %s", host)
+	}
+	for _, addr := range addrs {
+		if !IsPublicIP(addr.IP) {
+			return ErrPrivateDestination
+		}
+	}
+	return nil
+}
+
+// NewClient returns the http.Client deliveries are made with. It only connects to public addresses,
+// checking the address a host resolved to right before connecting so that a DNS record changed
+// after registration can't point a webhook at a private address, and doesn't follow redirects,
+// which are recorded as failed attempts
+func NewClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return errors.Wrap(err, "invalid webhook address")
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return errors.Wrapf(ErrPrivateDestination, "refusing to connect to %s", host)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   requestTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{
+		Timeout:   requestTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"93.184.216.34", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"64:ff9b::a00:1", false},
+		{"2002:a00:1::", false},
+	}
+	for _, tt := range tests {
+		if got := IsPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("IsPublicIP(%s) = %t, want %t", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDestination(t *testing.T) {
+	ctx := context.Background()
+	for _, host := range []string{"127.0.0.1", "::1", "169.254.169.254", "10.1.2.3", "localhost"} {
+		if err := CheckDestination(ctx, host); !errors.Is(err, ErrPrivateDestination) {
+			t.Errorf("CheckDestination(%s) = %v, want ErrPrivateDestination", host, err)
+		}
+	}
+	if err := CheckDestination(ctx, "8.8.8.8"); err != nil {
+		t.Errorf("CheckDestination(8.8.8.8) = %v, want nil", err)
+	}
+}
+
+func TestNewClientRefusesPrivateDestinations(t *testing.T) {
+	server, requests := newReceiver(t, http.StatusNoContent)
+
+	_, err := NewClient().Get(server.URL)
+	if !errors.Is(err, ErrPrivateDestination) {
+		t.Fatalf("Get(%s) = %v, want ErrPrivateDestination", server.URL, err)
+	}
+	select {
+	case <-requests:
+		t.Error("the loopback receiver was reached")
+	default:
+	}
+}
+
+func TestNewClientDoesNotFollowRedirects(t *testing.T) {
+	target, requests := newReceiver(t, http.StatusNoContent)
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer redirect.Close()
+
+	// the redirect policy is tested on its own, since the receivers listen on loopback addresses
+	client := NewClient()
+	client.Transport = redirect.Client().Transport
+	res, err := client.Get(redirect.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Errorf("status = %d, want the redirect's %d", res.StatusCode, http.StatusFound)
+	}
+	select {
+	case <-requests:
+		t.Error("the redirect was followed")
+	default:
+	}
+}
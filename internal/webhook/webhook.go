@@ -0,0 +1,304 @@
+// Package webhook delivers signed events to the webhooks partners registered.
+//
+// Events are persisted as one delivery per subscribed webhook before any request is made,
+// so that deliveries survive restarts. A Dispatcher then sends due deliveries, retrying
+// failed ones with exponential backoff until MaxAttempts is reached. Every attempt is
+// logged on the delivery. Deliveries are only made to public addresses and don't follow
+// redirects, see NewClient.
+//
+// Each request carries the headers
+//
+//	X-HeartNet-Event: the event type
+//	X-HeartNet-Delivery: the delivery id, identical across retries
+//	X-HeartNet-Signature: t=<unix time>,v1=<hex hmac-sha256 of "<unix time>.<body>" keyed with the webhook secret>
+//
+// Receivers should recompute the signature, compare it in constant time and reject
+// stale timestamps to prevent replay attacks.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxAttempts is the number of attempts after which a delivery is marked as failed
+	MaxAttempts = 8
+
+	// requestTimeout bounds a single delivery attempt
+	requestTimeout = 10 * time.Second
+
+	// lease is how long a claimed delivery is hidden from other dispatchers while it is attempted
+	lease = 2 * requestTimeout
+
+	// pollInterval is how often due retries are looked for
+	pollInterval = 15 * time.Second
+
+	// concurrency caps the deliveries attempted at the same time
+	concurrency = 4
+
+	firstRetryDelay = 30 * time.Second
+	maxRetryDelay   = 6 * time.Hour
+)
+
+// Repo persists webhooks and their deliveries
+type Repo interface {
+
+	// FetchWebhooksByEvent fetches every webhook subscribed to eventType
+	FetchWebhooksByEvent(eventType model.WebhookEventType) (*[]model.Webhook, error)
+
+	FetchWebhook(id primitive.ObjectID) (*model.Webhook, error)
+
+	InsertWebhookDeliveries(deliveries []model.WebhookDelivery) error
+
+	// ClaimDueWebhookDelivery fetches a pending delivery due at now and postpones it by lease,
+	// so that it isn't claimed again while it is attempted. Returns nil if no delivery is due
+	ClaimDueWebhookDelivery(now time.Time, lease time.Duration) (*model.WebhookDelivery, error)
+
+	// RecordWebhookDeliveryAttempt logs attempt on the delivery identified by id
+	// and moves it to status. nextAttemptAt is only relevant to pending deliveries
+	RecordWebhookDeliveryAttempt(id primitive.ObjectID, attempt model.DeliveryAttempt,
+		status model.DeliveryStatus, nextAttemptAt time.Time) error
+}
+
+// Dispatcher publishes events and delivers them to webhooks
+type Dispatcher struct {
+	repo   Repo
+	client *http.Client
+
+	// wake signals Run that new deliveries are due
+	wake chan struct{}
+
+	// publishing tracks the events published by PublishAsync that aren't recorded yet
+	publishing sync.WaitGroup
+}
+
+// NewDispatcher returns a Dispatcher delivering with client, or with the client returned by NewClient if client is nil
+func NewDispatcher(repo Repo, client *http.Client) *Dispatcher {
+	if client == nil {
+		client = NewClient()
+	}
+	return &Dispatcher{
+		repo:   repo,
+		client: client,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Publish records a delivery of an event of type eventType, carrying data,
+// for every webhook subscribed to eventType
+func (d *Dispatcher) Publish(eventType model.WebhookEventType, data interface{}) error {
+	webhooks, err := d.repo.FetchWebhooksByEvent(eventType)
+	if err != nil {
+		return err
+	}
+	if len(*webhooks) == 0 {
+		return nil
+	}
+
+	event := model.WebhookEvent{
+		ID:        primitive.NewObjectID(),
+		Type:      eventType,
+		CreatedOn: time.Now(),
+		Data:      data,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "error encoding webhook event")
+	}
+
+	deliveries := make([]model.WebhookDelivery, 0, len(*webhooks))
+	for _, webhook := range *webhooks {
+		deliveries = append(deliveries, model.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			PartnerID:     webhook.PartnerID,
+			EventID:       event.ID,
+			EventType:     eventType,
+			Payload:       string(payload),
+			Status:        model.DeliveryPending,
+			NextAttemptAt: event.CreatedOn,
+			Attempts:      []model.DeliveryAttempt{},
+			CreatedOn:     event.CreatedOn,
+		})
+	}
+	if err := d.repo.InsertWebhookDeliveries(deliveries); err != nil {
+		return err
+	}
+	d.notify()
+	return nil
+}
+
+// PublishAsync publishes the event in the background, logging failures
+func (d *Dispatcher) PublishAsync(eventType model.WebhookEventType, data interface{}) {
+	d.publishing.Add(1)
+	go func() {
+		defer d.publishing.Done()
+		if err := d.Publish(eventType, data); err != nil {
+			logger.Logger.LogError(fmt.Sprintf("failed to publish %s webhook event", eventType), "publish webhook event", err)
+		}
+	}()
+}
+
+// Replay records a new delivery of the payload of delivery to the same webhook
+func (d *Dispatcher) Replay(delivery *model.WebhookDelivery) (*model.WebhookDelivery, error) {
+	now := time.Now()
+	replay := model.WebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		PartnerID:     delivery.PartnerID,
+		EventID:       delivery.EventID,
+		EventType:     delivery.EventType,
+		Payload:       delivery.Payload,
+		Status:        model.DeliveryPending,
+		NextAttemptAt: now,
+		Attempts:      []model.DeliveryAttempt{},
+		ReplayOf:      &delivery.ID,
+		CreatedOn:     now,
+	}
+	deliveries := []model.WebhookDelivery{replay}
+	if err := d.repo.InsertWebhookDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+	d.notify()
+	return &deliveries[0], nil
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due deliveries until ctx is done,
+// then waits for the events being published and the attempts in flight to complete
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+	defer d.publishing.Wait()
+	slots := make(chan struct{}, concurrency)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		for ctx.Err() == nil {
+			delivery, err := d.repo.ClaimDueWebhookDelivery(time.Now(), lease)
+			if err != nil {
+				logger.Logger.LogError("failed to claim webhook delivery", "run webhook dispatcher", err)
+				break
+			}
+			if delivery == nil {
+				break
+			}
+
+			slots <- struct{}{}
+			inFlight.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					inFlight.Done()
+				}()
+				d.attempt(delivery)
+			}()
+		}
+	}
+}
+
+// attempt sends delivery once and records the outcome
+func (d *Dispatcher) attempt(delivery *model.WebhookDelivery) {
+	start := time.Now()
+	attempt := model.DeliveryAttempt{At: start}
+
+	webhook, err := d.repo.FetchWebhook(delivery.WebhookID)
+	if err == nil {
+		attempt.StatusCode, err = d.send(webhook, delivery)
+	}
+	attempt.DurationMs = time.Since(start).Milliseconds()
+
+	status := model.DeliveryDelivered
+	nextAttemptAt := time.Time{}
+	if err != nil {
+		attempt.Error = err.Error()
+		status = model.DeliveryPending
+		nextAttemptAt = time.Now().Add(retryDelay(len(delivery.Attempts) + 1))
+		if len(delivery.Attempts)+1 >= MaxAttempts {
+			status = model.DeliveryFailed
+		}
+	}
+
+	if err := d.repo.RecordWebhookDeliveryAttempt(delivery.ID, attempt, status, nextAttemptAt); err != nil {
+		logger.Logger.LogError("failed to record webhook delivery attempt", "attempt webhook delivery", err)
+	}
+}
+
+// send posts the delivery payload to webhook.
+// Any response other than 2xx is an error
+func (d *Dispatcher) send(webhook *model.Webhook, delivery *model.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "HeartNet-Webhooks/1.0")
+	req.Header.Set("X-HeartNet-Event", string(delivery.EventType))
+	req.Header.Set("X-HeartNet-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-HeartNet-Signature", Sign(webhook.Secret, time.Now().Unix(), payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// the body is drained so that the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// Sign returns the X-HeartNet-Signature header value of payload sent at timestamp
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// retryDelay is the delay before the retry following the given number of failed attempts.
+// It doubles with every attempt, from firstRetryDelay up to maxRetryDelay
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,347 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"github.com/Hrtnet/social-activities/internal/logger"
+	"github.com/Hrtnet/social-activities/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = logger.NewLogger(false)
+	os.Exit(m.Run())
+}
+
+// memoryRepo is an in-memory Repo
+type memoryRepo struct {
+	mu         sync.Mutex
+	webhooks   []model.Webhook
+	deliveries []*model.WebhookDelivery
+
+	// recorded receives the ids of the deliveries whose attempts are recorded
+	recorded chan primitive.ObjectID
+}
+
+func newMemoryRepo(webhooks ...model.Webhook) *memoryRepo {
+	return &memoryRepo{webhooks: webhooks, recorded: make(chan primitive.ObjectID, 16)}
+}
+
+func (m *memoryRepo) FetchWebhooksByEvent(eventType model.WebhookEventType) (*[]model.Webhook, error) {
+	webhooks := make([]model.Webhook, 0)
+	for _, webhook := range m.webhooks {
+		if webhook.Subscribes(eventType) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return &webhooks, nil
+}
+
+func (m *memoryRepo) FetchWebhook(id primitive.ObjectID) (*model.Webhook, error) {
+	for _, webhook := range m.webhooks {
+		if webhook.ID == id {
+			return &webhook, nil
+		}
+	}
+	return nil, fmt.Errorf("webhook %s not found", id.Hex())
+}
+
+func (m *memoryRepo) InsertWebhookDeliveries(deliveries []model.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range deliveries {
+		deliveries[i].ID = primitive.NewObjectID()
+		delivery := deliveries[i]
+		m.deliveries = append(m.deliveries, &delivery)
+	}
+	return nil
+}
+
+func (m *memoryRepo) ClaimDueWebhookDelivery(now time.Time, lease time.Duration) (*model.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, delivery := range m.deliveries {
+		if delivery.Status == model.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			delivery.NextAttemptAt = now.Add(lease)
+			claimed := *delivery
+			return &claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryRepo) RecordWebhookDeliveryAttempt(id primitive.ObjectID, attempt model.DeliveryAttempt,
+	status model.DeliveryStatus, nextAttemptAt time.Time) error {
+
+	m.mu.Lock()
+	for _, delivery := range m.deliveries {
+		if delivery.ID == id {
+			delivery.Attempts = append(delivery.Attempts, attempt)
+			delivery.Status = status
+			delivery.NextAttemptAt = nextAttemptAt
+		}
+	}
+	m.mu.Unlock()
+	m.recorded <- id
+	return nil
+}
+
+func (m *memoryRepo) delivery(id primitive.ObjectID) model.WebhookDelivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, delivery := range m.deliveries {
+		if delivery.ID == id {
+			return *delivery
+		}
+	}
+	return model.WebhookDelivery{}
+}
+
+// received is a request received by a test receiver
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver starts a webhook receiver responding with statusCode, which sends the requests it receives on requests
+func newReceiver(t *testing.T, statusCode int) (*httptest.Server, chan received) {
+	requests := make(chan received, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.Header, body}
+		w.WriteHeader(statusCode)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func newWebhook(url string) model.Webhook {
+	return model.Webhook{
+		ID:        primitive.NewObjectID(),
+		PartnerID: primitive.NewObjectID(),
+		URL:       url,
+		Secret:    "whsec_test",
+		Events:    []model.WebhookEventType{model.EventIncidenceReportCreated},
+	}
+}
+
+// verifySignature checks the X-HeartNet-Signature header of a request as receivers are told to
+func verifySignature(t *testing.T, secret string, request received) {
+	t.Helper()
+	header := request.header.Get("X-HeartNet-Signature")
+	parts := strings.SplitN(header, ",", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") {
+		t.Fatalf("X-HeartNet-Signature = %q, want t=<unix time>,v1=<hmac>", header)
+	}
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(parts[0], "t="), 10, 64)
+	if err != nil {
+		t.Fatalf("X-HeartNet-Signature timestamp: %v", err)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age < -time.Minute || age > time.Minute {
+		t.Errorf("X-HeartNet-Signature timestamp is %s old", age)
+	}
+	if !hmac.Equal([]byte(header), []byte(Sign(secret, timestamp, request.body))) {
+		t.Errorf("X-HeartNet-Signature = %q, doesn't match the body", header)
+	}
+	if Sign("another secret", timestamp, request.body) == header {
+		t.Errorf("X-HeartNet-Signature doesn't depend on the secret")
+	}
+}
+
+func receive(t *testing.T, requests chan received) received {
+	t.Helper()
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+	return received{}
+}
+
+func waitRecorded(t *testing.T, repo *memoryRepo) primitive.ObjectID {
+	t.Helper()
+	select {
+	case id := <-repo.recorded:
+		return id
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery attempt not recorded")
+	}
+	return primitive.NilObjectID
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	server, requests := newReceiver(t, http.StatusNoContent)
+	webhook := newWebhook(server.URL)
+	unsubscribed := newWebhook(server.URL)
+	unsubscribed.Events = []model.WebhookEventType{model.EventRecallIssued}
+	repo := newMemoryRepo(webhook, unsubscribed)
+
+	d := NewDispatcher(repo, server.Client())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if err := d.Publish(model.EventIncidenceReportCreated, map[string]string{"id": "report"}); err != nil {
+		t.Fatal(err)
+	}
+	request := receive(t, requests)
+	id := waitRecorded(t, repo)
+
+	delivery := repo.delivery(id)
+	if delivery.WebhookID != webhook.ID {
+		t.Errorf("delivered to webhook %s, want %s", delivery.WebhookID.Hex(), webhook.ID.Hex())
+	}
+	if got := request.header.Get("X-HeartNet-Event"); got != string(model.EventIncidenceReportCreated) {
+		t.Errorf("X-HeartNet-Event = %s, want %s", got, model.EventIncidenceReportCreated)
+	}
+	if got := request.header.Get("X-HeartNet-Delivery"); got != id.Hex() {
+		t.Errorf("X-HeartNet-Delivery = %s, want %s", got, id.Hex())
+	}
+	if string(request.body) != delivery.Payload {
+		t.Errorf("body = %s, want the delivery payload %s", request.body, delivery.Payload)
+	}
+	verifySignature(t, webhook.Secret, request)
+
+	if delivery.Status != model.DeliveryDelivered || len(delivery.Attempts) != 1 ||
+		delivery.Attempts[0].StatusCode != http.StatusNoContent {
+		t.Errorf("delivery = %s after %+v, want delivered after a single 204", delivery.Status, delivery.Attempts)
+	}
+	if len(repo.deliveries) != 1 {
+		t.Errorf("%d deliveries recorded, want 1 for the subscribed webhook", len(repo.deliveries))
+	}
+}
+
+func TestDispatcherRetriesServerErrors(t *testing.T) {
+	server, requests := newReceiver(t, http.StatusServiceUnavailable)
+	webhook := newWebhook(server.URL)
+	repo := newMemoryRepo(webhook)
+	d := NewDispatcher(repo, server.Client())
+
+	if err := d.Publish(model.EventIncidenceReportCreated, map[string]string{"id": "report"}); err != nil {
+		t.Fatal(err)
+	}
+	id := repo.deliveries[0].ID
+
+	// each failed attempt doubles the delay before the next one
+	want := firstRetryDelay
+	for attempts := 1; attempts < MaxAttempts; attempts++ {
+		delivery, err := repo.ClaimDueWebhookDelivery(time.Now().Add(maxRetryDelay), lease)
+		if err != nil || delivery == nil {
+			t.Fatalf("attempt %d: delivery not due: %v", attempts, err)
+		}
+		before := time.Now()
+		d.attempt(delivery)
+		receive(t, requests)
+		waitRecorded(t, repo)
+
+		got := repo.delivery(id)
+		if got.Status != model.DeliveryPending {
+			t.Fatalf("attempt %d: delivery = %s, want pending", attempts, got.Status)
+		}
+		last := got.Attempts[len(got.Attempts)-1]
+		if last.StatusCode != http.StatusServiceUnavailable || last.Error == "" {
+			t.Errorf("attempt %d = %+v, want a 503 error", attempts, last)
+		}
+		if delay := got.NextAttemptAt.Sub(before); delay < want || delay > want+time.Second {
+			t.Errorf("attempt %d: next attempt in %s, want %s", attempts, delay, want)
+		}
+		if want *= 2; want > maxRetryDelay {
+			want = maxRetryDelay
+		}
+	}
+
+	delivery, _ := repo.ClaimDueWebhookDelivery(time.Now().Add(maxRetryDelay), lease)
+	d.attempt(delivery)
+	receive(t, requests)
+	waitRecorded(t, repo)
+	if got := repo.delivery(id); got.Status != model.DeliveryFailed || len(got.Attempts) != MaxAttempts {
+		t.Errorf("delivery = %s after %d attempts, want failed after %d", got.Status, len(got.Attempts), MaxAttempts)
+	}
+}
+
+func TestDispatcherReplay(t *testing.T) {
+	server, requests := newReceiver(t, http.StatusOK)
+	webhook := newWebhook(server.URL)
+	repo := newMemoryRepo(webhook)
+	d := NewDispatcher(repo, server.Client())
+
+	original := model.WebhookDelivery{
+		ID:        primitive.NewObjectID(),
+		WebhookID: webhook.ID,
+		PartnerID: webhook.PartnerID,
+		EventID:   primitive.NewObjectID(),
+		EventType: model.EventIncidenceReportCreated,
+		Payload:   `{"id":"event","type":"incidence_report.created"}`,
+		Status:    model.DeliveryFailed,
+		Attempts:  make([]model.DeliveryAttempt, MaxAttempts),
+	}
+	repo.deliveries = append(repo.deliveries, &original)
+
+	replay, err := d.Replay(&original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.ID == original.ID || replay.ReplayOf == nil || *replay.ReplayOf != original.ID {
+		t.Errorf("replay %s of %v, want a new delivery replaying %s", replay.ID.Hex(), replay.ReplayOf, original.ID.Hex())
+	}
+	if replay.Status != model.DeliveryPending || len(replay.Attempts) != 0 || replay.EventID != original.EventID {
+		t.Errorf("replay = %+v, want a pending delivery of the same event without attempts", replay)
+	}
+
+	delivery, err := repo.ClaimDueWebhookDelivery(time.Now(), lease)
+	if err != nil || delivery == nil || delivery.ID != replay.ID {
+		t.Fatalf("claimed %v, %v, want the replay", delivery, err)
+	}
+	d.attempt(delivery)
+	request := receive(t, requests)
+	waitRecorded(t, repo)
+
+	if got := request.header.Get("X-HeartNet-Delivery"); got != replay.ID.Hex() {
+		t.Errorf("X-HeartNet-Delivery = %s, want the replay id %s", got, replay.ID.Hex())
+	}
+	if string(request.body) != original.Payload {
+		t.Errorf("body = %s, want the original payload %s", request.body, original.Payload)
+	}
+	verifySignature(t, webhook.Secret, request)
+	if got := repo.delivery(original.ID); got.Status != model.DeliveryFailed || len(got.Attempts) != MaxAttempts {
+		t.Errorf("original delivery = %s after %d attempts, want it untouched", got.Status, len(got.Attempts))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{10, 4*time.Hour + 16*time.Minute},
+		{11, maxRetryDelay},
+		{100, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}